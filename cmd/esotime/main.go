@@ -1,72 +1,329 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"golang.org/x/exp/slog"
 
 	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elrpc/runtime/sandbox"
 	"github.com/genkami/elsi/elsi/api/exp"
 	"github.com/genkami/elsi/elsi/impl/expimpl"
+	_ "github.com/genkami/elsi/elsi/interp/befunge"
+	_ "github.com/genkami/elsi/elsi/interp/brainfuck"
+	_ "github.com/genkami/elsi/elsi/interp/whitespace"
+	"github.com/genkami/elsi/elsi/lang"
+	"github.com/genkami/elsi/elsi/link"
+	"github.com/genkami/elsi/elsi/pool"
+	_ "github.com/genkami/elsi/elsi/wasm"
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: esotime run CMD...\n")
+	fmt.Fprintf(os.Stderr, "       esotime run SOURCE_FILE ARGS...\n")
+	fmt.Fprintf(os.Stderr, "       esotime run-fd CMD...\n")
+	fmt.Fprintf(os.Stderr, "       esotime attach unix PATH\n")
+	fmt.Fprintf(os.Stderr, "       esotime attach tcp ADDR:PORT\n")
+	os.Exit(1)
+}
+
+// EnvLanguages is the environment variable that points to a JSON file of lang.CommandConfig,
+// which configures external commands that run source files.
+const EnvLanguages = "ESOTIME_LANGUAGES"
+
+func loadLanguages() {
+	path := os.Getenv(EnvLanguages)
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	err = lang.Default.LoadConfig(f)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// EnvSandbox is the environment variable that points to a JSON file of sandbox.Config, whose durations are in nanoseconds.
+// If it is set, the guests that esotime runs as processes are sandboxed, including the commands of ESOTIME_LANGUAGES.
+const EnvSandbox = "ESOTIME_SANDBOX"
+
+func loadSandbox() []runtime.ProcessOption {
+	path := os.Getenv(EnvSandbox)
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	conf := &sandbox.Config{}
+	err = json.NewDecoder(f).Decode(conf)
+	if err != nil {
+		panic(err)
+	}
+	return []runtime.ProcessOption{runtime.WithSandbox(conf)}
+}
+
+// EnvRestart is the environment variable that selects the restart policy of the guest: never (default), on-failure or always.
+const EnvRestart = "ESOTIME_RESTART"
+
+// EnvPoolSize is the environment variable that sets the number of guests that run the same program.
+const EnvPoolSize = "ESOTIME_POOL_SIZE"
+
+func loadPoolConfig() *pool.Config {
+	conf := &pool.Config{
+		Size:          1,
+		Restart:       pool.RestartNever,
+		Backoff:       100 * time.Millisecond,
+		MaxRestarts:   5,
+		RestartWindow: time.Minute,
+		WaitTimeout:   10 * time.Second,
+		HTTPListeners: map[string]expimpl.HttpListenerConfig{
+			"default": {
+				AddrAndPort: ":8080",
+			},
+		},
+	}
+	if s := os.Getenv(EnvRestart); s != "" {
+		restart, err := pool.ParseRestartPolicy(s)
+		if err != nil {
+			panic(err)
+		}
+		conf.Restart = restart
+	}
+	if s := os.Getenv(EnvPoolSize); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil {
+			panic(err)
+		}
+		conf.Size = size
+	}
+	return conf
+}
+
+// EnvLinks is the environment variable that points to a JSON file of linkConfig.
+// The guests in the file run alongside the main guest, and serve the main guest's imports with their exports.
+const EnvLinks = "ESOTIME_LINKS"
+
+// linkConfig is a guest whose exported methods are linked to the main guest.
+type linkConfig struct {
+	// The source file or the command to run, followed by its arguments, as in `esotime run`.
+	Run   []string     `json:"run"`
+	Links []*link.Link `json:"links"`
+}
+
+func loadLinks() []*linkConfig {
+	path := os.Getenv(EnvLinks)
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	var confs []*linkConfig
+	err = json.NewDecoder(f).Decode(&confs)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range confs {
+		if len(c.Run) == 0 {
+			panic(fmt.Errorf("%s: linked guest has nothing to run", path))
+		}
+	}
+	return confs
+}
+
+// runGuest returns a function that creates a guest running the source file or the command at path.
+// opts apply to the guest if it runs as a process.
+func runGuest(path string, args []string, opts []runtime.ProcessOption) func() (runtime.Guest, error) {
+	return func() (runtime.Guest, error) {
+		g, err := lang.Open(path, args, opts...)
+		if errors.Is(err, lang.ErrUnknownLanguage) {
+			return runtime.NewProcessGuestWithOptions(path, args, opts...)
+		}
+		return g, err
+	}
+}
+
+// checkLinks fails if the links collide with each other or with the modules that newRuntime provides.
+// Every member's runtime has the same modules, so they are checked once before any guest is created.
+func checkLinks(logger *slog.Logger, links []*linkConfig) error {
+	rt := newRuntime(logger, nil, nil)
+	for _, lc := range links {
+		for _, l := range lc.Links {
+			err := link.Use(rt, nil, l)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newRuntime creates a runtime that provides exp to the guest.
+func newRuntime(logger *slog.Logger, guest runtime.Guest, httpListeners map[string]expimpl.HttpListenerConfig) *runtime.Runtime {
+	rt := runtime.NewRuntime(logger, guest)
+	hs := expimpl.NewHandleSet()
+	exp.UseWorld(rt, &exp.Imports{
+		Stdio: expimpl.NewStdio(hs, map[uint8]expimpl.StdHandleCtor{
+			exp.HandleTypeStdin: func() (any, error) {
+				return os.Stdin, nil
+			},
+			exp.HandleTypeStdout: func() (any, error) {
+				return os.Stdout, nil
+			},
+			exp.HandleTypeStderr: func() (any, error) {
+				return os.Stderr, nil
+			},
+		}),
+		Stream: expimpl.NewStream(hs),
+		File:   expimpl.NewFile(hs),
+		HTTP:   expimpl.NewHTTP(logger, hs, httpListeners),
+	})
+	return rt
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "esotime: %v\n", err)
 	os.Exit(1)
 }
 
 func main() {
+	// Sandboxed guests are started by re-executing esotime.
+	sandbox.Init()
+
 	args := os.Args
 	if len(args) < 3 {
 		usage()
 	}
-	if args[1] != "run" {
-		usage()
-	}
 
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
-	guest := runtime.NewProcessGuest(args[2], args[3:]...)
-	rt := runtime.NewRuntime(logger, guest)
+	loadLanguages()
+	sandboxOpts := loadSandbox()
+	// The guest is created again whenever it is restarted.
+	var newGuest func() (runtime.Guest, error)
+	switch args[1] {
+	case "run":
+		newGuest = runGuest(args[2], args[3:], sandboxOpts)
+	case "run-fd":
+		opts := append([]runtime.ProcessOption{runtime.WithRPCFDs(), runtime.WithStdio(os.Stdin, os.Stdout)}, sandboxOpts...)
+		newGuest = func() (runtime.Guest, error) {
+			return runtime.NewProcessGuestWithOptions(args[2], args[3:], opts...)
+		}
+	case "attach":
+		if len(args) != 4 {
+			usage()
+		}
+		var listen func(string) (*runtime.SocketGuest, error)
+		switch args[2] {
+		case "unix":
+			listen = runtime.ListenUnix
+		case "tcp":
+			listen = runtime.ListenTCP
+		default:
+			usage()
+		}
+		newGuest = func() (runtime.Guest, error) {
+			sg, err := listen(args[3])
+			if err != nil {
+				return nil, err
+			}
+			logger.Info("waiting for guest", slog.String("addr", sg.Addr().String()))
+			return sg, nil
+		}
+	default:
+		usage()
+	}
 
-	hs := expimpl.NewHandleSet()
-	stdio := expimpl.NewStdio(hs, map[uint8]expimpl.StdHandleCtor{
-		exp.HandleTypeStdin: func() (any, error) {
-			return os.Stdin, nil
-		},
-		exp.HandleTypeStdout: func() (any, error) {
-			return os.Stdout, nil
-		},
-		exp.HandleTypeStderr: func() (any, error) {
-			return os.Stderr, nil
-		},
-	})
-	exp.ImportStdio(rt, stdio)
+	conf := loadPoolConfig()
+	if args[1] == "attach" && conf.Size > 1 {
+		// Each guest listens on the address until it is connected.
+		exit(fmt.Errorf("%s must be 1 to attach a guest", EnvPoolSize))
+	}
 
-	stream := expimpl.NewStream(hs)
-	exp.ImportStream(rt, stream)
+	// os.Exit does not run deferred functions, so the pools are closed before exiting not to orphan the guests.
+	// The main pool is closed first because its guests call the linked ones.
+	var pools []*pool.Pool
+	closePools := func() {
+		for i := len(pools) - 1; i >= 0; i-- {
+			_ = pools[i].Close()
+		}
+	}
+	fail := func(err error) {
+		closePools()
+		exit(err)
+	}
 
-	file := expimpl.NewFile(hs)
-	exp.ImportFile(rt, file)
+	links := loadLinks()
+	err := checkLinks(logger, links)
+	if err != nil {
+		fail(err)
+	}
+	linked := make([]*pool.Pool, 0, len(links))
+	for _, lc := range links {
+		newLinkedGuest := runGuest(lc.Run[0], lc.Run[1:], sandboxOpts)
+		linkConf := loadPoolConfig()
+		linkConf.HTTPListeners = nil
+		lp, err := pool.New(logger, linkConf, func(m *pool.Member) (*runtime.Runtime, error) {
+			guest, err := newLinkedGuest()
+			if err != nil {
+				return nil, err
+			}
+			return newRuntime(logger, guest, nil), nil
+		})
+		if err != nil {
+			fail(err)
+		}
+		pools = append(pools, lp)
+		err = lp.Start()
+		if err != nil {
+			fail(err)
+		}
+		linked = append(linked, lp)
+	}
 
-	http := expimpl.NewHTTP(logger, hs, map[string]expimpl.HttpListenerConfig{
-		"default": {
-			AddrAndPort: ":8080",
-		},
+	p, err := pool.New(logger, conf, func(m *pool.Member) (*runtime.Runtime, error) {
+		guest, err := newGuest()
+		if err != nil {
+			return nil, err
+		}
+		rt := newRuntime(logger, guest, m.HTTPListeners("default"))
+		for i, lc := range links {
+			for _, l := range lc.Links {
+				err := link.Use(rt, linked[i], l)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return rt, nil
 	})
-	exp.ImportHTTP(rt, http)
-
-	// TODO: use UseWorld
-
-	err := rt.Start()
 	if err != nil {
-		panic(err)
+		fail(err)
+	}
+	pools = append(pools, p)
+	err = p.Start()
+	if err != nil {
+		fail(err)
 	}
 
-	err = rt.Wait()
+	err = p.Wait()
 	if err != nil {
-		panic(err)
+		fail(err)
 	}
+	closePools()
 
 	fmt.Fprintf(os.Stderr, "esotime: OK\n")
 }
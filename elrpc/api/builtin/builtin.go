@@ -9,16 +9,40 @@ import (
 const (
 	ModuleID = 0x0000_0000
 
-	MethodID_Exporter_PollMethodCall = 0x0000_0000
-	MethodID_Exporter_SendResult     = 0x0000_0001
+	MethodID_Exporter_PollMethodCall  = 0x0000_0000
+	MethodID_Exporter_SendResult      = 0x0000_0001
+	MethodID_Exporter_PollMethodCalls = 0x0000_0002
+	MethodID_Exporter_EnablePush      = 0x0000_0003
+
+	MethodID_Streaming_Recv      = 0x0000_0010
+	MethodID_Streaming_Send      = 0x0000_0011
+	MethodID_Streaming_CloseSend = 0x0000_0012
+	MethodID_Streaming_Cancel    = 0x0000_0013
+
+	// Sent from the host to the guest (through Exporter.PollMethodCall) when a pending method call is canceled.
+	// Its argument is a Uint64 that holds the call ID of the canceled call.
+	// Guests must not send a result for this notification.
+	MethodID_Notification_CancelCall = 0x0000_1000
 )
 
+// In push mode, which the guest enters by calling Exporter.EnablePush, every frame sent from the host to the guest
+// starts with a Uint8 that holds one of the frame kinds below, followed by the frame's payload.
+// The response to EnablePush itself is still sent without the frame kind.
 const (
-	CodeUnknown        = 0x0000
-	CodeUnimplemented  = 0x0001
-	CodeNotFound       = 0x0002
-	CodeInvalidRequest = 0x0003
-	CodeInternal       = 0x0004
+	// The payload is a Result that holds the response to the guest's request.
+	FrameKindResponse = 0x00
+	// The payload is a MethodCall that the host pushes to the guest without being polled.
+	FrameKindMethodCall = 0x01
+)
+
+const (
+	CodeUnknown          = 0x0000
+	CodeUnimplemented    = 0x0001
+	CodeNotFound         = 0x0002
+	CodeInvalidRequest   = 0x0003
+	CodeInternal         = 0x0004
+	CodeUnavailable      = 0x0005
+	CodeDeadlineExceeded = 0x0006
 )
 
 type MethodCall struct {
@@ -115,16 +139,49 @@ func (m *MethodResult) ZeroMessage() message.Message {
 type Exporter interface {
 	PollMethodCall() (*MethodCall, error)
 	SendResult(*MethodResult) (message.Void, error)
+	// PollMethodCalls waits for at most timeoutMillis milliseconds until any method call is queued,
+	// and then returns at most maxCalls calls (0 means no limit).
+	// It returns an empty array if no method call is queued before the timeout.
+	PollMethodCalls(timeoutMillis *message.Uint64, maxCalls *message.Uint64) (*message.Array[*MethodCall], error)
+	// EnablePush switches the connection to push mode, in which the host sends method calls to the guest as soon as they are queued.
+	EnablePush() (message.Void, error)
 }
 
 func ImportExporter(rt types.Runtime, e Exporter) {
 	rt.Use(ModuleID, MethodID_Exporter_PollMethodCall, apibuilder.HostHandler0[*MethodCall](e.PollMethodCall))
 	rt.Use(ModuleID, MethodID_Exporter_SendResult, apibuilder.HostHandler1[*MethodResult, message.Void](e.SendResult))
+	rt.Use(ModuleID, MethodID_Exporter_PollMethodCalls, apibuilder.HostHandler2[*message.Uint64, *message.Uint64, *message.Array[*MethodCall]](e.PollMethodCalls))
+	rt.Use(ModuleID, MethodID_Exporter_EnablePush, apibuilder.HostHandler0[message.Void](e.EnablePush))
+}
+
+// Streaming is used by the guest to exchange messages with a streaming method.
+// Calling a streaming method returns a Uint64 stream ID instead of its result.
+// The guest then receives messages with Recv until it returns None (the end of the stream) or an error.
+type Streaming interface {
+	Recv(streamID *message.Uint64) (*message.Option[*message.Any], error)
+	Send(streamID *message.Uint64, data *message.Any) (message.Void, error)
+	// CloseSend tells the host that the guest has no more messages to send.
+	CloseSend(streamID *message.Uint64) (message.Void, error)
+	// Cancel aborts the stream.
+	Cancel(streamID *message.Uint64) (message.Void, error)
+}
+
+func ImportStreaming(rt types.Runtime, s Streaming) {
+	rt.Use(ModuleID, MethodID_Streaming_Recv, apibuilder.HostHandler1[*message.Uint64, *message.Option[*message.Any]](s.Recv))
+	rt.Use(ModuleID, MethodID_Streaming_Send, apibuilder.HostHandler2[*message.Uint64, *message.Any, message.Void](s.Send))
+	rt.Use(ModuleID, MethodID_Streaming_CloseSend, apibuilder.HostHandler1[*message.Uint64, message.Void](s.CloseSend))
+	rt.Use(ModuleID, MethodID_Streaming_Cancel, apibuilder.HostHandler1[*message.Uint64, message.Void](s.Cancel))
+}
+
+type Imports struct {
+	Exporter  Exporter
+	Streaming Streaming
 }
 
 type Exports struct{}
 
-func UseWorld(rt types.Runtime, e Exporter) *Exports {
-	ImportExporter(rt, e)
+func UseWorld(rt types.Runtime, imports *Imports) *Exports {
+	ImportExporter(rt, imports.Exporter)
+	ImportStreaming(rt, imports.Streaming)
 	return &Exports{}
 }
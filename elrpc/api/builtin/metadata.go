@@ -0,0 +1,146 @@
+package builtin
+
+import (
+	"context"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+// Metadata is an optional section of a request envelope.
+// When present, it is encoded as an Any that precedes the module ID of the request:
+//
+//	Any(Metadata) Uint32(module ID) Uint32(method ID) args...
+//
+// Requests without metadata start with the module ID as usual.
+type Metadata struct {
+	// The time by which the guest wants the request to complete.
+	Deadline *message.Option[*message.Int64] // in Unix milliseconds
+	TraceID  []byte
+	SpanID   []byte
+	Tags     []*Tag
+}
+
+var _ message.Message = (*Metadata)(nil)
+
+func (m *Metadata) UnmarshalELRPC(dec *message.Decoder) error {
+	deadline := &message.Option[*message.Int64]{}
+	err := deadline.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+	traceID, err := dec.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	spanID, err := dec.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	tags := &message.Array[*Tag]{}
+	err = tags.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+	m.Deadline = deadline
+	m.TraceID = traceID
+	m.SpanID = spanID
+	m.Tags = tags.Items
+	return nil
+}
+
+func (m *Metadata) MarshalELRPC(enc *message.Encoder) error {
+	deadline := m.Deadline
+	if deadline == nil {
+		deadline = &message.Option[*message.Int64]{}
+	}
+	err := deadline.MarshalELRPC(enc)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeBytes(m.TraceID)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeBytes(m.SpanID)
+	if err != nil {
+		return err
+	}
+	tags := &message.Array[*Tag]{Items: m.Tags}
+	err = tags.MarshalELRPC(enc)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Metadata) ZeroMessage() message.Message {
+	return &Metadata{}
+}
+
+// DeadlineTime returns the deadline as a time.Time, if any.
+func (m *Metadata) DeadlineTime() (time.Time, bool) {
+	if m == nil || m.Deadline == nil || !m.Deadline.IsSome {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(m.Deadline.Some.Value), true
+}
+
+// Tag returns the value of the first tag that has the given key.
+func (m *Metadata) Tag(key string) (string, bool) {
+	for _, t := range m.Tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+var _ message.Message = (*Tag)(nil)
+
+func (t *Tag) UnmarshalELRPC(dec *message.Decoder) error {
+	key, err := dec.DecodeString()
+	if err != nil {
+		return err
+	}
+	value, err := dec.DecodeString()
+	if err != nil {
+		return err
+	}
+	t.Key = key
+	t.Value = value
+	return nil
+}
+
+func (t *Tag) MarshalELRPC(enc *message.Encoder) error {
+	err := enc.EncodeString(t.Key)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeString(t.Value)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *Tag) ZeroMessage() message.Message {
+	return &Tag{}
+}
+
+type metadataKey struct{}
+
+func ContextWithMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the metadata of the request that ctx belongs to.
+func MetadataFromContext(ctx context.Context) (*Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(*Metadata)
+	return md, ok
+}
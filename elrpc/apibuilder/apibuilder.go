@@ -1,6 +1,8 @@
 package apibuilder
 
 import (
+	"context"
+
 	"github.com/genkami/elsi/elrpc/message"
 	"github.com/genkami/elsi/elrpc/types"
 )
@@ -164,9 +166,13 @@ func NewGuestDelegator0[R message.Message](rt types.Runtime, moduleID, methodID
 }
 
 func (c *GuestDelegator0[R]) Call() (R, error) {
+	return c.CallContext(context.Background())
+}
+
+func (c *GuestDelegator0[R]) CallContext(ctx context.Context) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
@@ -195,6 +201,10 @@ func NewGuestDelegator1[T1, R message.Message](rt types.Runtime, moduleID, metho
 }
 
 func (c *GuestDelegator1[T1, R]) Call(x1 T1) (R, error) {
+	return c.CallContext(context.Background(), x1)
+}
+
+func (c *GuestDelegator1[T1, R]) CallContext(ctx context.Context, x1 T1) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
 	err := x1.MarshalELRPC(enc)
@@ -202,7 +212,7 @@ func (c *GuestDelegator1[T1, R]) Call(x1 T1) (R, error) {
 		return zero, err
 	}
 
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
@@ -231,6 +241,10 @@ func NewGuestDelegator2[T1, T2, R message.Message](rt types.Runtime, moduleID, m
 }
 
 func (c *GuestDelegator2[T1, T2, R]) Call(x1 T1, x2 T2) (R, error) {
+	return c.CallContext(context.Background(), x1, x2)
+}
+
+func (c *GuestDelegator2[T1, T2, R]) CallContext(ctx context.Context, x1 T1, x2 T2) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
 	err := x1.MarshalELRPC(enc)
@@ -242,7 +256,7 @@ func (c *GuestDelegator2[T1, T2, R]) Call(x1 T1, x2 T2) (R, error) {
 		return zero, err
 	}
 
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
@@ -271,6 +285,10 @@ func NewGuestDelegator3[T1, T2, T3, R message.Message](rt types.Runtime, moduleI
 }
 
 func (c *GuestDelegator3[T1, T2, T3, R]) Call(x1 T1, x2 T2, x3 T3) (R, error) {
+	return c.CallContext(context.Background(), x1, x2, x3)
+}
+
+func (c *GuestDelegator3[T1, T2, T3, R]) CallContext(ctx context.Context, x1 T1, x2 T2, x3 T3) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
 	err := x1.MarshalELRPC(enc)
@@ -286,7 +304,7 @@ func (c *GuestDelegator3[T1, T2, T3, R]) Call(x1 T1, x2 T2, x3 T3) (R, error) {
 		return zero, err
 	}
 
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
@@ -315,6 +333,10 @@ func NewGuestDelegator4[T1, T2, T3, T4, R message.Message](rt types.Runtime, mod
 }
 
 func (c *GuestDelegator4[T1, T2, T3, T4, R]) Call(x1 T1, x2 T2, x3 T3, x4 T4) (R, error) {
+	return c.CallContext(context.Background(), x1, x2, x3, x4)
+}
+
+func (c *GuestDelegator4[T1, T2, T3, T4, R]) CallContext(ctx context.Context, x1 T1, x2 T2, x3 T3, x4 T4) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
 	err := x1.MarshalELRPC(enc)
@@ -334,7 +356,7 @@ func (c *GuestDelegator4[T1, T2, T3, T4, R]) Call(x1 T1, x2 T2, x3 T3, x4 T4) (R
 		return zero, err
 	}
 
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
@@ -363,6 +385,10 @@ func NewGuestDelegator5[T1, T2, T3, T4, T5, R message.Message](rt types.Runtime,
 }
 
 func (c *GuestDelegator5[T1, T2, T3, T4, T5, R]) Call(x1 T1, x2 T2, x3 T3, x4 T4, x5 T5) (R, error) {
+	return c.CallContext(context.Background(), x1, x2, x3, x4, x5)
+}
+
+func (c *GuestDelegator5[T1, T2, T3, T4, T5, R]) CallContext(ctx context.Context, x1 T1, x2 T2, x3 T3, x4 T4, x5 T5) (R, error) {
 	var zero R
 	enc := message.NewEncoder()
 	err := x1.MarshalELRPC(enc)
@@ -386,7 +412,7 @@ func (c *GuestDelegator5[T1, T2, T3, T4, T5, R]) Call(x1 T1, x2 T2, x3 T3, x4 T4
 		return zero, err
 	}
 
-	rawResp, err := c.rt.Call(c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
+	rawResp, err := c.rt.CallContext(ctx, c.moduleID, c.methodID, &message.Any{Raw: enc.Buffer()})
 	if err != nil {
 		return zero, err
 	}
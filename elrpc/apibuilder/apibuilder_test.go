@@ -1,6 +1,7 @@
 package apibuilder_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/genkami/elsi/elrpc/apibuilder"
@@ -246,7 +247,15 @@ func (rt *mockRuntime) Use(moduleID uint32, methodID uint32, handler types.HostH
 	// nop
 }
 
+func (rt *mockRuntime) UseStream(moduleID uint32, methodID uint32, handler types.StreamHandler) {
+	// nop
+}
+
 func (rt *mockRuntime) Call(moduleID uint32, methodID uint32, args *message.Any) (*message.Any, error) {
+	return rt.CallContext(context.Background(), moduleID, methodID, args)
+}
+
+func (rt *mockRuntime) CallContext(ctx context.Context, moduleID uint32, methodID uint32, args *message.Any) (*message.Any, error) {
 	rt.call = runtimeCall{
 		ModuleID: moduleID,
 		MethodID: methodID,
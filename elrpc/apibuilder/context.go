@@ -0,0 +1,170 @@
+package apibuilder
+
+import (
+	"context"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/types"
+)
+
+type ContextHostHandler0[R message.Message] func(context.Context) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler0[message.Message](nil)
+
+func (h ContextHostHandler0[R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler0[R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	return h(ctx)
+}
+
+type ContextHostHandler1[T1, R message.Message] func(context.Context, T1) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler1[message.Message, message.Message](nil)
+
+func (h ContextHostHandler1[T1, R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler1[T1, R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return h(ctx, x1.(T1))
+}
+
+type ContextHostHandler2[T1, T2, R message.Message] func(context.Context, T1, T2) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler2[message.Message, message.Message, message.Message](nil)
+
+func (h ContextHostHandler2[T1, T2, R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler2[T1, T2, R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x2 := message.NewMessage[T2]()
+	err = x2.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return h(ctx, x1.(T1), x2.(T2))
+}
+
+type ContextHostHandler3[T1, T2, T3, R message.Message] func(context.Context, T1, T2, T3) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler3[message.Message, message.Message, message.Message, message.Message](nil)
+
+func (h ContextHostHandler3[T1, T2, T3, R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler3[T1, T2, T3, R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x2 := message.NewMessage[T2]()
+	err = x2.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x3 := message.NewMessage[T3]()
+	err = x3.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return h(ctx, x1.(T1), x2.(T2), x3.(T3))
+}
+
+type ContextHostHandler4[T1, T2, T3, T4, R message.Message] func(context.Context, T1, T2, T3, T4) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler4[message.Message, message.Message, message.Message, message.Message, message.Message](nil)
+
+func (h ContextHostHandler4[T1, T2, T3, T4, R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler4[T1, T2, T3, T4, R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x2 := message.NewMessage[T2]()
+	err = x2.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x3 := message.NewMessage[T3]()
+	err = x3.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x4 := message.NewMessage[T4]()
+	err = x4.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return h(ctx, x1.(T1), x2.(T2), x3.(T3), x4.(T4))
+}
+
+type ContextHostHandler5[T1, T2, T3, T4, T5, R message.Message] func(context.Context, T1, T2, T3, T4, T5) (R, error)
+
+var _ types.ContextHostHandler = ContextHostHandler5[message.Message, message.Message, message.Message, message.Message, message.Message, message.Message](nil)
+
+func (h ContextHostHandler5[T1, T2, T3, T4, T5, R]) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return h.HandleRequestContext(context.Background(), dec)
+}
+
+func (h ContextHostHandler5[T1, T2, T3, T4, T5, R]) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x2 := message.NewMessage[T2]()
+	err = x2.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x3 := message.NewMessage[T3]()
+	err = x3.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x4 := message.NewMessage[T4]()
+	err = x4.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	x5 := message.NewMessage[T5]()
+	err = x5.UnmarshalELRPC(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return h(ctx, x1.(T1), x2.(T2), x3.(T3), x4.(T4), x5.(T5))
+}
@@ -0,0 +1,132 @@
+package apibuilder
+
+import (
+	"context"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/types"
+)
+
+type StreamSender[R message.Message] struct {
+	s types.ServerStream
+}
+
+func (s *StreamSender[R]) Send(m R) error {
+	return s.s.Send(m)
+}
+
+type StreamReceiver[T message.Message] struct {
+	s types.ServerStream
+}
+
+// Recv receives a message from the guest. It returns io.EOF at the end of the stream.
+func (r *StreamReceiver[T]) Recv() (T, error) {
+	var zero T
+	raw, err := r.s.Recv()
+	if err != nil {
+		return zero, err
+	}
+	m := message.NewMessage[T]()
+	err = m.UnmarshalELRPC(message.NewDecoder(raw.Raw))
+	if err != nil {
+		return zero, err
+	}
+	return m.(T), nil
+}
+
+// ServerStreamHandler0 sends an arbitrary number of messages to the guest.
+type ServerStreamHandler0[R message.Message] func(context.Context, *StreamSender[R]) error
+
+var _ types.StreamHandler = ServerStreamHandler0[message.Message](nil)
+
+func (h ServerStreamHandler0[R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	return h(ctx, &StreamSender[R]{s: s})
+}
+
+type ServerStreamHandler1[T1, R message.Message] func(context.Context, T1, *StreamSender[R]) error
+
+var _ types.StreamHandler = ServerStreamHandler1[message.Message, message.Message](nil)
+
+func (h ServerStreamHandler1[T1, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+
+	return h(ctx, x1.(T1), &StreamSender[R]{s: s})
+}
+
+type ServerStreamHandler2[T1, T2, R message.Message] func(context.Context, T1, T2, *StreamSender[R]) error
+
+var _ types.StreamHandler = ServerStreamHandler2[message.Message, message.Message, message.Message](nil)
+
+func (h ServerStreamHandler2[T1, T2, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+
+	x2 := message.NewMessage[T2]()
+	err = x2.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+
+	return h(ctx, x1.(T1), x2.(T2), &StreamSender[R]{s: s})
+}
+
+// ClientStreamHandler0 receives an arbitrary number of messages from the guest and then sends a single result.
+type ClientStreamHandler0[T, R message.Message] func(context.Context, *StreamReceiver[T]) (R, error)
+
+var _ types.StreamHandler = ClientStreamHandler0[message.Message, message.Message](nil)
+
+func (h ClientStreamHandler0[T, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	resp, err := h(ctx, &StreamReceiver[T]{s: s})
+	if err != nil {
+		return err
+	}
+	return s.Send(resp)
+}
+
+type ClientStreamHandler1[T1, T, R message.Message] func(context.Context, T1, *StreamReceiver[T]) (R, error)
+
+var _ types.StreamHandler = ClientStreamHandler1[message.Message, message.Message, message.Message](nil)
+
+func (h ClientStreamHandler1[T1, T, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h(ctx, x1.(T1), &StreamReceiver[T]{s: s})
+	if err != nil {
+		return err
+	}
+	return s.Send(resp)
+}
+
+// BidiStreamHandler0 exchanges an arbitrary number of messages with the guest in both directions.
+type BidiStreamHandler0[T, R message.Message] func(context.Context, *StreamReceiver[T], *StreamSender[R]) error
+
+var _ types.StreamHandler = BidiStreamHandler0[message.Message, message.Message](nil)
+
+func (h BidiStreamHandler0[T, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	return h(ctx, &StreamReceiver[T]{s: s}, &StreamSender[R]{s: s})
+}
+
+type BidiStreamHandler1[T1, T, R message.Message] func(context.Context, T1, *StreamReceiver[T], *StreamSender[R]) error
+
+var _ types.StreamHandler = BidiStreamHandler1[message.Message, message.Message, message.Message](nil)
+
+func (h BidiStreamHandler1[T1, T, R]) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	x1 := message.NewMessage[T1]()
+	err := x1.UnmarshalELRPC(dec)
+	if err != nil {
+		return err
+	}
+
+	return h(ctx, x1.(T1), &StreamReceiver[T]{s: s}, &StreamSender[R]{s: s})
+}
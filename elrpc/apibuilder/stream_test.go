@@ -0,0 +1,98 @@
+package apibuilder_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockServerStream struct {
+	sent []message.Message
+	recv []*message.Any
+}
+
+func (s *mockServerStream) Send(m message.Message) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *mockServerStream) Recv() (*message.Any, error) {
+	if len(s.recv) == 0 {
+		return nil, io.EOF
+	}
+	m := s.recv[0]
+	s.recv = s.recv[1:]
+	return m, nil
+}
+
+func TestServerStreamHandler1(t *testing.T) {
+	enc := message.NewEncoder()
+	err := enc.EncodeUint32(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Handler = apibuilder.ServerStreamHandler1[*message.Uint32, *message.Uint32]
+	handler := Handler(func(ctx context.Context, x1 *message.Uint32, s *apibuilder.StreamSender[*message.Uint32]) error {
+		for i := uint32(0); i < x1.Value; i++ {
+			err := s.Send(&message.Uint32{Value: i})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	s := &mockServerStream{}
+	err = handler.HandleStream(context.Background(), message.NewDecoder(enc.Buffer()), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []message.Message{
+		&message.Uint32{Value: 0},
+		&message.Uint32{Value: 1},
+		&message.Uint32{Value: 2},
+	}
+	if diff := cmp.Diff(want, s.sent); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestClientStreamHandler0(t *testing.T) {
+	type Handler = apibuilder.ClientStreamHandler0[*message.Uint32, *message.Uint32]
+	handler := Handler(func(ctx context.Context, r *apibuilder.StreamReceiver[*message.Uint32]) (*message.Uint32, error) {
+		var sum uint32
+		for {
+			x, err := r.Recv()
+			if err == io.EOF {
+				return &message.Uint32{Value: sum}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			sum += x.Value
+		}
+	})
+
+	s := &mockServerStream{
+		recv: []*message.Any{
+			encodeAsAny(t, &message.Uint32{Value: 1}),
+			encodeAsAny(t, &message.Uint32{Value: 2}),
+			encodeAsAny(t, &message.Uint32{Value: 3}),
+		},
+	}
+	err := handler.HandleStream(context.Background(), message.NewDecoder(nil), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []message.Message{&message.Uint32{Value: 6}}
+	if diff := cmp.Diff(want, s.sent); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
@@ -189,3 +189,18 @@ func (d *Decoder) DecodeAny() (*Any, error) {
 	d.buf = d.buf[length:]
 	return &Any{Raw: val}, nil
 }
+
+// DecodeRaw returns the rest of the buffer as is, without decoding it.
+func (d *Decoder) DecodeRaw() []byte {
+	val := d.buf
+	d.buf = d.buf[len(d.buf):]
+	return val
+}
+
+// PeekTag returns the type tag of the next value without consuming it.
+func (d *Decoder) PeekTag() (byte, error) {
+	if len(d.buf) < 1 {
+		return 0, ErrInsufficientBuf
+	}
+	return d.buf[0], nil
+}
@@ -542,3 +542,35 @@ func TestDecoder_DecodeAny_typeMismatch(t *testing.T) {
 		t.Errorf("want ErrTypeMismatch but got %s", err)
 	}
 }
+
+func TestDecoder_PeekTag(t *testing.T) {
+	buf := []byte{
+		0x03,                   // type tag (uint32)
+		0x12, 0x34, 0x56, 0x78, // value
+	}
+	dec := message.NewDecoder(buf)
+	tag, err := dec.PeekTag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != message.TagUint32 {
+		t.Errorf("want %X but got %X", message.TagUint32, tag)
+	}
+
+	// PeekTag does not consume the value.
+	got, err := dec.DecodeUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0x12345678 {
+		t.Errorf("want 0x12345678 but got %X", got)
+	}
+}
+
+func TestDecoder_PeekTag_insufficientBuf(t *testing.T) {
+	dec := message.NewDecoder([]byte{})
+	_, err := dec.PeekTag()
+	if err != message.ErrInsufficientBuf {
+		t.Errorf("want ErrInsufficientBuf but got %s", err)
+	}
+}
@@ -92,6 +92,12 @@ func (e *Encoder) EncodeAny(val *Any) error {
 	return nil
 }
 
+// EncodeRaw appends val, which must be already encoded, as is.
+func (e *Encoder) EncodeRaw(val []byte) error {
+	e.buf = append(e.buf, val...)
+	return nil
+}
+
 func (e *Encoder) Buffer() []byte {
 	return e.buf
 }
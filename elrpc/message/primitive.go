@@ -310,3 +310,24 @@ func (a *Any) MarshalELRPC(enc *Encoder) error {
 func (a *Any) ZeroMessage() Message {
 	return &Any{}
 }
+
+// Raw is a sequence of values that are already encoded, which is useful to relay messages without knowing their types.
+// It is encoded as is, and decoding it consumes the rest of the buffer.
+type Raw struct {
+	Value []byte
+}
+
+var _ Message = (*Raw)(nil)
+
+func (r *Raw) UnmarshalELRPC(dec *Decoder) error {
+	r.Value = dec.DecodeRaw()
+	return nil
+}
+
+func (r *Raw) MarshalELRPC(enc *Encoder) error {
+	return enc.EncodeRaw(r.Value)
+}
+
+func (r *Raw) ZeroMessage() Message {
+	return &Raw{}
+}
@@ -523,3 +523,52 @@ func TestAny_ZeroMessage(t *testing.T) {
 		t.Errorf("want Any but got %T", got)
 	}
 }
+
+func TestRaw_UnmarshalELRPC(t *testing.T) {
+	buf := []byte{
+		0x02, 0xab, 0xcd, // uint16 0xabcd
+		0x01, 0xef, // uint8 0xef
+	}
+	dec := message.NewDecoder(buf)
+	var v message.Raw
+	err := v.UnmarshalELRPC(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(buf, v.Value); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	_, err = dec.DecodeUint8()
+	if err != message.ErrInsufficientBuf {
+		t.Errorf("want ErrInsufficientBuf but got %v", err)
+	}
+}
+
+func TestRaw_MarshalELRPC(t *testing.T) {
+	v := message.Raw{Value: []byte{0x02, 0xab, 0xcd}}
+	enc := message.NewEncoder()
+	err := enc.EncodeUint8(0xef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.MarshalELRPC(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x01, 0xef, // uint8 0xef
+		0x02, 0xab, 0xcd, // uint16 0xabcd
+	}
+	got := enc.Buffer()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRaw_ZeroMessage(t *testing.T) {
+	var v message.Raw
+	got := v.ZeroMessage()
+	if _, ok := got.(*message.Raw); !ok {
+		t.Errorf("want Raw but got %T", got)
+	}
+}
@@ -0,0 +1,453 @@
+// Package mux multiplexes logical channels over a single stream.
+//
+// Every frame is length-prefixed in the same way as ELRPC messages, and its body consists of
+// a Uint32 channel ID, a Uint8 frame type and a frame-type-specific payload.
+// Each channel has its own receive window, so that a large write to one channel never blocks the others.
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+const (
+	// Opens a new channel. No payload.
+	FrameTypeOpen = 0x00
+	// Sends data to the channel. The payload is a Bytes.
+	FrameTypeData = 0x01
+	// Allows the peer to send more data. The payload is a Uint32 that holds the number of bytes.
+	FrameTypeWindowUpdate = 0x02
+	// Closes the sender's side of the channel. No payload.
+	FrameTypeClose = 0x03
+)
+
+const (
+	DefaultInitialWindow = 256 * 1024
+	DefaultMaxFrameSize  = 16 * 1024
+)
+
+var (
+	ErrSessionClosed  = errors.New("mux: session closed")
+	ErrChannelClosed  = errors.New("mux: channel closed")
+	ErrProtocol       = errors.New("mux: protocol error")
+	ErrWindowExceeded = fmt.Errorf("%w: receive window exceeded", ErrProtocol)
+)
+
+type Config struct {
+	// The number of bytes that the peer can send to each channel before receiving a window update.
+	InitialWindow uint32
+	// The maximum size of a single data frame.
+	MaxFrameSize uint32
+}
+
+func (c *Config) initialWindow() uint32 {
+	if c == nil || c.InitialWindow == 0 {
+		return DefaultInitialWindow
+	}
+	return c.InitialWindow
+}
+
+func (c *Config) maxFrameSize() uint32 {
+	if c == nil || c.MaxFrameSize == 0 {
+		return DefaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+// Session multiplexes channels over a stream. Both ends of the stream must use the same Config.
+type Session struct {
+	rw            io.ReadWriter
+	initialWindow uint32
+	maxFrameSize  uint32
+
+	wmu sync.Mutex // guards writes to rw
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signaled when acceptQ gets a new item or the session is closed
+	channels map[uint32]*Channel
+	acceptQ  []*Channel
+	nextID   uint32
+	err      error // non-nil once the session is closed
+}
+
+// Client creates a session for the guest side. Channels opened by the client have odd IDs.
+func Client(rw io.ReadWriter, conf *Config) *Session {
+	return newSession(rw, conf, 1)
+}
+
+// Server creates a session for the host side. Channels opened by the server have even IDs.
+func Server(rw io.ReadWriter, conf *Config) *Session {
+	return newSession(rw, conf, 2)
+}
+
+func newSession(rw io.ReadWriter, conf *Config, firstID uint32) *Session {
+	s := &Session{
+		rw:            rw,
+		initialWindow: conf.initialWindow(),
+		maxFrameSize:  conf.maxFrameSize(),
+		channels:      make(map[uint32]*Channel),
+		nextID:        firstID,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.readLoop()
+	return s
+}
+
+// Open opens a new channel.
+func (s *Session) Open() (*Channel, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	ch := newChannel(s, id)
+	s.channels[id] = ch
+	s.mu.Unlock()
+
+	err := s.writeFrame(id, FrameTypeOpen, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Accept waits for the peer to open a new channel.
+func (s *Session) Accept() (*Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.acceptQ) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.acceptQ) == 0 {
+		return nil, s.err
+	}
+	ch := s.acceptQ[0]
+	s.acceptQ = s.acceptQ[1:]
+	return ch, nil
+}
+
+// Close closes the session and all of its channels. It does not close the underlying stream.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return nil
+}
+
+// Err returns the reason why the session is closed, or nil if it is still open.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	channels := s.channels
+	s.channels = make(map[uint32]*Channel)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.fail(err)
+	}
+}
+
+func (s *Session) readLoop() {
+	for {
+		err := s.readFrame()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrSessionClosed
+			}
+			s.fail(err)
+			return
+		}
+	}
+}
+
+func (s *Session) readFrame() error {
+	lenBuf := make([]byte, message.LengthSize)
+	_, err := io.ReadFull(s.rw, lenBuf)
+	if err != nil {
+		return err
+	}
+	length, err := message.DecodeLength(lenBuf)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(s.rw, buf)
+	if err != nil {
+		return err
+	}
+
+	dec := message.NewDecoder(buf)
+	id, err := dec.DecodeUint32()
+	if err != nil {
+		return err
+	}
+	frameType, err := dec.DecodeUint8()
+	if err != nil {
+		return err
+	}
+
+	switch frameType {
+	case FrameTypeOpen:
+		return s.handleOpen(id)
+	case FrameTypeData:
+		data, err := dec.DecodeBytes()
+		if err != nil {
+			return err
+		}
+		if ch := s.channel(id); ch != nil {
+			return ch.receive(data)
+		}
+		return nil
+	case FrameTypeWindowUpdate:
+		delta, err := dec.DecodeUint32()
+		if err != nil {
+			return err
+		}
+		if ch := s.channel(id); ch != nil {
+			ch.grow(delta)
+		}
+		return nil
+	case FrameTypeClose:
+		if ch := s.channel(id); ch != nil {
+			ch.closeRead()
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown frame type: %d", ErrProtocol, frameType)
+	}
+}
+
+func (s *Session) handleOpen(id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id%2 == s.nextID%2 {
+		return fmt.Errorf("%w: peer opened channel %d with a wrong parity", ErrProtocol, id)
+	}
+	if _, ok := s.channels[id]; ok {
+		return fmt.Errorf("%w: channel %d is already open", ErrProtocol, id)
+	}
+	ch := newChannel(s, id)
+	s.channels[id] = ch
+	s.acceptQ = append(s.acceptQ, ch)
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Session) channel(id uint32) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.channels[id]
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels, id)
+}
+
+func (s *Session) writeFrame(id uint32, frameType uint8, payload message.Marshaler) error {
+	enc := message.NewEncoder()
+	err := enc.EncodeUint32(id)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeUint8(frameType)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		err = payload.MarshalELRPC(enc)
+		if err != nil {
+			return err
+		}
+	}
+	body := enc.Buffer()
+	frame, err := message.AppendLength(make([]byte, 0, message.LengthSize+len(body)), len(body))
+	if err != nil {
+		return err
+	}
+	frame = append(frame, body...)
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := s.Err(); err != nil {
+		return err
+	}
+	_, err = s.rw.Write(frame)
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+// Channel is a logical bidirectional stream in a session.
+type Channel struct {
+	s  *Session
+	id uint32
+
+	mu          sync.Mutex
+	cond        *sync.Cond // signaled when any of the fields below changes
+	readBuf     []byte
+	unacked     uint32 // bytes received but not yet granted back to the peer
+	consumed    uint32 // bytes read by the user but not yet granted back to the peer
+	sendWindow  uint32
+	readClosed  bool // the peer closed its side
+	writeClosed bool // we closed our side
+	err         error
+}
+
+var _ io.ReadWriteCloser = (*Channel)(nil)
+
+func newChannel(s *Session, id uint32) *Channel {
+	ch := &Channel{
+		s:          s,
+		id:         id,
+		sendWindow: s.initialWindow,
+	}
+	ch.cond = sync.NewCond(&ch.mu)
+	return ch
+}
+
+func (ch *Channel) ID() uint32 {
+	return ch.id
+}
+
+func (ch *Channel) Read(p []byte) (int, error) {
+	ch.mu.Lock()
+	for len(ch.readBuf) == 0 && !ch.readClosed && ch.err == nil {
+		ch.cond.Wait()
+	}
+	if len(ch.readBuf) == 0 {
+		defer ch.mu.Unlock()
+		if ch.readClosed {
+			return 0, io.EOF
+		}
+		return 0, ch.err
+	}
+	n := copy(p, ch.readBuf)
+	ch.readBuf = ch.readBuf[n:]
+	ch.consumed += uint32(n)
+	var grant uint32
+	if ch.consumed >= ch.s.initialWindow/2 && !ch.readClosed {
+		grant = ch.consumed
+		ch.consumed = 0
+		ch.unacked -= grant
+	}
+	ch.mu.Unlock()
+
+	if grant > 0 {
+		_ = ch.s.writeFrame(ch.id, FrameTypeWindowUpdate, &message.Uint32{Value: grant})
+	}
+	return n, nil
+}
+
+// Write sends p to the peer. It splits p into frames so that other channels can interleave,
+// and blocks while the peer's receive window is exhausted.
+func (ch *Channel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		ch.mu.Lock()
+		for ch.sendWindow == 0 && !ch.writeClosed && ch.err == nil {
+			ch.cond.Wait()
+		}
+		if ch.err != nil {
+			ch.mu.Unlock()
+			return written, ch.err
+		}
+		if ch.writeClosed {
+			ch.mu.Unlock()
+			return written, ErrChannelClosed
+		}
+		n := uint32(len(p))
+		if n > ch.sendWindow {
+			n = ch.sendWindow
+		}
+		if n > ch.s.maxFrameSize {
+			n = ch.s.maxFrameSize
+		}
+		ch.sendWindow -= n
+		ch.mu.Unlock()
+
+		err := ch.s.writeFrame(ch.id, FrameTypeData, &message.Bytes{Value: p[:n]})
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes our side of the channel. The peer reads io.EOF after it consumes the data sent so far.
+func (ch *Channel) Close() error {
+	ch.mu.Lock()
+	if ch.writeClosed || ch.err != nil {
+		ch.mu.Unlock()
+		return nil
+	}
+	ch.writeClosed = true
+	done := ch.readClosed
+	ch.cond.Broadcast()
+	ch.mu.Unlock()
+
+	if done {
+		ch.s.remove(ch.id)
+	}
+	return ch.s.writeFrame(ch.id, FrameTypeClose, nil)
+}
+
+func (ch *Channel) receive(data []byte) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if uint64(ch.unacked)+uint64(len(data)) > uint64(ch.s.initialWindow) {
+		return ErrWindowExceeded
+	}
+	ch.unacked += uint32(len(data))
+	ch.readBuf = append(ch.readBuf, data...)
+	ch.cond.Broadcast()
+	return nil
+}
+
+func (ch *Channel) grow(delta uint32) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.sendWindow += delta
+	ch.cond.Broadcast()
+}
+
+func (ch *Channel) closeRead() {
+	ch.mu.Lock()
+	ch.readClosed = true
+	done := ch.writeClosed
+	ch.cond.Broadcast()
+	ch.mu.Unlock()
+
+	if done {
+		ch.s.remove(ch.id)
+	}
+}
+
+func (ch *Channel) fail(err error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.err == nil {
+		ch.err = err
+	}
+	ch.cond.Broadcast()
+}
@@ -0,0 +1,188 @@
+package mux_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/mux"
+	"golang.org/x/sync/errgroup"
+)
+
+var timeout = 1 * time.Second
+
+func newSessionPair(t *testing.T, conf *mux.Config) (*mux.Session, *mux.Session) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	return mux.Client(clientConn, conf), mux.Server(serverConn, conf)
+}
+
+func TestSession_openAccept(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		ch, err := server.Accept()
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(ch)
+		if err != nil {
+			return err
+		}
+		_, err = ch.Write(bytes.ToUpper(buf))
+		if err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+
+	ch, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.ID()%2 != 1 {
+		t.Errorf("want odd channel ID but got %d", ch.ID())
+	}
+	_, err = ch.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HELLO" {
+		t.Errorf("want HELLO but got %q", got)
+	}
+
+	err = eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSession_flowControl(t *testing.T) {
+	conf := &mux.Config{InitialWindow: 16, MaxFrameSize: 4}
+	client, server := newSessionPair(t, conf)
+
+	want := bytes.Repeat([]byte("0123456789"), 100)
+	var eg errgroup.Group
+	eg.Go(func() error {
+		ch, err := client.Open()
+		if err != nil {
+			return err
+		}
+		_, err = ch.Write(want)
+		if err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+
+	ch, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("want %q but got %q", want, got)
+	}
+
+	err = eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSession_noHeadOfLineBlocking(t *testing.T) {
+	conf := &mux.Config{InitialWindow: 16, MaxFrameSize: 4}
+	client, server := newSessionPair(t, conf)
+
+	// Nobody reads bulk, so writing to it blocks once its window is exhausted.
+	bulk, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = bulk.Write(make([]byte, 1024))
+	}()
+
+	control, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = control.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Accept() // bulk
+		if err != nil {
+			done <- err
+			return
+		}
+		ch, err := server.Accept() // control
+		if err != nil {
+			done <- err
+			return
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(ch, buf)
+		if err == nil && string(buf) != "ping" {
+			err = errors.New("unexpected data: " + string(buf))
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("timeout")
+	}
+}
+
+func TestSession_close(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+
+	ch, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ch.Read(make([]byte, 1))
+	if !errors.Is(err, mux.ErrSessionClosed) {
+		t.Errorf("want ErrSessionClosed but got %v", err)
+	}
+	_, err = client.Open()
+	if !errors.Is(err, mux.ErrSessionClosed) {
+		t.Errorf("want ErrSessionClosed but got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+// conn serves a single request/response conversation with the guest.
+type conn struct {
+	rt     *Runtime
+	stream Stream
+	wmu    sync.Mutex // guards writes to stream and push
+	push   bool
+}
+
+func newConn(rt *Runtime, stream Stream) *conn {
+	return &conn{
+		rt:     rt,
+		stream: stream,
+	}
+}
+
+func (c *conn) serve() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for {
+		req, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+
+		resp := c.rt.dispatchRequest(message.NewDecoder(req))
+		err = c.writeFrame(builtin.FrameKindResponse, resp)
+		if err != nil {
+			return err
+		}
+
+		if resp.IsOk && isEnablePush(req) {
+			c.wmu.Lock()
+			enabled := c.push
+			c.push = true
+			c.wmu.Unlock()
+			if !enabled {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					c.pushWorker(ctx)
+				}()
+			}
+		}
+	}
+}
+
+// pushWorker sends queued method calls to the guest until ctx is done or the exporter is closed.
+func (c *conn) pushWorker(ctx context.Context) {
+	for {
+		calls, err := c.rt.exporter.WaitMethodCalls(ctx, 0)
+		if err != nil {
+			return
+		}
+		for i, call := range calls {
+			err = c.writeFrame(builtin.FrameKindMethodCall, call)
+			if err != nil {
+				c.rt.logger.Error("failed to push method call",
+					slog.Uint64("call_id", call.CallID), slog.String("error", err.Error()))
+				// Let other connections deliver them.
+				c.rt.exporter.Requeue(calls[i:])
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) readFrame() ([]byte, error) {
+	lenBuf := make([]byte, message.LengthSize)
+	_, err := io.ReadFull(c.stream, lenBuf)
+	if err != nil {
+		return nil, err
+	}
+	length, err := message.DecodeLength(lenBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+	_, err = io.ReadFull(c.stream, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeFrame sends m to the guest. The frame kind is prepended only in push mode.
+func (c *conn) writeFrame(kind uint8, m message.Marshaler) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	enc := message.NewEncoder()
+	if c.push {
+		err := enc.EncodeUint8(kind)
+		if err != nil {
+			return err
+		}
+	}
+	err := m.MarshalELRPC(enc)
+	if err != nil {
+		return err
+	}
+	body := enc.Buffer()
+
+	lenBuf, err := message.AppendLength(nil, len(body))
+	if err != nil {
+		return err
+	}
+	_, err = c.stream.Write(lenBuf)
+	if err != nil {
+		return err
+	}
+	_, err = c.stream.Write(body)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func isEnablePush(req []byte) bool {
+	dec := message.NewDecoder(req)
+	_, err := decodeMetadata(dec)
+	if err != nil {
+		return false
+	}
+	modID, err := dec.DecodeUint32()
+	if err != nil {
+		return false
+	}
+	methodID, err := dec.DecodeUint32()
+	if err != nil {
+		return false
+	}
+	return modID == builtin.ModuleID && methodID == builtin.MethodID_Exporter_EnablePush
+}
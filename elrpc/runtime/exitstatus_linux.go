@@ -0,0 +1,19 @@
+package runtime
+
+import (
+	"os"
+	"syscall"
+)
+
+func sysExitStatus(ps *os.ProcessState) (os.Signal, int64) {
+	var sig os.Signal
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		sig = ws.Signal()
+	}
+	var maxRSS int64
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		// ru_maxrss is in kilobytes on Linux.
+		maxRSS = ru.Maxrss * 1024
+	}
+	return sig, maxRSS
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package runtime
+
+import (
+	"os"
+)
+
+func sysExitStatus(ps *os.ProcessState) (os.Signal, int64) {
+	return nil, 0
+}
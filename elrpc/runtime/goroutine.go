@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// GuestFunc is the body of a GoroutineGuest. It talks ELRPC over s, and returns the exit code of the guest.
+// ctx is canceled when the guest is killed.
+type GuestFunc func(ctx context.Context, s Stream) int
+
+// GoroutineGuest is a guest that runs a Go function in the same process, connected by in-memory pipes.
+type GoroutineGuest struct {
+	fn GuestFunc
+
+	hostR  *io.PipeReader
+	hostW  *io.PipeWriter
+	guestR *io.PipeReader
+	guestW *io.PipeWriter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	started atomic.Bool
+	done    chan struct{}
+	// valid after done is closed
+	exitCode   int
+	panicErr   *PanicError
+	exitStatus *ExitStatus
+}
+
+var _ Guest = (*GoroutineGuest)(nil)
+
+// ExitError is returned by GoroutineGuest.Wait when the guest exits with a non-zero code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("guest exited with code %d", e.Code)
+}
+
+// PanicError is returned by GoroutineGuest.Wait when the guest panics.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("guest panicked: %v", e.Value)
+}
+
+// The exit code of a guest that panicked, which is the same as the one of a Go program.
+const exitCodePanic = 2
+
+func NewGoroutineGuest(fn GuestFunc) *GoroutineGuest {
+	hostR, guestW := io.Pipe()
+	guestR, hostW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GoroutineGuest{
+		fn:     fn,
+		hostR:  hostR,
+		hostW:  hostW,
+		guestR: guestR,
+		guestW: guestW,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func (g *GoroutineGuest) Stream() Stream {
+	return NewPipeStream(g.hostR, g.hostW)
+}
+
+func (g *GoroutineGuest) Start() error {
+	if !g.started.CompareAndSwap(false, true) {
+		return errors.New("guest is already started")
+	}
+	go g.run()
+	return nil
+}
+
+func (g *GoroutineGuest) run() {
+	defer close(g.done)
+	defer func() {
+		// The host sees EOF once the guest exits.
+		g.guestW.Close()
+		g.guestR.CloseWithError(io.ErrClosedPipe)
+		g.cancel()
+		g.exitStatus = &ExitStatus{Reason: ExitReasonExited, ExitCode: g.exitCode}
+		if g.panicErr != nil {
+			g.exitStatus.Reason = ExitReasonPanicked
+		}
+	}()
+	defer func() {
+		if v := recover(); v != nil {
+			g.panicErr = &PanicError{Value: v, Stack: debug.Stack()}
+			g.exitCode = exitCodePanic
+		}
+	}()
+	g.exitCode = g.fn(g.ctx, NewPipeStream(g.guestR, g.guestW))
+}
+
+// Wait waits for the guest to exit. It returns *ExitError or *PanicError if the guest fails.
+func (g *GoroutineGuest) Wait() error {
+	if !g.started.Load() {
+		return errors.New("guest is not started")
+	}
+	<-g.done
+	if g.panicErr != nil {
+		return g.panicErr
+	}
+	if g.exitCode != 0 {
+		return &ExitError{Code: g.exitCode}
+	}
+	return nil
+}
+
+// Kill cancels the guest's context and closes its pipes, so that the guest fails to talk to the host.
+// The guest function should return soon after that.
+func (g *GoroutineGuest) Kill() {
+	g.cancel()
+	g.guestR.CloseWithError(io.ErrClosedPipe)
+	g.guestW.CloseWithError(io.ErrClosedPipe)
+}
+
+// ExitStatus returns how the guest exited. It returns nil until the guest exits.
+func (g *GoroutineGuest) ExitStatus() *ExitStatus {
+	select {
+	case <-g.done:
+		return g.exitStatus
+	default:
+		return nil
+	}
+}
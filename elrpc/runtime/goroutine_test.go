@@ -0,0 +1,129 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/errgroup"
+)
+
+func startGoroutineGuest(t *testing.T, fn runtime.GuestFunc) (*runtime.Runtime, *runtime.GoroutineGuest) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := runtime.NewGoroutineGuest(fn)
+	rt := runtime.NewRuntime(logger, guest)
+	ImportHostAPI(rt, &hostAPIImpl{
+		pingImpl: func(arg *message.String) (*message.String, error) {
+			return &message.String{Value: arg.Value + "Pong"}, nil
+		},
+	})
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt, guest
+}
+
+func pingFromGuest(t *testing.T, s runtime.Stream, value string) error {
+	type Result = message.Result[*message.String, *message.Error]
+	got := &Result{}
+	err := got.UnmarshalELRPC(callHostAPI(t, s, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: value}))
+	if err != nil {
+		return err
+	}
+	if !got.IsOk || got.Ok.Value != value+"Pong" {
+		return fmt.Errorf("unexpected response: %#v", got)
+	}
+	return nil
+}
+
+func TestGoroutineGuest(t *testing.T) {
+	rt, guest := startGoroutineGuest(t, func(ctx context.Context, s runtime.Stream) int {
+		err := pingFromGuest(t, s, "Ping")
+		if err != nil {
+			t.Error(err)
+			return 1
+		}
+		return 0
+	})
+	err := rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := guest.ExitStatus()
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 0 {
+		t.Errorf("want exit code 0 but got %s (%d)", st.Reason, st.ExitCode)
+	}
+}
+
+func TestGoroutineGuest_exitCode(t *testing.T) {
+	rt, guest := startGoroutineGuest(t, func(ctx context.Context, s runtime.Stream) int {
+		return 3
+	})
+	err := rt.Wait()
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("want exit code 3 but got %v", err)
+	}
+	if st := guest.ExitStatus(); st.ExitCode != 3 {
+		t.Errorf("want exit code 3 but got %d", st.ExitCode)
+	}
+}
+
+func TestGoroutineGuest_panic(t *testing.T) {
+	rt, guest := startGoroutineGuest(t, func(ctx context.Context, s runtime.Stream) int {
+		panic("oops")
+	})
+	err := rt.Wait()
+	var panicErr *runtime.PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "oops" {
+		t.Errorf("want panic but got %v", err)
+	}
+	if st := guest.ExitStatus(); st.Reason != runtime.ExitReasonPanicked {
+		t.Errorf("want %s but got %s", runtime.ExitReasonPanicked, st.Reason)
+	}
+}
+
+func TestGoroutineGuest_kill(t *testing.T) {
+	rt, guest := startGoroutineGuest(t, func(ctx context.Context, s runtime.Stream) int {
+		<-ctx.Done()
+		return 137
+	})
+	if guest.ExitStatus() != nil {
+		t.Errorf("want nil but got %#v", guest.ExitStatus())
+	}
+	guest.Kill()
+	err := rt.Wait()
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 137 {
+		t.Errorf("want exit code 137 but got %v", err)
+	}
+}
+
+func TestGoroutineGuest_many(t *testing.T) {
+	var eg errgroup.Group
+	for i := 0; i < 200; i++ {
+		i := i
+		eg.Go(func() error {
+			rt, _ := startGoroutineGuest(t, func(ctx context.Context, s runtime.Stream) int {
+				err := pingFromGuest(t, s, fmt.Sprint(i))
+				if err != nil {
+					t.Error(err)
+					return 1
+				}
+				return 0
+			})
+			return rt.Wait()
+		})
+	}
+	err := eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
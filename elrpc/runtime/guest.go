@@ -1,9 +1,27 @@
 package runtime
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/runtime/sandbox"
+	"golang.org/x/exp/slog"
+)
+
+// EnvRPCFDs is the environment variable that tells a guest created by NewProcessGuestFD
+// which file descriptors to use for ELRPC. Its value is "R,W", where the guest reads responses from R and writes requests to W.
+const EnvRPCFDs = "ELSI_RPC_FDS"
+
+// The file descriptors of the ELRPC pipes in a guest created by NewProcessGuestFD.
+const (
+	guestReadFD  = 3
+	guestWriteFD = 4
 )
 
 type Stream interface {
@@ -28,25 +46,213 @@ type Guest interface {
 type ProcessGuest struct {
 	cmd    *exec.Cmd
 	stream Stream
+	// The guest's ends of the ELRPC pipes, which are closed in the host after the guest starts.
+	childFiles []*os.File
+	// The host's ends of the ELRPC pipes, which are closed after the guest exits as exec.Cmd.Wait closes StdoutPipe.
+	hostFiles []*os.File
+	// Non-nil if stderr is sent to a logger.
+	stderrLogger *logWriter
+	sandbox      *sandbox.Sandbox
+	exitStatus   *ExitStatus
 }
 
 var _ Guest = (*ProcessGuest)(nil)
 
+type processConfig struct {
+	rpcFDs       bool
+	envSet       bool
+	env          []string
+	dir          string
+	stdin        io.Reader
+	stdout       io.Writer
+	stdioSet     bool
+	stderr       io.Writer
+	stderrLogger *slog.Logger
+	sandbox      *sandbox.Config
+}
+
+type ProcessOption func(*processConfig)
+
+// WithRPCFDs makes the guest talk ELRPC over file descriptors 3 and 4 instead of stdin and stdout.
+// The guest finds the file descriptors in EnvRPCFDs.
+func WithRPCFDs() ProcessOption {
+	return func(c *processConfig) {
+		c.rpcFDs = true
+	}
+}
+
+// WithEnvAllowlist passes the host's environment variables in keys to the guest.
+// Once any of WithEnvAllowlist and WithEnv is given, the guest no longer inherits the other variables.
+func WithEnvAllowlist(keys ...string) ProcessOption {
+	return func(c *processConfig) {
+		c.envSet = true
+		for _, key := range keys {
+			if value, ok := os.LookupEnv(key); ok {
+				c.env = append(c.env, key+"="+value)
+			}
+		}
+	}
+}
+
+// WithEnv sets an environment variable of the guest. See WithEnvAllowlist.
+func WithEnv(key, value string) ProcessOption {
+	return func(c *processConfig) {
+		c.envSet = true
+		c.env = append(c.env, key+"="+value)
+	}
+}
+
+// WithDir sets the working directory of the guest.
+func WithDir(dir string) ProcessOption {
+	return func(c *processConfig) {
+		c.dir = dir
+	}
+}
+
+// WithStdio sets the guest's stdin and stdout. It requires WithRPCFDs, because stdio is used by ELRPC otherwise.
+func WithStdio(stdin io.Reader, stdout io.Writer) ProcessOption {
+	return func(c *processConfig) {
+		c.stdioSet = true
+		c.stdin = stdin
+		c.stdout = stdout
+	}
+}
+
+// WithStderr sends the guest's stderr to w.
+func WithStderr(w io.Writer) ProcessOption {
+	return func(c *processConfig) {
+		c.stderr = w
+		c.stderrLogger = nil
+	}
+}
+
+// WithStderrLogger logs each line that the guest writes to stderr. Lines longer than 4 KiB are split.
+func WithStderrLogger(logger *slog.Logger) ProcessOption {
+	return func(c *processConfig) {
+		c.stderr = nil
+		c.stderrLogger = logger
+	}
+}
+
+// WithSandbox runs the guest in a sandbox. The host program must call sandbox.Init at the beginning of main.
+func WithSandbox(conf *sandbox.Config) ProcessOption {
+	return func(c *processConfig) {
+		c.sandbox = conf
+	}
+}
+
+// NewProcessGuest creates a guest that talks ELRPC over its stdin and stdout.
+// It panics if it fails to create pipes; use NewProcessGuestWithOptions to handle the error.
 func NewProcessGuest(name string, args ...string) *ProcessGuest {
-	cmd := exec.Command(name, args...)
-	stdin, err := cmd.StdinPipe()
+	g, err := NewProcessGuestWithOptions(name, args)
 	if err != nil {
 		panic(err)
 	}
-	stdout, err := cmd.StdoutPipe()
+	return g
+}
+
+// NewProcessGuestFD is a shorthand for NewProcessGuestWithOptions with WithRPCFDs.
+// The guest's stdio is inherited from the host unless SetStdio is called.
+// It panics if it fails to create pipes.
+func NewProcessGuestFD(name string, args ...string) *ProcessGuest {
+	g, err := NewProcessGuestWithOptions(name, args, WithRPCFDs(), WithStdio(os.Stdin, os.Stdout))
 	if err != nil {
 		panic(err)
 	}
-	cmd.Stderr = os.Stderr
-	return &ProcessGuest{
-		cmd:    cmd,
-		stream: NewPipeStream(stdout, stdin),
+	return g
+}
+
+// NewProcessGuestWithOptions creates a guest that runs the given command.
+// By default, the guest inherits the host's environment, working directory and stderr.
+func NewProcessGuestWithOptions(name string, args []string, opts ...ProcessOption) (*ProcessGuest, error) {
+	conf := &processConfig{stderr: os.Stderr}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	if conf.stdioSet && !conf.rpcFDs {
+		return nil, errors.New("WithStdio requires WithRPCFDs")
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = conf.dir
+	if conf.envSet {
+		cmd.Env = append([]string{}, conf.env...)
+	} else {
+		cmd.Env = os.Environ()
+	}
+	if conf.rpcFDs {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d,%d", EnvRPCFDs, guestReadFD, guestWriteFD))
+	}
+	g := &ProcessGuest{cmd: cmd}
+	if conf.stderrLogger != nil {
+		g.stderrLogger = &logWriter{logger: conf.stderrLogger}
+		cmd.Stderr = g.stderrLogger
+	} else {
+		cmd.Stderr = conf.stderr
+	}
+	if conf.sandbox != nil {
+		sb, err := sandbox.Wrap(cmd, conf.sandbox)
+		if err != nil {
+			return nil, err
+		}
+		g.sandbox = sb
+	}
+
+	err := g.setupPipes(conf)
+	if err != nil {
+		g.releaseSandbox()
+		return nil, err
 	}
+	return g, nil
+}
+
+func (g *ProcessGuest) setupPipes(conf *processConfig) error {
+	cmd := g.cmd
+	if !conf.rpcFDs {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			stdin.Close()
+			return err
+		}
+		g.stream = NewPipeStream(stdout, stdin)
+		return nil
+	}
+
+	guestR, hostW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	hostR, guestW, err := os.Pipe()
+	if err != nil {
+		guestR.Close()
+		hostW.Close()
+		return err
+	}
+	// ExtraFiles[i] becomes file descriptor 3+i.
+	cmd.ExtraFiles = []*os.File{guestR, guestW}
+	cmd.Stdin = conf.stdin
+	cmd.Stdout = conf.stdout
+	g.stream = NewPipeStream(hostR, hostW)
+	g.childFiles = []*os.File{guestR, guestW}
+	g.hostFiles = []*os.File{hostR, hostW}
+	return nil
+}
+
+func (g *ProcessGuest) releaseSandbox() {
+	if g.sandbox != nil {
+		_ = g.sandbox.Release()
+	}
+}
+
+// SetStdio sets the guest's stdio. It must be called before Start, and only on guests that use WithRPCFDs.
+func (m *ProcessGuest) SetStdio(stdin io.Reader, stdout, stderr io.Writer) {
+	m.cmd.Stdin = stdin
+	m.cmd.Stdout = stdout
+	m.cmd.Stderr = stderr
 }
 
 func (m *ProcessGuest) Stream() Stream {
@@ -54,9 +260,155 @@ func (m *ProcessGuest) Stream() Stream {
 }
 
 func (m *ProcessGuest) Start() error {
-	return m.cmd.Start()
+	err := m.cmd.Start()
+	// The host must not hold the guest's ends; otherwise it never sees EOF when the guest exits.
+	for _, f := range m.childFiles {
+		f.Close()
+	}
+	if err != nil {
+		for _, f := range m.hostFiles {
+			f.Close()
+		}
+		m.releaseSandbox()
+	}
+	return err
 }
 
 func (m *ProcessGuest) Wait() error {
-	return m.cmd.Wait()
+	err := m.cmd.Wait()
+	for _, f := range m.hostFiles {
+		f.Close()
+	}
+	if m.stderrLogger != nil {
+		m.stderrLogger.flush()
+	}
+	if m.cmd.ProcessState != nil {
+		m.exitStatus = newExitStatus(m.cmd.ProcessState)
+		if m.sandbox != nil {
+			if m.sandbox.CPULimitExceeded(m.cmd.ProcessState) {
+				m.exitStatus.Reason = ExitReasonCPULimit
+			} else if m.sandbox.MemoryLimitExceeded() {
+				m.exitStatus.Reason = ExitReasonMemoryLimit
+			}
+		}
+	}
+	m.releaseSandbox()
+	return err
+}
+
+// Kill kills the guest process. It does nothing if the process has not started.
+func (m *ProcessGuest) Kill() {
+	if m.cmd.Process != nil {
+		_ = m.cmd.Process.Kill()
+	}
+}
+
+// ExitStatus returns how the guest exited and how much resource it used. It returns nil until Wait returns.
+func (m *ProcessGuest) ExitStatus() *ExitStatus {
+	return m.exitStatus
+}
+
+type ExitReason int
+
+const (
+	// The guest exited by itself.
+	ExitReasonExited ExitReason = iota
+	// The guest was terminated by a signal.
+	ExitReasonSignaled
+	// The guest exceeded sandbox.Limits.CPUTime.
+	ExitReasonCPULimit
+	// The guest was killed because it exceeded sandbox.Limits.Memory.
+	ExitReasonMemoryLimit
+	// The guest panicked. Only GoroutineGuest exits for this reason.
+	ExitReasonPanicked
+	// The interpreter guest executed as many instructions as it was allowed to.
+	ExitReasonFuelExhausted
+	// The interpreter guest ran past its deadline.
+	ExitReasonDeadlineExceeded
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitReasonExited:
+		return "exited"
+	case ExitReasonSignaled:
+		return "signaled"
+	case ExitReasonCPULimit:
+		return "cpu limit exceeded"
+	case ExitReasonMemoryLimit:
+		return "memory limit exceeded"
+	case ExitReasonPanicked:
+		return "panicked"
+	case ExitReasonFuelExhausted:
+		return "fuel exhausted"
+	case ExitReasonDeadlineExceeded:
+		return "deadline exceeded"
+	default:
+		return fmt.Sprintf("ExitReason(%d)", int(r))
+	}
+}
+
+type ExitStatus struct {
+	Reason ExitReason
+	// The exit code of the guest, or -1 if it was terminated by a signal.
+	ExitCode int
+	// The signal that terminated the guest, if any.
+	Signal     os.Signal
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// The maximum resident set size in bytes, or 0 if unknown.
+	MaxRSS int64
+	// The number of instructions executed by an interpreter guest, or 0 for other guests.
+	Steps uint64
+}
+
+func newExitStatus(ps *os.ProcessState) *ExitStatus {
+	st := &ExitStatus{
+		ExitCode:   ps.ExitCode(),
+		UserTime:   ps.UserTime(),
+		SystemTime: ps.SystemTime(),
+	}
+	st.Signal, st.MaxRSS = sysExitStatus(ps)
+	if st.Signal != nil {
+		st.Reason = ExitReasonSignaled
+	}
+	return st
+}
+
+// Longer lines are logged in pieces so that the guest cannot make the host buffer its stderr without limit.
+const maxLogLineLength = 4 << 10
+
+// logWriter logs each line written to it.
+type logWriter struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		switch {
+		case 0 <= i && i <= maxLogLineLength:
+			w.logger.Info("guest stderr", slog.String("line", string(w.buf[:i])))
+			w.buf = w.buf[i+1:]
+		case len(w.buf) > maxLogLineLength:
+			w.logger.Info("guest stderr", slog.String("line", string(w.buf[:maxLogLineLength])))
+			w.buf = w.buf[maxLogLineLength:]
+		default:
+			return len(p), nil
+		}
+	}
+}
+
+func (w *logWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.logger.Info("guest stderr", slog.String("line", string(w.buf)))
+		w.buf = nil
+	}
 }
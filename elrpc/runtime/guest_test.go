@@ -0,0 +1,341 @@
+package runtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elrpc/runtime/sandbox"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/slog"
+)
+
+const envHelperProcess = "ELSI_TEST_HELPER_PROCESS"
+
+func TestMain(m *testing.M) {
+	sandbox.Init()
+	os.Exit(m.Run())
+}
+
+// TestHelperProcess is not a real test. It is run as a guest by other tests.
+func TestHelperProcess(t *testing.T) {
+	var err error
+	switch os.Getenv(envHelperProcess) {
+	case "fd":
+		err = helperProcessFD()
+	case "env":
+		helperProcessEnv()
+	case "spin":
+		for {
+		}
+	case "longline":
+		fmt.Fprint(os.Stderr, strings.Repeat("x", 10000)+"\nend")
+	case "nofile":
+		helperProcessNofile()
+	case "alloc":
+		helperProcessAlloc()
+	case "ns":
+		err = helperProcessNamespaces()
+	case "seccomp":
+		err = helperProcessSeccomp()
+	case "write":
+		err = os.WriteFile("/data/elsi-test", nil, 0o644)
+	default:
+		t.Skip("not a helper process")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func helperProcessEnv() {
+	dir, _ := os.Getwd()
+	fmt.Fprintf(os.Stderr, "dir=%s\n", dir)
+	fmt.Fprintf(os.Stderr, "FOO=%s\n", os.Getenv("FOO"))
+	fmt.Fprintf(os.Stderr, "HOME=%s\n", os.Getenv("HOME"))
+	os.Exit(3)
+}
+
+func helperProcessNofile() {
+	for i := 0; i < 64; i++ {
+		_, err := os.Open(os.DevNull)
+		if err != nil {
+			os.Exit(4)
+		}
+	}
+	os.Exit(0)
+}
+
+var allocated [][]byte
+
+func helperProcessAlloc() {
+	for {
+		buf := make([]byte, 1<<20)
+		for i := range buf {
+			buf[i] = 1
+		}
+		allocated = append(allocated, buf)
+	}
+}
+
+func helperProcessNamespaces() error {
+	if pid := os.Getpid(); pid != 1 {
+		return fmt.Errorf("want pid 1 but got %d", pid)
+	}
+	if name, _ := os.Hostname(); name != "elsi" {
+		return fmt.Errorf("want hostname elsi but got %s", name)
+	}
+	if _, err := os.Stat("/etc/passwd"); !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("/etc/passwd should not be visible: %v", err)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	if len(ifaces) != 1 || ifaces[0].Flags&net.FlagLoopback == 0 {
+		return fmt.Errorf("want only the loopback interface but got %v", ifaces)
+	}
+	err = os.WriteFile("/usr/elsi-test", nil, 0o644)
+	if err == nil {
+		return errors.New("/usr should be read-only")
+	}
+	return nil
+}
+
+func helperProcessSeccomp() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err == nil {
+		ln.Close()
+		return errors.New("creating a socket should fail")
+	}
+	err = exec.Command("true").Run()
+	if err == nil {
+		return errors.New("executing another program should fail")
+	}
+	// Threads are still allowed.
+	done := make(chan struct{})
+	go func() {
+		goruntime.LockOSThread()
+		close(done)
+	}()
+	<-done
+	return nil
+}
+
+func helperProcessFD() error {
+	fds := strings.Split(os.Getenv(runtime.EnvRPCFDs), ",")
+	if len(fds) != 2 {
+		return fmt.Errorf("invalid %s: %q", runtime.EnvRPCFDs, os.Getenv(runtime.EnvRPCFDs))
+	}
+	rfd, err := strconv.Atoi(fds[0])
+	if err != nil {
+		return err
+	}
+	wfd, err := strconv.Atoi(fds[1])
+	if err != nil {
+		return err
+	}
+	s := runtime.NewPipeStream(os.NewFile(uintptr(rfd), "rpc-r"), os.NewFile(uintptr(wfd), "rpc-w"))
+
+	// Stdout is no longer used by ELRPC.
+	fmt.Fprint(os.Stdout, "raw output")
+
+	enc := message.NewEncoder()
+	err = enc.EncodeUint32(ModuleID)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeUint32(MethodID_HostAPI_Ping)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeString("Ping")
+	if err != nil {
+		return err
+	}
+	frame, err := message.AppendLength(nil, len(enc.Buffer()))
+	if err != nil {
+		return err
+	}
+	_, err = s.Write(append(frame, enc.Buffer()...))
+	if err != nil {
+		return err
+	}
+
+	lenBuf := make([]byte, message.LengthSize)
+	_, err = io.ReadFull(s, lenBuf)
+	if err != nil {
+		return err
+	}
+	length, err := message.DecodeLength(lenBuf)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(s, buf)
+	if err != nil {
+		return err
+	}
+	type Result = message.Result[*message.String, *message.Error]
+	got := &Result{}
+	err = got.UnmarshalELRPC(message.NewDecoder(buf))
+	if err != nil {
+		return err
+	}
+	if !got.IsOk || got.Ok.Value != "PingPong" {
+		return fmt.Errorf("unexpected response: %#v", got)
+	}
+	return nil
+}
+
+func TestProcessGuestFD(t *testing.T) {
+	t.Setenv(envHelperProcess, "fd")
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := runtime.NewProcessGuestFD(os.Args[0], "-test.run=^TestHelperProcess$")
+	stdout := &bytes.Buffer{}
+	guest.SetStdio(nil, stdout, os.Stderr)
+
+	rt := runtime.NewRuntime(logger, guest)
+	ImportHostAPI(rt, &hostAPIImpl{
+		pingImpl: func(arg *message.String) (*message.String, error) {
+			return &message.String{Value: arg.Value + "Pong"}, nil
+		},
+	})
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "raw output" {
+		t.Errorf("want %q but got %q", "raw output", got)
+	}
+}
+
+func TestProcessGuestFD_closesPipes(t *testing.T) {
+	countFDs := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip(err)
+		}
+		return len(fds)
+	}
+	before := countFDs()
+	for i := 0; i < 3; i++ {
+		guest, err := runtime.NewProcessGuestWithOptions(os.Args[0], []string{"-test.run=^TestHelperProcess$"},
+			runtime.WithRPCFDs(),
+			runtime.WithEnv(envHelperProcess, "env"),
+			runtime.WithStderr(io.Discard),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = guest.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = guest.Wait()
+	}
+	if after := countFDs(); after != before {
+		t.Errorf("want %d open files but got %d", before, after)
+	}
+}
+
+func TestProcessGuestWithOptions(t *testing.T) {
+	t.Setenv("HOME", "/nonexistent")
+	dir := t.TempDir()
+	stderr := &bytes.Buffer{}
+	guest, err := runtime.NewProcessGuestWithOptions(os.Args[0], []string{"-test.run=^TestHelperProcess$"},
+		runtime.WithEnv(envHelperProcess, "env"),
+		runtime.WithEnv("FOO", "bar"),
+		runtime.WithDir(dir),
+		runtime.WithStderr(stderr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.ExitStatus() != nil {
+		t.Errorf("want nil but got %#v", guest.ExitStatus())
+	}
+	err = guest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Wait()
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+
+	want := fmt.Sprintf("dir=%s\nFOO=bar\nHOME=\n", dir)
+	if got := stderr.String(); got != want {
+		t.Errorf("want %q but got %q", want, got)
+	}
+	st := guest.ExitStatus()
+	if st == nil {
+		t.Fatal("want exit status but got nil")
+	}
+	if st.ExitCode != 3 {
+		t.Errorf("want exit code 3 but got %d", st.ExitCode)
+	}
+	if st.Signal != nil {
+		t.Errorf("want no signal but got %s", st.Signal)
+	}
+}
+
+func TestWithStderrLogger(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+	guest, err := runtime.NewProcessGuestWithOptions(os.Args[0], []string{"-test.run=^TestHelperProcess$"},
+		runtime.WithEnv(envHelperProcess, "longline"),
+		runtime.WithStderrLogger(logger),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	dec := json.NewDecoder(logs)
+	for dec.More() {
+		var record struct{ Line string }
+		err := dec.Decode(&record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record.Line)
+	}
+	// The long line is split into 4 KiB pieces.
+	want := []string{strings.Repeat("x", 4096), strings.Repeat("x", 4096), strings.Repeat("x", 1808), "end"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestNewProcessGuestWithOptions_stdioWithoutRPCFDs(t *testing.T) {
+	_, err := runtime.NewProcessGuestWithOptions("true", nil, runtime.WithStdio(nil, nil))
+	if err == nil {
+		t.Fatal("want error but got nil")
+	}
+}
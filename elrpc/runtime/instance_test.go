@@ -1,15 +1,18 @@
 package runtime_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/genkami/elsi/elrpc/api/builtin"
 	"github.com/genkami/elsi/elrpc/apibuilder"
 	"github.com/genkami/elsi/elrpc/elrpctest"
 	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/mux"
 	"github.com/genkami/elsi/elrpc/runtime"
 	"github.com/genkami/elsi/elrpc/types"
 	"github.com/google/go-cmp/cmp"
@@ -285,8 +288,311 @@ func TestInstance_callGuestAPI(t *testing.T) {
 	}
 }
 
+func TestInstance_callGuestAPI_deadlineExceeded(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest never polls the method call.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = rt.CallContext(ctx, ModuleID, MethodID_GuestAPI_Ping, &message.Any{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestInstance_callGuestAPI_guestExited(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := rt.Call(ModuleID, MethodID_GuestAPI_Ping, &message.Any{})
+		errCh <- err
+	}()
+
+	// Emulate the guest's exit.
+	guest.Close()
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeUnavailable)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestInstance_callGuestAPI_push(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest)
+	guestAPI := ExportGuestAPI(rt)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := guest.GuestStream()
+
+	// Call builtin.Exporter.EnablePush
+	type EnablePushResult = message.Result[message.Void, *message.Error]
+	respDec := callHostAPI(t, s, builtin.ModuleID, builtin.MethodID_Exporter_EnablePush)
+	enablePushResult := &EnablePushResult{}
+	err = enablePushResult.UnmarshalELRPC(respDec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enablePushResult.IsOk {
+		t.Fatalf("want ok but got %#v", enablePushResult)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		got, err := guestAPI.Ping(&message.String{Value: "Ping"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Value != "Pong" {
+			t.Errorf("want Pong but got %s", got.Value)
+		}
+		return nil
+	})
+
+	// The method call is pushed without polling.
+	frameDec := receiveFrame(t, s)
+	kind, err := frameDec.DecodeUint8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != builtin.FrameKindMethodCall {
+		t.Fatalf("want frame kind %X but got %X", builtin.FrameKindMethodCall, kind)
+	}
+	mCall := &builtin.MethodCall{}
+	err = mCall.UnmarshalELRPC(frameDec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mCall.ModuleID != ModuleID || mCall.MethodID != MethodID_GuestAPI_Ping {
+		t.Fatalf("want (mod = %X, method = %X) but got (mod = %X, method = %X)",
+			ModuleID, MethodID_GuestAPI_Ping, mCall.ModuleID, mCall.MethodID)
+	}
+
+	// Call builtin.Exporter.SendResult
+	rvEnc := message.NewEncoder()
+	err = rvEnc.EncodeString("Pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendRequest(
+		t, s,
+		builtin.ModuleID, builtin.MethodID_Exporter_SendResult,
+		&builtin.MethodResult{
+			CallID: mCall.CallID,
+			RetVal: &message.Result[*message.Any, *message.Error]{
+				IsOk: true,
+				Ok:   &message.Any{Raw: rvEnc.Buffer()},
+			},
+		},
+	)
+	frameDec = receiveFrame(t, s)
+	kind, err = frameDec.DecodeUint8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != builtin.FrameKindResponse {
+		t.Fatalf("want frame kind %X but got %X", builtin.FrameKindResponse, kind)
+	}
+	type SendResultResult = message.Result[message.Void, *message.Error]
+	sendResultResult := &SendResultResult{}
+	err = sendResultResult.UnmarshalELRPC(frameDec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sendResultResult.IsOk {
+		t.Fatalf("want ok but got %#v", sendResultResult)
+	}
+
+	err = eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstance_multiplexed(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest, runtime.WithMultiplexing(nil))
+	ImportHostAPI(rt, &hostAPIImpl{
+		pingImpl: func(arg *message.String) (*message.String, error) {
+			return &message.String{Value: arg.Value + "Pong"}, nil
+		},
+	})
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess := mux.Client(guest.GuestStream(), nil)
+	var eg errgroup.Group
+	for _, prefix := range []string{"a", "b", "c"} {
+		prefix := prefix
+		ch, err := sess.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		eg.Go(func() error {
+			type Result = message.Result[*message.String, *message.Error]
+			respDec := callHostAPI(t, ch, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: prefix})
+			got := &Result{}
+			err := got.UnmarshalELRPC(respDec)
+			if err != nil {
+				return err
+			}
+			want := &Result{IsOk: true, Ok: &message.String{Value: prefix + "Pong"}}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+			return nil
+		})
+	}
+
+	err = eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstance_metadata(t *testing.T) {
+	type Result = message.Result[*message.String, *message.Error]
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest)
+	rt.Use(ModuleID, MethodID_HostAPI_Ping, apibuilder.ContextHostHandler1[*message.String, *message.String](
+		func(ctx context.Context, arg *message.String) (*message.String, error) {
+			md, ok := builtin.MetadataFromContext(ctx)
+			if !ok {
+				return nil, errors.New("no metadata")
+			}
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, errors.New("no deadline")
+			}
+			user, _ := md.Tag("user")
+			return &message.String{Value: arg.Value + string(md.TraceID) + user}, nil
+		}))
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := guest.GuestStream()
+
+	deadline := time.Now().Add(time.Minute)
+	md := &builtin.Metadata{
+		Deadline: &message.Option[*message.Int64]{IsSome: true, Some: &message.Int64{Value: deadline.UnixMilli()}},
+		TraceID:  []byte("trace"),
+		Tags:     []*builtin.Tag{{Key: "user", Value: "alice"}},
+	}
+	sendRequestWithMetadata(t, s, md, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: "Ping"})
+	got := &Result{}
+	err = got.UnmarshalELRPC(receiveFrame(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Result{IsOk: true, Ok: &message.String{Value: "Pingtracealice"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Requests without metadata are still accepted.
+	sendRequest(t, s, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: "Ping"})
+	got = &Result{}
+	err = got.UnmarshalELRPC(receiveFrame(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IsOk {
+		t.Errorf("want error but got %#v", got)
+	}
+}
+
+func TestInstance_metadata_deadlineExceeded(t *testing.T) {
+	type Result = message.Result[*message.String, *message.Error]
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := elrpctest.NewTestGuest(t)
+	defer guest.Close()
+
+	rt := runtime.NewRuntime(logger, guest)
+	ImportHostAPI(rt, &hostAPIImpl{
+		pingImpl: func(arg *message.String) (*message.String, error) {
+			t.Error("handler should not be called")
+			return arg, nil
+		},
+	})
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := guest.GuestStream()
+
+	md := &builtin.Metadata{
+		Deadline: &message.Option[*message.Int64]{IsSome: true, Some: &message.Int64{Value: time.Now().Add(-time.Second).UnixMilli()}},
+	}
+	sendRequestWithMetadata(t, s, md, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: "Ping"})
+	got := &Result{}
+	err = got.UnmarshalELRPC(receiveFrame(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IsOk {
+		t.Fatalf("want error but got %#v", got)
+	}
+	elrpctest.AssertError(t, got.Err, builtin.ModuleID, builtin.CodeDeadlineExceeded)
+}
+
 func callHostAPI(t *testing.T, s runtime.Stream, modID, methodID uint32, args ...message.Message) *message.Decoder {
+	sendRequest(t, s, modID, methodID, args...)
+	return receiveFrame(t, s)
+}
+
+func sendRequest(t *testing.T, s runtime.Stream, modID, methodID uint32, args ...message.Message) {
+	sendRequestWithMetadata(t, s, nil, modID, methodID, args...)
+}
+
+func sendRequestWithMetadata(t *testing.T, s runtime.Stream, md *builtin.Metadata, modID, methodID uint32, args ...message.Message) {
 	enc := message.NewEncoder()
+	if md != nil {
+		mdEnc := message.NewEncoder()
+		err := md.MarshalELRPC(mdEnc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = enc.EncodeAny(&message.Any{Raw: mdEnc.Buffer()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 	err := enc.EncodeUint32(modID)
 	if err != nil {
 		t.Fatal(err)
@@ -314,10 +620,11 @@ func callHostAPI(t *testing.T, s runtime.Stream, modID, methodID uint32, args ..
 	if err != nil {
 		t.Fatal(err)
 	}
+}
 
-	// Receive the response
-	lenBuf = make([]byte, message.LengthSize)
-	_, err = io.ReadFull(s, lenBuf)
+func receiveFrame(t *testing.T, s runtime.Stream) *message.Decoder {
+	lenBuf := make([]byte, message.LengthSize)
+	_, err := io.ReadFull(s, lenBuf)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -325,7 +632,7 @@ func callHostAPI(t *testing.T, s runtime.Stream, modID, methodID uint32, args ..
 	if err != nil {
 		t.Fatal(err)
 	}
-	buf = make([]byte, respLen)
+	buf := make([]byte, respLen)
 	_, err = io.ReadFull(s, buf)
 	if err != nil {
 		t.Fatal(err)
@@ -1,13 +1,25 @@
 package builtinimpl
 
 import (
+	"context"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/genkami/elsi/elrpc/api/builtin"
 	"github.com/genkami/elsi/elrpc/message"
 	"golang.org/x/exp/slog"
 )
 
+// Longer timeouts of PollMethodCalls are clamped so that they do not overflow.
+const maxPollTimeout = time.Duration(math.MaxInt64)
+
+var errGuestExited = &message.Error{
+	ModuleID: builtin.ModuleID,
+	Code:     builtin.CodeUnavailable,
+	Message:  "guest exited",
+}
+
 type CallResult struct {
 	RetVal *message.Result[*message.Any, *message.Error]
 }
@@ -18,6 +30,8 @@ type Exporter struct {
 	waiters   map[uint64]chan<- CallResult
 	callQueue []*builtin.MethodCall
 	next      uint64
+	closed    bool
+	ready     chan struct{} // closed and replaced whenever callQueue gets a new item or the exporter is closed
 }
 
 var _ builtin.Exporter = &Exporter{}
@@ -26,9 +40,19 @@ func NewExporter(logger *slog.Logger) *Exporter {
 	return &Exporter{
 		logger:  logger,
 		waiters: make(map[uint64]chan<- CallResult),
+		ready:   make(chan struct{}),
 	}
 }
 
+// signal wakes up all goroutines that are waiting for method calls.
+// e.mu must be held by the caller.
+func (e *Exporter) signal() {
+	close(e.ready)
+	e.ready = make(chan struct{})
+}
+
+// CallAsync enqueues the method call and returns a channel that receives its result.
+// If the exporter is already closed, the channel immediately receives an error.
 func (e *Exporter) CallAsync(call *builtin.MethodCall) <-chan CallResult {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -36,11 +60,77 @@ func (e *Exporter) CallAsync(call *builtin.MethodCall) <-chan CallResult {
 	id := e.next
 	e.next++
 	call.CallID = id
+	if e.closed {
+		ch <- CallResult{&message.Result[*message.Any, *message.Error]{IsOk: false, Err: errGuestExited}}
+		return ch
+	}
 	e.callQueue = append(e.callQueue, call)
 	e.waiters[id] = ch
+	e.signal()
 	return ch
 }
 
+// Cancel abandons the method call. If the guest has not polled the call yet, it is just removed from the queue.
+// Otherwise the guest is notified by MethodID_Notification_CancelCall.
+func (e *Exporter) Cancel(callID uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.waiters[callID]; !ok {
+		return
+	}
+	delete(e.waiters, callID)
+	if e.closed {
+		return
+	}
+	for i, call := range e.callQueue {
+		if call.CallID == callID {
+			e.callQueue = append(e.callQueue[:i:i], e.callQueue[i+1:]...)
+			return
+		}
+	}
+
+	enc := message.NewEncoder()
+	_ = enc.EncodeUint64(callID)
+	id := e.next
+	e.next++
+	e.callQueue = append(e.callQueue, &builtin.MethodCall{
+		CallID:   id,
+		ModuleID: builtin.ModuleID,
+		MethodID: builtin.MethodID_Notification_CancelCall,
+		Args:     &message.Any{Raw: enc.Buffer()},
+	})
+	e.signal()
+}
+
+// Requeue puts the method calls that could not be delivered back to the head of the queue.
+func (e *Exporter) Requeue(calls []*builtin.MethodCall) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed || len(calls) == 0 {
+		return
+	}
+	queue := make([]*builtin.MethodCall, 0, len(calls)+len(e.callQueue))
+	queue = append(queue, calls...)
+	e.callQueue = append(queue, e.callQueue...)
+	e.signal()
+}
+
+// Close fails all pending method calls. It should be called once the guest exits.
+func (e *Exporter) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	for id, ch := range e.waiters {
+		ch <- CallResult{&message.Result[*message.Any, *message.Error]{IsOk: false, Err: errGuestExited}}
+		delete(e.waiters, id)
+	}
+	e.callQueue = nil
+	e.signal()
+}
+
 func (e *Exporter) PollMethodCall() (*builtin.MethodCall, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -68,6 +158,63 @@ func (e *Exporter) SendResult(m *builtin.MethodResult) (message.Void, error) {
 			Message:  "no such method call",
 		}
 	}
+	delete(e.waiters, m.CallID)
 	ch <- CallResult{m.RetVal}
 	return message.Void{}, nil
 }
+
+// EnablePush only checks that the exporter is still open; the runtime switches the connection to push mode
+// once it has sent the response.
+func (e *Exporter) EnablePush() (message.Void, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return message.Void{}, errGuestExited
+	}
+	return message.Void{}, nil
+}
+
+func (e *Exporter) PollMethodCalls(timeoutMillis *message.Uint64, maxCalls *message.Uint64) (*message.Array[*builtin.MethodCall], error) {
+	timeout := maxPollTimeout
+	if timeoutMillis.Value < uint64(maxPollTimeout/time.Millisecond) {
+		timeout = time.Duration(timeoutMillis.Value) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	calls, err := e.WaitMethodCalls(ctx, int(maxCalls.Value))
+	if err != nil {
+		return nil, err
+	}
+	return &message.Array[*builtin.MethodCall]{Items: calls}, nil
+}
+
+// WaitMethodCalls blocks until any method call is queued or ctx is done, and then dequeues at most maxCalls calls (0 means no limit).
+// It returns an empty slice if ctx is done before any method call is queued.
+func (e *Exporter) WaitMethodCalls(ctx context.Context, maxCalls int) ([]*builtin.MethodCall, error) {
+	for {
+		e.mu.Lock()
+		if e.closed {
+			e.mu.Unlock()
+			return nil, errGuestExited
+		}
+		if len(e.callQueue) > 0 {
+			n := len(e.callQueue)
+			if 0 < maxCalls && maxCalls < n {
+				n = maxCalls
+			}
+			calls := make([]*builtin.MethodCall, n)
+			copy(calls, e.callQueue)
+			e.callQueue = e.callQueue[n:]
+			e.mu.Unlock()
+			return calls, nil
+		}
+		ready := e.ready
+		e.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return []*builtin.MethodCall{}, nil
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package builtinimpl_test
 
 import (
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -218,3 +219,225 @@ func TestExporterImpl_SendResult_err(t *testing.T) {
 		t.Fatal("timeout")
 	}
 }
+
+func TestExporterImpl_SendResult_duplicate(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	call := &builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	}
+	_ = e.CallAsync(call)
+
+	_, err := e.PollMethodCall()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &builtin.MethodResult{
+		CallID: call.CallID,
+		RetVal: &message.Result[*message.Any, *message.Error]{
+			IsOk: true,
+			Ok:   &message.Any{},
+		},
+	}
+	_, err = e.SendResult(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.SendResult(result)
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeNotFound)
+}
+
+func TestExporterImpl_Cancel_queued(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	call := &builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	}
+	_ = e.CallAsync(call)
+	e.Cancel(call.CallID)
+
+	_, err := e.PollMethodCall()
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeNotFound)
+}
+
+func TestExporterImpl_Cancel_polled(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	call := &builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	}
+	_ = e.CallAsync(call)
+
+	_, err := e.PollMethodCall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Cancel(call.CallID)
+
+	got, err := e.PollMethodCall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ModuleID != builtin.ModuleID || got.MethodID != builtin.MethodID_Notification_CancelCall {
+		t.Errorf("want (mod = %X, method = %X) but got (mod = %X, method = %X)",
+			builtin.ModuleID, builtin.MethodID_Notification_CancelCall, got.ModuleID, got.MethodID)
+	}
+	dec := message.NewDecoder(got.Args.Raw)
+	canceledID, err := dec.DecodeUint64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceledID != call.CallID {
+		t.Errorf("want %d but got %d", call.CallID, canceledID)
+	}
+
+	_, err = e.SendResult(&builtin.MethodResult{
+		CallID: call.CallID,
+		RetVal: &message.Result[*message.Any, *message.Error]{
+			IsOk: true,
+			Ok:   &message.Any{},
+		},
+	})
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeNotFound)
+}
+
+func TestExporterImpl_Close(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	call := &builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	}
+	resultCh := e.CallAsync(call)
+	e.Close()
+
+	select {
+	case r := <-resultCh:
+		if r.RetVal.IsOk {
+			t.Fatalf("want error but got %#v", r.RetVal.Ok)
+		}
+		elrpctest.AssertError(t, r.RetVal.Err, builtin.ModuleID, builtin.CodeUnavailable)
+	case <-time.After(timeout):
+		t.Fatal("timeout")
+	}
+
+	// Calls after Close fail immediately.
+	resultCh = e.CallAsync(&builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	})
+	select {
+	case r := <-resultCh:
+		if r.RetVal.IsOk {
+			t.Fatalf("want error but got %#v", r.RetVal.Ok)
+		}
+		elrpctest.AssertError(t, r.RetVal.Err, builtin.ModuleID, builtin.CodeUnavailable)
+	case <-time.After(timeout):
+		t.Fatal("timeout")
+	}
+}
+
+func TestExporterImpl_PollMethodCalls_timeout(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+	got, err := e.PollMethodCalls(&message.Uint64{Value: 10}, &message.Uint64{Value: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 0 {
+		t.Errorf("want no method calls but got %#v", got.Items)
+	}
+}
+
+func TestExporterImpl_PollMethodCalls_wait(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	call := &builtin.MethodCall{
+		ModuleID: ModuleID,
+		MethodID: MethodID_Nop_Nop,
+		Args:     &message.Any{},
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = e.CallAsync(call)
+	}()
+
+	got, err := e.PollMethodCalls(&message.Uint64{Value: uint64(timeout.Milliseconds())}, &message.Uint64{Value: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 1 {
+		t.Fatalf("want 1 method call but got %#v", got.Items)
+	}
+	if got.Items[0].ModuleID != ModuleID || got.Items[0].MethodID != MethodID_Nop_Nop {
+		t.Errorf("want (mod = %X, method = %X) but got (mod = %X, method = %X)",
+			ModuleID, MethodID_Nop_Nop, got.Items[0].ModuleID, got.Items[0].MethodID)
+	}
+}
+
+func TestExporterImpl_PollMethodCalls_longTimeout(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = e.CallAsync(&builtin.MethodCall{
+			ModuleID: ModuleID,
+			MethodID: MethodID_Nop_Nop,
+			Args:     &message.Any{},
+		})
+	}()
+
+	// The timeout would overflow time.Duration.
+	got, err := e.PollMethodCalls(&message.Uint64{Value: math.MaxUint64}, &message.Uint64{Value: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 1 {
+		t.Fatalf("want 1 method call but got %#v", got.Items)
+	}
+}
+
+func TestExporterImpl_PollMethodCalls_batch(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+
+	for i := 0; i < 3; i++ {
+		_ = e.CallAsync(&builtin.MethodCall{
+			ModuleID: ModuleID,
+			MethodID: MethodID_Nop_Nop,
+			Args:     &message.Any{},
+		})
+	}
+
+	got, err := e.PollMethodCalls(&message.Uint64{Value: 0}, &message.Uint64{Value: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 2 {
+		t.Fatalf("want 2 method calls but got %#v", got.Items)
+	}
+	got, err = e.PollMethodCalls(&message.Uint64{Value: 0}, &message.Uint64{Value: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 1 {
+		t.Fatalf("want 1 method call but got %#v", got.Items)
+	}
+}
+
+func TestExporterImpl_PollMethodCalls_closed(t *testing.T) {
+	e := builtinimpl.NewExporter(logger)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		e.Close()
+	}()
+
+	_, err := e.PollMethodCalls(&message.Uint64{Value: uint64(timeout.Milliseconds())}, &message.Uint64{Value: 0})
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeUnavailable)
+}
@@ -0,0 +1,254 @@
+package builtinimpl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/types"
+	"golang.org/x/exp/slog"
+)
+
+// The number of messages that can be buffered in each direction of a stream.
+const streamBufferSize = 16
+
+var (
+	errNoSuchStream = &message.Error{
+		ModuleID: builtin.ModuleID,
+		Code:     builtin.CodeNotFound,
+		Message:  "no such stream",
+	}
+	errSendClosed = &message.Error{
+		ModuleID: builtin.ModuleID,
+		Code:     builtin.CodeInvalidRequest,
+		Message:  "stream is already closed for sending",
+	}
+)
+
+type Streaming struct {
+	logger  *slog.Logger
+	mu      sync.Mutex
+	next    uint64
+	streams map[uint64]*stream
+	closed  bool
+}
+
+var _ builtin.Streaming = (*Streaming)(nil)
+
+type stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	out  chan *message.Any // from the handler to the guest
+	done chan struct{}     // closed when the handler returns
+	err  *message.Error    // the handler's error; valid after done is closed
+
+	in         chan *message.Any // from the guest to the handler
+	inClosed   chan struct{}     // closed by CloseSend
+	sendClosed bool              // guarded by Streaming.mu
+}
+
+var _ types.ServerStream = (*stream)(nil)
+
+func NewStreaming(logger *slog.Logger) *Streaming {
+	return &Streaming{
+		logger:  logger,
+		streams: make(map[uint64]*stream),
+	}
+}
+
+// Start runs the handler in a new goroutine and returns the ID of the stream.
+func (s *Streaming) Start(ctx context.Context, handler types.StreamHandler, dec *message.Decoder) (uint64, error) {
+	var cancel context.CancelFunc
+	md, _ := builtin.MetadataFromContext(ctx)
+	if deadline, ok := md.DeadlineTime(); ok {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	st := &stream{
+		ctx:      ctx,
+		cancel:   cancel,
+		out:      make(chan *message.Any, streamBufferSize),
+		done:     make(chan struct{}),
+		in:       make(chan *message.Any, streamBufferSize),
+		inClosed: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		return 0, errGuestExited
+	}
+	s.next++
+	id := s.next
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	go func() {
+		defer close(st.done)
+		err := handler.HandleStream(ctx, dec, st)
+		if err != nil {
+			s.logger.Error("stream error", slog.Uint64("stream_id", id), slog.String("error", err.Error()))
+			st.err = ToELRPCError(err)
+		}
+	}()
+	return id, nil
+}
+
+// Close cancels all streams. It should be called once the guest exits.
+func (s *Streaming) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for id, st := range s.streams {
+		st.cancel()
+		delete(s.streams, id)
+	}
+}
+
+func (s *Streaming) get(id uint64) (*stream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[id]
+	return st, ok
+}
+
+func (s *Streaming) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[id]
+	if !ok {
+		return
+	}
+	st.cancel()
+	delete(s.streams, id)
+}
+
+func (s *Streaming) Recv(streamID *message.Uint64) (*message.Option[*message.Any], error) {
+	st, ok := s.get(streamID.Value)
+	if !ok {
+		return nil, errNoSuchStream
+	}
+	select {
+	case m := <-st.out:
+		return &message.Option[*message.Any]{IsSome: true, Some: m}, nil
+	case <-st.done:
+	case <-st.ctx.Done():
+		return nil, errNoSuchStream
+	}
+
+	// The handler may have sent messages right before returning.
+	select {
+	case m := <-st.out:
+		return &message.Option[*message.Any]{IsSome: true, Some: m}, nil
+	default:
+	}
+	s.remove(streamID.Value)
+	if st.err != nil {
+		return nil, st.err
+	}
+	return &message.Option[*message.Any]{IsSome: false}, nil
+}
+
+func (s *Streaming) Send(streamID *message.Uint64, data *message.Any) (message.Void, error) {
+	s.mu.Lock()
+	st, ok := s.streams[streamID.Value]
+	sendClosed := ok && st.sendClosed
+	s.mu.Unlock()
+	if !ok {
+		return message.Void{}, errNoSuchStream
+	}
+	if sendClosed {
+		return message.Void{}, errSendClosed
+	}
+
+	select {
+	case st.in <- data:
+		return message.Void{}, nil
+	case <-st.done:
+		// The handler is no longer interested in messages from the guest.
+		return message.Void{}, nil
+	case <-st.ctx.Done():
+		return message.Void{}, errNoSuchStream
+	}
+}
+
+func (s *Streaming) CloseSend(streamID *message.Uint64) (message.Void, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[streamID.Value]
+	if !ok {
+		return message.Void{}, errNoSuchStream
+	}
+	if !st.sendClosed {
+		st.sendClosed = true
+		close(st.inClosed)
+	}
+	return message.Void{}, nil
+}
+
+func (s *Streaming) Cancel(streamID *message.Uint64) (message.Void, error) {
+	_, ok := s.get(streamID.Value)
+	if !ok {
+		return message.Void{}, errNoSuchStream
+	}
+	s.remove(streamID.Value)
+	return message.Void{}, nil
+}
+
+func (st *stream) Send(m message.Message) error {
+	enc := message.NewEncoder()
+	err := m.MarshalELRPC(enc)
+	if err != nil {
+		return err
+	}
+	select {
+	case st.out <- &message.Any{Raw: enc.Buffer()}:
+		return nil
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+func (st *stream) Recv() (*message.Any, error) {
+	select {
+	case m := <-st.in:
+		return m, nil
+	case <-st.inClosed:
+	case <-st.ctx.Done():
+		return nil, st.ctx.Err()
+	}
+
+	// The guest may have sent messages right before closing.
+	select {
+	case m := <-st.in:
+		return m, nil
+	default:
+		return nil, io.EOF
+	}
+}
+
+// ToELRPCError converts an error returned by a handler into the one that is sent to the guest.
+func ToELRPCError(err error) *message.Error {
+	var elrpcErr *message.Error
+	if errors.As(err, &elrpcErr) {
+		return elrpcErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeDeadlineExceeded,
+			Message:  err.Error(),
+		}
+	}
+	return &message.Error{
+		ModuleID: builtin.ModuleID,
+		Code:     builtin.CodeInternal,
+		Message:  err.Error(),
+	}
+}
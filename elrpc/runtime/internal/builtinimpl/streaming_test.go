@@ -0,0 +1,170 @@
+package builtinimpl_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/elrpctest"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime/internal/builtinimpl"
+	"github.com/genkami/elsi/elrpc/types"
+)
+
+type streamHandlerFunc func(context.Context, *message.Decoder, types.ServerStream) error
+
+func (f streamHandlerFunc) HandleStream(ctx context.Context, dec *message.Decoder, s types.ServerStream) error {
+	return f(ctx, dec, s)
+}
+
+func recvString(t *testing.T, s *builtinimpl.Streaming, id uint64) (string, bool) {
+	t.Helper()
+	got, err := s.Recv(&message.Uint64{Value: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsSome {
+		return "", false
+	}
+	v, err := message.NewDecoder(got.Some.Raw).DecodeString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, true
+}
+
+func TestStreaming_serverStreaming(t *testing.T) {
+	s := builtinimpl.NewStreaming(logger)
+	id, err := s.Start(context.Background(), streamHandlerFunc(func(ctx context.Context, dec *message.Decoder, st types.ServerStream) error {
+		for _, v := range []string{"a", "b", "c"} {
+			err := st.Send(&message.String{Value: v})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}), message.NewDecoder(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		got, ok := recvString(t, s, id)
+		if !ok {
+			t.Fatalf("want %s but got end of stream", want)
+		}
+		if got != want {
+			t.Errorf("want %s but got %s", want, got)
+		}
+	}
+	_, ok := recvString(t, s, id)
+	if ok {
+		t.Error("want end of stream")
+	}
+
+	// The stream is removed after its end is received.
+	_, err = s.Recv(&message.Uint64{Value: id})
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeNotFound)
+}
+
+func TestStreaming_error(t *testing.T) {
+	s := builtinimpl.NewStreaming(logger)
+	id, err := s.Start(context.Background(), streamHandlerFunc(func(ctx context.Context, dec *message.Decoder, st types.ServerStream) error {
+		return &message.Error{ModuleID: ModuleID, Code: CodeFoo, Message: "foo"}
+	}), message.NewDecoder(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Recv(&message.Uint64{Value: id})
+	elrpctest.AssertError(t, err, ModuleID, CodeFoo)
+}
+
+func TestStreaming_clientStreaming(t *testing.T) {
+	s := builtinimpl.NewStreaming(logger)
+	id, err := s.Start(context.Background(), streamHandlerFunc(func(ctx context.Context, dec *message.Decoder, st types.ServerStream) error {
+		var all string
+		for {
+			m, err := st.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			v, err := message.NewDecoder(m.Raw).DecodeString()
+			if err != nil {
+				return err
+			}
+			all += v
+		}
+		return st.Send(&message.String{Value: all})
+	}), message.NewDecoder(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"a", "b", "c"} {
+		enc := message.NewEncoder()
+		_ = enc.EncodeString(v)
+		_, err = s.Send(&message.Uint64{Value: id}, &message.Any{Raw: enc.Buffer()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.CloseSend(&message.Uint64{Value: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Send(&message.Uint64{Value: id}, &message.Any{})
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeInvalidRequest)
+
+	got, ok := recvString(t, s, id)
+	if !ok {
+		t.Fatal("want result but got end of stream")
+	}
+	if got != "abc" {
+		t.Errorf("want abc but got %s", got)
+	}
+	_, ok = recvString(t, s, id)
+	if ok {
+		t.Error("want end of stream")
+	}
+}
+
+func TestStreaming_Cancel(t *testing.T) {
+	s := builtinimpl.NewStreaming(logger)
+	canceled := make(chan struct{})
+	id, err := s.Start(context.Background(), streamHandlerFunc(func(ctx context.Context, dec *message.Decoder, st types.ServerStream) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}), message.NewDecoder(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Cancel(&message.Uint64{Value: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(timeout):
+		t.Fatal("timeout")
+	}
+	_, err = s.Recv(&message.Uint64{Value: id})
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeNotFound)
+}
+
+func TestStreaming_Close(t *testing.T) {
+	s := builtinimpl.NewStreaming(logger)
+	s.Close()
+	_, err := s.Start(context.Background(), streamHandlerFunc(func(ctx context.Context, dec *message.Decoder, st types.ServerStream) error {
+		return nil
+	}), message.NewDecoder(nil))
+	elrpctest.AssertError(t, err, builtin.ModuleID, builtin.CodeUnavailable)
+}
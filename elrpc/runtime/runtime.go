@@ -1,15 +1,19 @@
 package runtime
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/slog"
 
 	"github.com/genkami/elsi/elrpc/api/builtin"
 	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/mux"
 	"github.com/genkami/elsi/elrpc/runtime/internal/builtinimpl"
 	"github.com/genkami/elsi/elrpc/types"
 )
@@ -20,19 +24,45 @@ type Runtime struct {
 	guest    Guest
 	exporter *builtinimpl.Exporter
 	wg       sync.WaitGroup
+
+	streamHandlers map[uint64]types.StreamHandler // a map from full method ID to its handler
+	streaming      *builtinimpl.Streaming
+
+	multiplexed bool
+	muxConfig   *mux.Config
 }
 
 var _ types.Runtime = (*Runtime)(nil)
 
-func NewRuntime(logger *slog.Logger, guest Guest) *Runtime {
+type Option func(*Runtime)
+
+// WithMultiplexing makes the runtime speak the protocol of the mux package over the guest's stream.
+// Each channel opened by the guest is served as an independent request/response conversation.
+func WithMultiplexing(conf *mux.Config) Option {
+	return func(rt *Runtime) {
+		rt.multiplexed = true
+		rt.muxConfig = conf
+	}
+}
+
+func NewRuntime(logger *slog.Logger, guest Guest, opts ...Option) *Runtime {
 	exporter := builtinimpl.NewExporter(logger)
+	streaming := builtinimpl.NewStreaming(logger)
 	rt := &Runtime{
-		logger:   logger,
-		handlers: make(map[uint64]types.HostHandler),
-		guest:    guest,
-		exporter: exporter,
+		logger:         logger,
+		handlers:       make(map[uint64]types.HostHandler),
+		guest:          guest,
+		exporter:       exporter,
+		streamHandlers: make(map[uint64]types.StreamHandler),
+		streaming:      streaming,
 	}
-	_ = builtin.UseWorld(rt, exporter)
+	for _, opt := range opts {
+		opt(rt)
+	}
+	_ = builtin.UseWorld(rt, &builtin.Imports{
+		Exporter:  exporter,
+		Streaming: streaming,
+	})
 	return rt
 }
 
@@ -40,6 +70,25 @@ func (rt *Runtime) Use(moduleID, methodID uint32, h types.HostHandler) {
 	rt.handlers[fullID(moduleID, methodID)] = h
 }
 
+func (rt *Runtime) UseStream(moduleID, methodID uint32, h types.StreamHandler) {
+	rt.streamHandlers[fullID(moduleID, methodID)] = h
+}
+
+// HasModule reports whether any method of the module is registered by Use or UseStream.
+func (rt *Runtime) HasModule(moduleID uint32) bool {
+	for id := range rt.handlers {
+		if uint32(id>>32) == moduleID {
+			return true
+		}
+	}
+	for id := range rt.streamHandlers {
+		if uint32(id>>32) == moduleID {
+			return true
+		}
+	}
+	return false
+}
+
 func (rt *Runtime) Start() error {
 	err := rt.guest.Start()
 	if err != nil {
@@ -49,7 +98,15 @@ func (rt *Runtime) Start() error {
 	rt.wg.Add(1)
 	go func() {
 		defer rt.wg.Done()
-		err := rt.serverWorker()
+		// The guest can no longer receive method calls once the stream is closed.
+		defer rt.closeBuiltins()
+
+		var err error
+		if rt.multiplexed {
+			err = rt.serveMux(rt.guest.Stream())
+		} else {
+			err = newConn(rt, rt.guest.Stream()).serve()
+		}
 		if err != nil {
 			// TODO: stop guest
 			rt.logger.Error("worker error", slog.String("error", err.Error()))
@@ -61,6 +118,7 @@ func (rt *Runtime) Start() error {
 func (rt *Runtime) Wait() error {
 	// TODO: any way to terminate instead of waiting?
 	err := rt.guest.Wait()
+	rt.closeBuiltins()
 	if err != nil {
 		return err
 	}
@@ -68,115 +126,198 @@ func (rt *Runtime) Wait() error {
 	return nil
 }
 
-func (rt *Runtime) serverWorker() error {
-	var err error
-	stream := rt.guest.Stream()
-	for {
-		rlenBuf := make([]byte, message.LengthSize)
-		_, err = io.ReadFull(stream, rlenBuf)
-		if err != nil {
-			return err
-		}
-		length, err := message.DecodeLength(rlenBuf)
-		if err != nil {
-			return err
-		}
+// Kill terminates the guest without waiting for it to exit. It fails if the guest cannot be killed.
+func (rt *Runtime) Kill() error {
+	g, ok := rt.guest.(interface{ Kill() })
+	if !ok {
+		return errors.New("runtime: the guest cannot be killed")
+	}
+	g.Kill()
+	return nil
+}
 
-		req := make([]byte, length)
-		_, err = io.ReadFull(stream, req)
-		if err != nil {
-			return err
-		}
-		dec := message.NewDecoder(req)
+// ExitStatus returns how the guest exited, if the guest reports it. It returns nil until Wait returns.
+func (rt *Runtime) ExitStatus() *ExitStatus {
+	g, ok := rt.guest.(interface{ ExitStatus() *ExitStatus })
+	if !ok {
+		return nil
+	}
+	return g.ExitStatus()
+}
 
-		resp := rt.dispatchRequest(dec)
-		if !resp.IsOk {
-			rt.logger.Error("method error", slog.String("error", resp.Err.Error()))
-		}
-		enc := message.NewEncoder()
-		err = resp.MarshalELRPC(enc)
-		if err != nil {
-			return err
-		}
-		respBody := enc.Buffer()
+func (rt *Runtime) closeBuiltins() {
+	rt.exporter.Close()
+	rt.streaming.Close()
+}
 
-		wlenBuf, err := message.AppendLength(nil, len(respBody))
-		if err != nil {
-			return err
-		}
-		_, err = stream.Write(wlenBuf)
-		if err != nil {
-			return err
-		}
+func (rt *Runtime) serveMux(stream Stream) error {
+	sess := mux.Server(stream, rt.muxConfig)
+	var wg sync.WaitGroup
+	defer func() {
+		_ = sess.Close()
+		wg.Wait()
+	}()
 
-		_, err = stream.Write(respBody)
+	for {
+		ch, err := sess.Accept()
 		if err != nil {
 			return err
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer ch.Close()
+			err := newConn(rt, ch).serve()
+			if err != nil && !errors.Is(err, io.EOF) {
+				rt.logger.Error("channel error",
+					slog.Uint64("channel_id", uint64(ch.ID())), slog.String("error", err.Error()))
+			}
+		}()
 	}
 }
 
 func (rt *Runtime) dispatchRequest(dec *message.Decoder) *message.Result[message.Message, *message.Error] {
 	type Resp = message.Result[message.Message, *message.Error]
+	ctx := context.Background()
+	logger := rt.logger
+	fail := func(err *message.Error) *Resp {
+		logger.Error("method error", slog.String("error", err.Error()))
+		return &Resp{IsOk: false, Err: err}
+	}
+
+	md, err := decodeMetadata(dec)
+	if err != nil {
+		return fail(&message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeInvalidRequest,
+			Message:  "failed to decode metadata",
+		})
+	}
+	if md != nil {
+		ctx = builtin.ContextWithMetadata(ctx, md)
+		logger = logger.With(metadataAttrs(md)...)
+	}
+
 	modID, err := dec.DecodeUint32()
 	if err != nil {
-		return &Resp{
-			IsOk: false,
-			Err: &message.Error{
-				ModuleID: builtin.ModuleID,
-				Code:     builtin.CodeInvalidRequest,
-				Message:  "failed to decode module ID",
-			},
-		}
+		return fail(&message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeInvalidRequest,
+			Message:  "failed to decode module ID",
+		})
 	}
 	methodID, err := dec.DecodeUint32()
 	if err != nil {
-		return &Resp{
-			IsOk: false,
-			Err: &message.Error{
+		return fail(&message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeInvalidRequest,
+			Message:  "failed to decode method ID",
+		})
+	}
+	logger = logger.With(slog.Uint64("module_id", uint64(modID)), slog.Uint64("method_id", uint64(methodID)))
+
+	if md != nil {
+		if deadline, ok := md.DeadlineTime(); ok && !time.Now().Before(deadline) {
+			return fail(&message.Error{
 				ModuleID: builtin.ModuleID,
-				Code:     builtin.CodeInvalidRequest,
-				Message:  "failed to decode method ID",
-			},
+				Code:     builtin.CodeDeadlineExceeded,
+				Message:  "deadline exceeded before the request is handled",
+			})
 		}
 	}
+
 	fullMethodID := fullID(modID, methodID)
+	if streamHandler, ok := rt.streamHandlers[fullMethodID]; ok {
+		streamID, err := rt.streaming.Start(ctx, streamHandler, dec)
+		if err != nil {
+			return fail(builtinimpl.ToELRPCError(err))
+		}
+		return &Resp{IsOk: true, Ok: &message.Uint64{Value: streamID}}
+	}
 	handler, ok := rt.handlers[fullMethodID]
 	if !ok {
-		return &Resp{
-			IsOk: false,
-			Err: &message.Error{
-				ModuleID: builtin.ModuleID,
-				Code:     builtin.CodeUnimplemented,
-				Message:  fmt.Sprintf("method %X in module %X is not implemented", modID, methodID),
-			},
+		return fail(&message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeUnimplemented,
+			Message:  fmt.Sprintf("method %X in module %X is not implemented", methodID, modID),
+		})
+	}
+
+	var resp message.Message
+	if ctxHandler, ok := handler.(types.ContextHostHandler); ok {
+		if md != nil {
+			if deadline, ok := md.DeadlineTime(); ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, deadline)
+				defer cancel()
+			}
 		}
+		resp, err = ctxHandler.HandleRequestContext(ctx, dec)
+	} else {
+		resp, err = handler.HandleRequest(dec)
 	}
-	resp, err := handler.HandleRequest(dec)
 	if err != nil {
-		var elrpcErr *message.Error
-		if errors.As(err, &elrpcErr) {
-			return &Resp{IsOk: false, Err: elrpcErr}
-		}
-		return &Resp{
-			IsOk: false,
-			Err: &message.Error{
-				ModuleID: builtin.ModuleID,
-				Code:     builtin.CodeInternal,
-				Message:  err.Error(),
-			},
-		}
+		return fail(builtinimpl.ToELRPCError(err))
 	}
 	return &Resp{IsOk: true, Ok: resp}
 }
 
+// decodeMetadata decodes the optional metadata section of a request. It returns nil if the request has no metadata.
+func decodeMetadata(dec *message.Decoder) (*builtin.Metadata, error) {
+	tag, err := dec.PeekTag()
+	if err != nil || tag != message.TagAny {
+		return nil, nil
+	}
+	raw, err := dec.DecodeAny()
+	if err != nil {
+		return nil, err
+	}
+	md := &builtin.Metadata{}
+	err = md.UnmarshalELRPC(message.NewDecoder(raw.Raw))
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func metadataAttrs(md *builtin.Metadata) []any {
+	var attrs []any
+	if len(md.TraceID) > 0 {
+		attrs = append(attrs, slog.String("trace_id", hex.EncodeToString(md.TraceID)))
+	}
+	if len(md.SpanID) > 0 {
+		attrs = append(attrs, slog.String("span_id", hex.EncodeToString(md.SpanID)))
+	}
+	if len(md.Tags) > 0 {
+		tagAttrs := make([]any, 0, len(md.Tags))
+		for _, t := range md.Tags {
+			tagAttrs = append(tagAttrs, slog.String(t.Key, t.Value))
+		}
+		attrs = append(attrs, slog.Group("tags", tagAttrs...))
+	}
+	return attrs
+}
+
 func (rt *Runtime) Call(moduleID, methodID uint32, args *message.Any) (*message.Any, error) {
-	ch := rt.exporter.CallAsync(&builtin.MethodCall{
+	return rt.CallContext(context.Background(), moduleID, methodID, args)
+}
+
+// CallContext calls the guest's method and waits for its result.
+// If ctx is done before the guest sends the result, the call is canceled and ctx.Err() is returned.
+func (rt *Runtime) CallContext(ctx context.Context, moduleID, methodID uint32, args *message.Any) (*message.Any, error) {
+	call := &builtin.MethodCall{
 		ModuleID: moduleID,
 		MethodID: methodID,
 		Args:     args,
-	})
-	r := <-ch
+	}
+	ch := rt.exporter.CallAsync(call)
+	var r builtinimpl.CallResult
+	select {
+	case r = <-ch:
+	case <-ctx.Done():
+		rt.exporter.Cancel(call.CallID)
+		return nil, ctx.Err()
+	}
 	if !r.RetVal.IsOk {
 		return nil, r.RetVal.Err
 	}
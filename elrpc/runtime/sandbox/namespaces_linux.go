@@ -0,0 +1,348 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const namespaceFlags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+
+// Device files that are bind-mounted into the guest's /dev.
+var devices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+const hostname = "elsi"
+
+func (sb *Sandbox) setupNamespaces(cmd *exec.Cmd, ns *Namespaces, s *spec) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= namespaceFlags
+	// The guest keeps the host's user and group IDs, so that it can access the mounted files as the host does.
+	uid, gid := os.Getuid(), os.Getgid()
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+
+	// The working directory is set up by the trampoline, because it may not exist in the host.
+	cmd.Dir = ""
+
+	root, err := os.MkdirTemp("", "elsi-root-")
+	if err != nil {
+		return err
+	}
+	sb.tmpRoot = root
+	s.Root = root
+	// Checked here as well as in the trampoline, so that a bad config fails before the guest starts.
+	for i := range ns.Mounts {
+		_, err := mountTarget(root, &ns.Mounts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountTarget returns the path under root on which m is mounted. It fails if the path is not under root.
+func mountTarget(root string, m *Mount) (string, error) {
+	target := m.Target
+	if target == "" {
+		target = m.Source
+	}
+	path := filepath.Join(root, target)
+	if path != root && !strings.HasPrefix(path, root+"/") {
+		return "", fmt.Errorf("mount target %s is outside the root", target)
+	}
+	return path, nil
+}
+
+// setupRoot builds the guest's root filesystem and makes it the root. It runs in the trampoline.
+func setupRoot(s *spec) error {
+	ns := s.Config.Namespaces
+	// Nothing below should propagate to the host.
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	// The root is always a tmpfs, so that the mount points below are never created in the host's directories.
+	root := s.Root
+	err = syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("failed to mount root: %w", err)
+	}
+	fs := &rootfs{root: root}
+	if ns.Root != "" {
+		err = fs.bindEntries(ns.Root, !ns.RootWritable)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range ns.Mounts {
+		m := &ns.Mounts[i]
+		target, err := mountTarget(root, m)
+		if err != nil {
+			return err
+		}
+		err = fs.bindMount(m.Source, target, !m.Writable)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mountProc(root)
+	if err != nil {
+		return err
+	}
+	err = fs.mountDev()
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Sethostname([]byte(hostname))
+	if err != nil {
+		return fmt.Errorf("failed to set hostname: %w", err)
+	}
+	return pivotRoot(root)
+}
+
+// rootfs is the guest's root filesystem under construction.
+type rootfs struct {
+	// The tmpfs on which the root filesystem is built.
+	root string
+	// The targets of bind mounts, under which nothing must be created because they are the host's directories.
+	binds []string
+}
+
+// bindEntries bind-mounts the entries of dir into the root, except /proc and /dev, which are replaced with fresh ones.
+func (fs *rootfs) bindEntries(dir string, readOnly bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == "proc" || e.Name() == "dev" {
+			continue
+		}
+		source := filepath.Join(dir, e.Name())
+		target := filepath.Join(fs.root, e.Name())
+		if e.Type()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(source)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(link, target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = fs.bindMount(source, target, readOnly)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *rootfs) bindMount(source, target string, readOnly bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	err = fs.createMountPoint(target, info.IsDir())
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("failed to bind-mount %s: %w", source, err)
+	}
+	fs.binds = append(fs.binds, target)
+	if !readOnly {
+		return nil
+	}
+	// MS_REMOUNT applies only to a single mount, so the ones under target, which MS_REC has bound, are remounted one by one.
+	mounts, err := mountsUnder(target)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		err := remountReadOnly(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remountReadOnly(target string) error {
+	// The flags locked by the host's mount must be kept; otherwise remounting fails in a user namespace.
+	var st syscall.Statfs_t
+	err := syscall.Statfs(target, &st)
+	if err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | lockedFlags(int64(st.Flags))
+	err = syscall.Mount("", target, "", flags, "")
+	if err != nil {
+		return fmt.Errorf("failed to make %s read-only: %w", target, err)
+	}
+	return nil
+}
+
+// mountsUnder returns the mount points at or under dir, parents first, as listed in /proc/self/mountinfo.
+func mountsUnder(dir string) ([]string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		// Spaces and the like in the path are escaped in octal.
+		path := unescapeOctal(fields[4])
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			mounts = append(mounts, path)
+		}
+	}
+	return mounts, nil
+}
+
+func unescapeOctal(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			n, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			if err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// The flags reported by statfs(2), which are not defined in syscall.
+const (
+	stNosuid     = 0x2
+	stNodev      = 0x4
+	stNoexec     = 0x8
+	stNoatime    = 0x400
+	stNodiratime = 0x800
+	stRelatime   = 0x1000
+)
+
+func lockedFlags(statfsFlags int64) uintptr {
+	var flags uintptr
+	pairs := []struct {
+		st    int64
+		mount uintptr
+	}{
+		{stNosuid, syscall.MS_NOSUID},
+		{stNodev, syscall.MS_NODEV},
+		{stNoexec, syscall.MS_NOEXEC},
+		{stNoatime, syscall.MS_NOATIME},
+		{stNodiratime, syscall.MS_NODIRATIME},
+		{stRelatime, syscall.MS_RELATIME},
+	}
+	for _, p := range pairs {
+		if statfsFlags&p.st != 0 {
+			flags |= p.mount
+		}
+	}
+	return flags
+}
+
+// createMountPoint creates the file or the directory at path and its parents if they do not exist.
+// It fails rather than creating them in the host's directories that are bind-mounted.
+func (fs *rootfs) createMountPoint(path string, isDir bool) error {
+	_, err := os.Lstat(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, b := range fs.binds {
+		if strings.HasPrefix(path, b+"/") {
+			return fmt.Errorf("mount point %s does not exist in %s", path, b)
+		}
+	}
+	err = fs.createMountPoint(filepath.Dir(path), true)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return os.Mkdir(path, 0o755)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func mountProc(root string) error {
+	target := filepath.Join(root, "proc")
+	err := os.MkdirAll(target, 0o555)
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount("proc", target, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	return nil
+}
+
+func (fs *rootfs) mountDev() error {
+	target := filepath.Join(fs.root, "dev")
+	err := os.MkdirAll(target, 0o755)
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("failed to mount /dev: %w", err)
+	}
+	for _, dev := range devices {
+		// Devices can't be created in a user namespace, but the host's ones can be bind-mounted.
+		err = fs.bindMount(dev, filepath.Join(fs.root, dev), false)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func pivotRoot(root string) error {
+	oldRoot := filepath.Join(root, ".oldroot")
+	err := os.MkdirAll(oldRoot, 0o700)
+	if err != nil {
+		return err
+	}
+	err = syscall.PivotRoot(root, oldRoot)
+	if err != nil {
+		return fmt.Errorf("failed to pivot root: %w", err)
+	}
+	err = os.Chdir("/")
+	if err != nil {
+		return err
+	}
+	err = syscall.Unmount("/.oldroot", syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("failed to unmount the old root: %w", err)
+	}
+	return os.Remove("/.oldroot")
+}
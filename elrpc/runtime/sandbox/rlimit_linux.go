@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package sandbox
+
+// syscall does not define RLIMIT_NPROC.
+const rlimitNproc = 0x6
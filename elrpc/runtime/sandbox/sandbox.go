@@ -0,0 +1,124 @@
+// Package sandbox restricts what guest processes can do.
+//
+// A sandboxed guest is not started directly. Instead, the host program re-executes itself as a trampoline,
+// which applies the restrictions to itself and then executes the guest. Therefore the host program
+// must call Init at the beginning of its main function (or TestMain in tests).
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// envSpec is the environment variable that passes the spec to the trampoline.
+const envSpec = "ELSI_SANDBOX_SPEC"
+
+// ExitCodeTrampolineFailure is the exit code of the trampoline when it fails to start the guest.
+const ExitCodeTrampolineFailure = 127
+
+type Config struct {
+	Limits Limits
+	// If non-nil, the guest runs in new user, mount, PID, network, IPC and UTS namespaces.
+	Namespaces *Namespaces
+	// If non-nil, the guest can only make the system calls allowed by the seccomp profile.
+	Seccomp *Seccomp
+}
+
+// Seccomp is a seccomp-BPF profile. By default, it allows only the system calls that a program needs
+// to compute and to talk over the file descriptors it already has: reading and writing, memory management,
+// threads, signals, clocks and exiting. Opening files and creating sockets are not allowed.
+// Executing other programs is only made difficult, not prevented; use Namespaces to hide them from the guest.
+// Seccomp is supported only on linux/amd64 and linux/arm64.
+type Seccomp struct {
+	// The names of system calls allowed in addition to the default ones, such as "openat".
+	// Note that dynamically linked guests need "openat" and a few others to load shared libraries.
+	Allow []string
+	// If true, the guest is killed when it makes a disallowed system call. Otherwise the call fails with EPERM.
+	Kill bool
+}
+
+// Limits restricts the resources that a guest can use. Zero values mean no limit.
+type Limits struct {
+	// The CPU time that the guest can use (RLIMIT_CPU). The guest receives SIGXCPU once it exceeds the limit.
+	CPUTime time.Duration
+	// The maximum size of the guest's virtual memory in bytes (RLIMIT_AS).
+	AddressSpace uint64
+	// The maximum number of file descriptors that the guest can open (RLIMIT_NOFILE).
+	OpenFiles uint64
+	// The maximum number of processes (RLIMIT_NPROC). Note that it counts all processes of the same user, not only the guest's.
+	Processes uint64
+
+	// A cgroup v2 directory that the host can write to, such as /sys/fs/cgroup/elsi.
+	// The guest is put into a new child cgroup of it. Required only if Memory or CPUMax is set.
+	CgroupParent string
+	// The maximum amount of memory in bytes (memory.max).
+	Memory uint64
+	// The CPU bandwidth limit (cpu.max).
+	CPUMax *CPUMax
+}
+
+// CPUMax allows the guest to use Quota of CPU time in each Period.
+type CPUMax struct {
+	Quota  time.Duration
+	Period time.Duration
+}
+
+// Namespaces configures the filesystem that a guest sees in its mount namespace.
+// The guest's executable and everything it needs must be visible there, for example via Mounts.
+type Namespaces struct {
+	// The directory whose entries are bind-mounted into the guest's root filesystem, which is a tmpfs.
+	// If empty, the root is an empty tmpfs. The guest's /proc and /dev are always replaced with fresh ones.
+	// Nothing is created in the directory; the targets of Mounts under its entries must exist in it.
+	// The entries are read-only unless RootWritable is true.
+	Root         string
+	RootWritable bool
+	// Files or directories of the host that are bind-mounted into the guest's root filesystem.
+	Mounts []Mount
+}
+
+type Mount struct {
+	// The path in the host.
+	Source string
+	// The path in the guest. If empty, it is the same as Source.
+	Target string
+	// If false, the mount is read-only.
+	Writable bool
+}
+
+func (l *Limits) usesCgroup() bool {
+	return l.Memory > 0 || l.CPUMax != nil
+}
+
+// spec is what the host tells the trampoline.
+type spec struct {
+	Path   string
+	Args   []string
+	Dir    string
+	Config *Config
+	// The cgroup directory that the guest joins, if any.
+	Cgroup string
+	// The empty directory created by the host, on which the guest's root filesystem is built, if the guest runs in namespaces.
+	Root string
+}
+
+// Init runs the trampoline if the current process is started as a sandboxed guest. It never returns in that case.
+// Otherwise it does nothing.
+func Init() {
+	specJSON, ok := os.LookupEnv(envSpec)
+	if !ok {
+		return
+	}
+	err := os.Unsetenv(envSpec)
+	if err == nil {
+		s := &spec{}
+		err = json.Unmarshal([]byte(specJSON), s)
+		if err == nil {
+			err = trampoline(s)
+		}
+	}
+	// trampoline returns only if it fails.
+	fmt.Fprintf(os.Stderr, "elsi sandbox: %s\n", err.Error())
+	os.Exit(ExitCodeTrampolineFailure)
+}
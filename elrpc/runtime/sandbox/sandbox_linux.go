@@ -0,0 +1,224 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Sandbox holds the host-side resources of a sandboxed guest.
+type Sandbox struct {
+	limits  Limits
+	cgroup  string
+	tmpRoot string
+}
+
+var cgroupSeq atomic.Uint64
+
+// Wrap rewrites cmd so that it runs in a sandbox configured by conf.
+// It must be called after cmd.Env is set, and cmd must not be started yet.
+// The caller must call Release after the guest exits.
+func Wrap(cmd *exec.Cmd, conf *Config) (*Sandbox, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	if conf.Seccomp != nil {
+		err := validateSeccomp(conf.Seccomp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sb := &Sandbox{limits: conf.Limits}
+	if conf.Limits.usesCgroup() {
+		cgroup, err := createCgroup(&conf.Limits)
+		if err != nil {
+			return nil, err
+		}
+		sb.cgroup = cgroup
+	}
+
+	s := &spec{
+		Path:   cmd.Path,
+		Args:   cmd.Args,
+		Dir:    cmd.Dir,
+		Config: conf,
+		Cgroup: sb.cgroup,
+	}
+	if conf.Namespaces != nil {
+		err := sb.setupNamespaces(cmd, conf.Namespaces, s)
+		if err != nil {
+			_ = sb.Release()
+			return nil, err
+		}
+	}
+
+	specJSON, err := json.Marshal(s)
+	if err != nil {
+		_ = sb.Release()
+		return nil, err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{"elsi-sandbox"}
+	cmd.Env = append(env, envSpec+"="+string(specJSON))
+	return sb, nil
+}
+
+// Release removes the cgroup and the temporary root directory of the guest, if any.
+func (sb *Sandbox) Release() error {
+	for _, dir := range []string{sb.cgroup, sb.tmpRoot} {
+		if dir == "" {
+			continue
+		}
+		err := os.Remove(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// CPULimitExceeded reports whether the guest was killed because it exceeded Limits.CPUTime.
+func (sb *Sandbox) CPULimitExceeded(ps *os.ProcessState) bool {
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() || sb.limits.CPUTime == 0 {
+		return false
+	}
+	switch ws.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		// Sent at the hard limit if the guest ignores SIGXCPU, as Go programs do.
+		return ps.UserTime()+ps.SystemTime() >= sb.limits.CPUTime
+	default:
+		return false
+	}
+}
+
+// MemoryLimitExceeded reports whether the guest was killed because it exceeded Limits.Memory.
+// It must be called before Release.
+func (sb *Sandbox) MemoryLimitExceeded() bool {
+	if sb.cgroup == "" {
+		return false
+	}
+	events, err := os.ReadFile(filepath.Join(sb.cgroup, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(events), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if ok && key == "oom_kill" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			return err == nil && n > 0
+		}
+	}
+	return false
+}
+
+func createCgroup(l *Limits) (string, error) {
+	if l.CgroupParent == "" {
+		return "", errors.New("sandbox: Memory and CPUMax require CgroupParent")
+	}
+	var controllers []string
+	if l.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if l.CPUMax != nil {
+		controllers = append(controllers, "+cpu")
+	}
+	err := os.WriteFile(filepath.Join(l.CgroupParent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0)
+	if err != nil {
+		return "", fmt.Errorf("sandbox: failed to enable cgroup controllers: %w", err)
+	}
+
+	cgroup := filepath.Join(l.CgroupParent, fmt.Sprintf("elsi-%d-%d", os.Getpid(), cgroupSeq.Add(1)))
+	err = os.Mkdir(cgroup, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("sandbox: failed to create cgroup: %w", err)
+	}
+	if l.Memory > 0 {
+		err = os.WriteFile(filepath.Join(cgroup, "memory.max"), []byte(strconv.FormatUint(l.Memory, 10)), 0)
+		if err != nil {
+			_ = os.Remove(cgroup)
+			return "", fmt.Errorf("sandbox: failed to set memory.max: %w", err)
+		}
+	}
+	if l.CPUMax != nil {
+		cpuMax := fmt.Sprintf("%d %d", l.CPUMax.Quota.Microseconds(), l.CPUMax.Period.Microseconds())
+		err = os.WriteFile(filepath.Join(cgroup, "cpu.max"), []byte(cpuMax), 0)
+		if err != nil {
+			_ = os.Remove(cgroup)
+			return "", fmt.Errorf("sandbox: failed to set cpu.max: %w", err)
+		}
+	}
+	return cgroup, nil
+}
+
+func trampoline(s *spec) error {
+	if s.Cgroup != "" {
+		err := os.WriteFile(filepath.Join(s.Cgroup, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0)
+		if err != nil {
+			return fmt.Errorf("failed to join cgroup: %w", err)
+		}
+	}
+	if s.Config.Namespaces != nil {
+		err := setupRoot(s)
+		if err != nil {
+			return err
+		}
+	}
+	err := setRlimits(&s.Config.Limits)
+	if err != nil {
+		return err
+	}
+	if s.Dir != "" {
+		err = os.Chdir(s.Dir)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Config.Seccomp != nil {
+		return execWithSeccomp(s)
+	}
+	return syscall.Exec(s.Path, s.Args, os.Environ())
+}
+
+func setRlimits(l *Limits) error {
+	if l.CPUTime > 0 {
+		secs := uint64((l.CPUTime + 999_999_999) / 1_000_000_000)
+		// The guest receives SIGXCPU at the soft limit, and SIGKILL at the hard limit if it ignores SIGXCPU.
+		err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: secs, Max: secs + 1})
+		if err != nil {
+			return fmt.Errorf("failed to set RLIMIT_CPU: %w", err)
+		}
+	}
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"RLIMIT_AS", syscall.RLIMIT_AS, l.AddressSpace},
+		{"RLIMIT_NOFILE", syscall.RLIMIT_NOFILE, l.OpenFiles},
+		{"RLIMIT_NPROC", rlimitNproc, l.Processes},
+	}
+	for _, lim := range limits {
+		if lim.value == 0 {
+			continue
+		}
+		err := syscall.Setrlimit(lim.resource, &syscall.Rlimit{Cur: lim.value, Max: lim.value})
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %w", lim.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("sandbox: not supported on this platform")
+
+type Sandbox struct{}
+
+func Wrap(cmd *exec.Cmd, conf *Config) (*Sandbox, error) {
+	return nil, errUnsupported
+}
+
+func (sb *Sandbox) Release() error {
+	return nil
+}
+
+func (sb *Sandbox) CPULimitExceeded(ps *os.ProcessState) bool {
+	return false
+}
+
+func (sb *Sandbox) MemoryLimitExceeded() bool {
+	return false
+}
+
+func trampoline(s *spec) error {
+	return errUnsupported
+}
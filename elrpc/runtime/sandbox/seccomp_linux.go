@@ -0,0 +1,187 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	seccompRetKillProcess = 0x8000_0000
+	seccompRetErrno       = 0x0005_0000
+	seccompRetAllow       = 0x7fff_0000
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	prSetNoNewPrivs = 38
+
+	cloneThread = 0x0001_0000
+)
+
+// Offsets in struct seccomp_data.
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// The system calls allowed by default. Those that do not exist on the current architecture are ignored.
+// execve, clone, clone3, tgkill and prlimit64 are handled separately in buildFilter.
+var defaultSyscalls = []string{
+	"read", "write", "readv", "writev", "pread64", "pwrite64", "close", "lseek", "fstat", "newfstatat",
+	"fcntl", "dup", "dup3", "pipe2", "eventfd2", "poll", "ppoll", "pselect6",
+	"epoll_create1", "epoll_ctl", "epoll_wait", "epoll_pwait", "epoll_pwait2",
+	"mmap", "munmap", "mprotect", "madvise", "brk", "mremap", "membarrier",
+	"rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "sigaltstack", "restart_syscall",
+	"futex", "sched_yield", "sched_getaffinity", "set_tid_address", "set_robust_list", "rseq", "arch_prctl",
+	"nanosleep", "clock_gettime", "clock_getres", "clock_nanosleep", "gettimeofday",
+	"getpid", "gettid", "getuid", "geteuid", "getgid", "getegid", "getrlimit", "uname", "getrandom",
+	"exit", "exit_group",
+}
+
+func validateSeccomp(conf *Seccomp) error {
+	if auditArch == 0 {
+		return fmt.Errorf("sandbox: seccomp is not supported on %s", goruntime.GOARCH)
+	}
+	for _, name := range conf.Allow {
+		if _, ok := syscallNumbers[name]; !ok {
+			return fmt.Errorf("sandbox: unknown system call: %s", name)
+		}
+	}
+	return nil
+}
+
+// execWithSeccomp installs the filter and executes the guest.
+// execve is allowed only if its path is at the address of the trampoline's path. The filter can't tell that
+// address apart in the guest, which can map memory there and execute another program, so it is not a security boundary.
+func execWithSeccomp(s *spec) error {
+	goruntime.LockOSThread()
+	path, err := syscall.BytePtrFromString(s.Path)
+	if err != nil {
+		return err
+	}
+	argv, err := syscall.SlicePtrFromStrings(s.Args)
+	if err != nil {
+		return err
+	}
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		return err
+	}
+
+	filter := buildFilter(s.Config.Seccomp, uintptr(unsafe.Pointer(path)), os.Getpid())
+	prog := &syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	// TSYNC applies the filter to all threads of the trampoline, not only the current one.
+	_, _, errno = syscall.RawSyscall(syscallNumbers["seccomp"], seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+
+	_, _, errno = syscall.RawSyscall(syscallNumbers["execve"],
+		uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	goruntime.KeepAlive(path)
+	goruntime.KeepAlive(argv)
+	goruntime.KeepAlive(envv)
+	return errno
+}
+
+func buildFilter(conf *Seccomp, execPath uintptr, pid int) []syscall.SockFilter {
+	deny := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	if conf.Kill {
+		deny = seccompRetKillProcess
+	}
+	b := &filterBuilder{}
+
+	b.load(offsetArch)
+	b.add(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 1, 0, auditArch)
+	b.ret(seccompRetKillProcess)
+	b.load(offsetNr)
+	if x32SyscallBit != 0 {
+		b.add(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, 0, 1, x32SyscallBit)
+		b.ret(deny)
+	}
+
+	allowed := make(map[uintptr]bool)
+	for _, names := range [][]string{defaultSyscalls, conf.Allow} {
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok || allowed[nr] {
+				continue
+			}
+			allowed[nr] = true
+			b.jumpIfNotEqual(uint32(nr), 1)
+			b.ret(seccompRetAllow)
+		}
+	}
+
+	// clone is allowed only for creating threads.
+	b.jumpIfNotEqual(uint32(syscallNumbers["clone"]), 4)
+	b.load(offsetArgs)
+	b.add(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, 0, 1, cloneThread)
+	b.ret(seccompRetAllow)
+	b.ret(deny)
+
+	// clone3 passes its flags in memory, which BPF can't inspect. ENOSYS makes libc fall back to clone.
+	b.jumpIfNotEqual(uint32(syscallNumbers["clone3"]), 1)
+	b.ret(seccompRetErrno | uint32(syscall.ENOSYS))
+
+	// Signals can only be sent to the guest's own threads.
+	b.jumpIfNotEqual(uint32(syscallNumbers["tgkill"]), 3)
+	b.loadArg(0)
+	b.jumpIfNotEqual(uint32(pid), 1)
+	b.ret(seccompRetAllow)
+	b.load(offsetNr)
+
+	// prlimit64 is allowed only for the guest itself.
+	b.jumpIfNotEqual(uint32(syscallNumbers["prlimit64"]), 3)
+	b.loadArg(0)
+	b.jumpIfNotEqual(0, 1)
+	b.ret(seccompRetAllow)
+	b.load(offsetNr)
+
+	b.jumpIfNotEqual(uint32(syscallNumbers["execve"]), 5)
+	b.loadArg(0)
+	b.jumpIfNotEqual(uint32(execPath), 3)
+	b.add(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0, 0, offsetArgs+4)
+	b.jumpIfNotEqual(uint32(uint64(execPath)>>32), 1)
+	b.ret(seccompRetAllow)
+
+	b.ret(deny)
+	return b.filter
+}
+
+type filterBuilder struct {
+	filter []syscall.SockFilter
+}
+
+func (b *filterBuilder) add(code uint16, jt, jf uint8, k uint32) {
+	b.filter = append(b.filter, syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k})
+}
+
+func (b *filterBuilder) load(offset uint32) {
+	b.add(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0, 0, offset)
+}
+
+// loadArg loads the lower 32 bits of the i-th argument.
+func (b *filterBuilder) loadArg(i uint32) {
+	b.load(offsetArgs + 8*i)
+}
+
+// jumpIfNotEqual skips the next n instructions if the accumulator is not equal to k.
+func (b *filterBuilder) jumpIfNotEqual(k uint32, n uint8) {
+	b.add(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 0, n, k)
+}
+
+func (b *filterBuilder) ret(k uint32) {
+	b.add(syscall.BPF_RET|syscall.BPF_K, 0, 0, k)
+}
@@ -0,0 +1,105 @@
+package sandbox
+
+// AUDIT_ARCH_X86_64
+const auditArch = 0xc000_003e
+
+// System calls with this bit set are x32 ones, which are denied so that the filter can't be bypassed through them.
+const x32SyscallBit = 0x4000_0000
+
+var syscallNumbers = map[string]uintptr{
+	"read":              0,
+	"write":             1,
+	"open":              2,
+	"close":             3,
+	"stat":              4,
+	"fstat":             5,
+	"lstat":             6,
+	"poll":              7,
+	"lseek":             8,
+	"mmap":              9,
+	"mprotect":          10,
+	"munmap":            11,
+	"brk":               12,
+	"rt_sigaction":      13,
+	"rt_sigprocmask":    14,
+	"rt_sigreturn":      15,
+	"ioctl":             16,
+	"pread64":           17,
+	"pwrite64":          18,
+	"readv":             19,
+	"writev":            20,
+	"access":            21,
+	"pipe":              22,
+	"select":            23,
+	"sched_yield":       24,
+	"mremap":            25,
+	"msync":             26,
+	"mincore":           27,
+	"madvise":           28,
+	"dup":               32,
+	"dup2":              33,
+	"nanosleep":         35,
+	"getpid":            39,
+	"socket":            41,
+	"connect":           42,
+	"accept":            43,
+	"sendto":            44,
+	"recvfrom":          45,
+	"bind":              49,
+	"listen":            50,
+	"clone":             56,
+	"fork":              57,
+	"vfork":             58,
+	"execve":            59,
+	"exit":              60,
+	"wait4":             61,
+	"kill":              62,
+	"uname":             63,
+	"fcntl":             72,
+	"getcwd":            79,
+	"readlink":          89,
+	"gettimeofday":      96,
+	"getrlimit":         97,
+	"getrusage":         98,
+	"getuid":            102,
+	"getgid":            104,
+	"geteuid":           107,
+	"getegid":           108,
+	"getppid":           110,
+	"sigaltstack":       131,
+	"arch_prctl":        158,
+	"gettid":            186,
+	"futex":             202,
+	"sched_getaffinity": 204,
+	"getdents64":        217,
+	"set_tid_address":   218,
+	"restart_syscall":   219,
+	"clock_gettime":     228,
+	"clock_getres":      229,
+	"clock_nanosleep":   230,
+	"exit_group":        231,
+	"epoll_wait":        232,
+	"epoll_ctl":         233,
+	"tgkill":            234,
+	"openat":            257,
+	"newfstatat":        262,
+	"readlinkat":        267,
+	"faccessat":         269,
+	"pselect6":          270,
+	"ppoll":             271,
+	"set_robust_list":   273,
+	"epoll_pwait":       281,
+	"eventfd2":          290,
+	"epoll_create1":     291,
+	"dup3":              292,
+	"pipe2":             293,
+	"prlimit64":         302,
+	"seccomp":           317,
+	"getrandom":         318,
+	"membarrier":        324,
+	"statx":             332,
+	"rseq":              334,
+	"clone3":            435,
+	"faccessat2":        439,
+	"epoll_pwait2":      441,
+}
@@ -0,0 +1,92 @@
+package sandbox
+
+// AUDIT_ARCH_AARCH64
+const auditArch = 0xc000_00b7
+
+// arm64 has no alternative system call ABI.
+const x32SyscallBit = 0
+
+var syscallNumbers = map[string]uintptr{
+	"getcwd":            17,
+	"eventfd2":          19,
+	"epoll_create1":     20,
+	"epoll_ctl":         21,
+	"epoll_pwait":       22,
+	"dup":               23,
+	"dup3":              24,
+	"fcntl":             25,
+	"ioctl":             29,
+	"faccessat":         48,
+	"openat":            56,
+	"close":             57,
+	"pipe2":             59,
+	"getdents64":        61,
+	"lseek":             62,
+	"read":              63,
+	"write":             64,
+	"readv":             65,
+	"writev":            66,
+	"pread64":           67,
+	"pwrite64":          68,
+	"pselect6":          72,
+	"ppoll":             73,
+	"readlinkat":        78,
+	"newfstatat":        79,
+	"fstat":             80,
+	"exit":              93,
+	"exit_group":        94,
+	"set_tid_address":   96,
+	"futex":             98,
+	"set_robust_list":   99,
+	"nanosleep":         101,
+	"clock_gettime":     113,
+	"clock_getres":      114,
+	"clock_nanosleep":   115,
+	"sched_getaffinity": 123,
+	"sched_yield":       124,
+	"restart_syscall":   128,
+	"kill":              129,
+	"tgkill":            131,
+	"sigaltstack":       132,
+	"rt_sigaction":      134,
+	"rt_sigprocmask":    135,
+	"rt_sigreturn":      139,
+	"uname":             160,
+	"getrlimit":         163,
+	"getrusage":         165,
+	"gettimeofday":      169,
+	"getpid":            172,
+	"getppid":           173,
+	"getuid":            174,
+	"geteuid":           175,
+	"getgid":            176,
+	"getegid":           177,
+	"gettid":            178,
+	"socket":            198,
+	"bind":              200,
+	"listen":            201,
+	"accept":            202,
+	"connect":           203,
+	"sendto":            206,
+	"recvfrom":          207,
+	"brk":               214,
+	"munmap":            215,
+	"mremap":            216,
+	"clone":             220,
+	"execve":            221,
+	"mmap":              222,
+	"mprotect":          226,
+	"msync":             227,
+	"mincore":           232,
+	"madvise":           233,
+	"wait4":             260,
+	"prlimit64":         261,
+	"seccomp":           277,
+	"getrandom":         278,
+	"membarrier":        283,
+	"statx":             291,
+	"rseq":              293,
+	"clone3":            435,
+	"faccessat2":        439,
+	"epoll_pwait2":      441,
+}
@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+// seccomp is not supported on this architecture.
+const auditArch = 0
+
+const x32SyscallBit = 0
+
+var syscallNumbers = map[string]uintptr{}
@@ -0,0 +1,86 @@
+package sandbox
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// runFilter evaluates the filter against a system call as the kernel does.
+func runFilter(t *testing.T, filter []syscall.SockFilter, nr uintptr, args ...uint64) uint32 {
+	t.Helper()
+	data := make([]byte, offsetArgs+8*6)
+	binary.LittleEndian.PutUint32(data[offsetNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[offsetArch:], auditArch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			pc += jump(ins, acc == ins.K)
+		case syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			pc += jump(ins, acc >= ins.K)
+		case syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K:
+			pc += jump(ins, acc&ins.K != 0)
+		case syscall.BPF_RET | syscall.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unknown instruction at %d: %#v", pc, ins)
+		}
+	}
+	t.Fatal("the filter does not return")
+	return 0
+}
+
+func jump(ins syscall.SockFilter, cond bool) int {
+	if cond {
+		return int(ins.Jt)
+	}
+	return int(ins.Jf)
+}
+
+func TestBuildFilter(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("seccomp is not supported")
+	}
+	const execPath = 0x0000_7fff_1234_5678
+	const pid = 42
+	deny := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	filter := buildFilter(&Seccomp{}, execPath, pid)
+
+	cases := []struct {
+		name    string
+		syscall string
+		args    []uint64
+		want    uint32
+	}{
+		{"read", "read", nil, seccompRetAllow},
+		{"openat is denied by default", "openat", nil, deny},
+		{"sockets are denied", "socket", nil, deny},
+		{"threads", "clone", []uint64{cloneThread}, seccompRetAllow},
+		{"processes are denied", "clone", []uint64{uint64(syscall.SIGCHLD)}, deny},
+		{"signals to itself", "tgkill", []uint64{pid}, seccompRetAllow},
+		{"signals to others are denied", "tgkill", []uint64{pid + 1}, deny},
+		{"the guest", "execve", []uint64{execPath}, seccompRetAllow},
+		{"other programs are denied", "execve", []uint64{execPath + 1}, deny},
+	}
+	for _, c := range cases {
+		nr, ok := syscallNumbers[c.syscall]
+		if !ok {
+			t.Fatalf("unknown system call: %s", c.syscall)
+		}
+		if got := runFilter(t, filter, nr, c.args...); got != c.want {
+			t.Errorf("%s: want %#x but got %#x", c.name, c.want, got)
+		}
+	}
+
+	filter = buildFilter(&Seccomp{Allow: []string{"openat"}}, execPath, pid)
+	if got := runFilter(t, filter, syscallNumbers["openat"]); got != seccompRetAllow {
+		t.Errorf("want openat to be allowed but got %#x", got)
+	}
+}
@@ -0,0 +1,180 @@
+package runtime_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elrpc/runtime/sandbox"
+)
+
+func runSandboxedHelper(t *testing.T, mode string, conf *sandbox.Config) *runtime.ExitStatus {
+	t.Helper()
+	guest, err := runtime.NewProcessGuestWithOptions(os.Args[0], []string{"-test.run=^TestHelperProcess$"},
+		runtime.WithEnv(envHelperProcess, mode),
+		runtime.WithSandbox(conf),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Start()
+	if err != nil {
+		if conf.Namespaces != nil && (errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL)) {
+			t.Skipf("user namespaces are not available: %s", err.Error())
+		}
+		t.Fatal(err)
+	}
+	_ = guest.Wait()
+	return guest.ExitStatus()
+}
+
+// helperMounts makes the test binary visible in the sandbox.
+func helperMounts() []sandbox.Mount {
+	mounts := []sandbox.Mount{{Source: filepath.Dir(os.Args[0])}}
+	// The test binary may be linked dynamically.
+	for _, dir := range []string{"/lib", "/lib64", "/usr"} {
+		if _, err := os.Stat(dir); err == nil {
+			mounts = append(mounts, sandbox.Mount{Source: dir})
+		}
+	}
+	return mounts
+}
+
+func TestSandbox_namespaces(t *testing.T) {
+	st := runSandboxedHelper(t, "ns", &sandbox.Config{
+		Namespaces: &sandbox.Namespaces{Mounts: helperMounts()},
+	})
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 0 {
+		t.Errorf("want exit code 0 but got %s (%d, %v)", st.Reason, st.ExitCode, st.Signal)
+	}
+}
+
+func TestSandbox_namespacesRoot(t *testing.T) {
+	root := t.TempDir()
+	err := os.Mkdir(filepath.Join(root, "data"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := runSandboxedHelper(t, "ns", &sandbox.Config{
+		Namespaces: &sandbox.Namespaces{Root: root, Mounts: helperMounts()},
+	})
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 0 {
+		t.Errorf("want exit code 0 but got %s (%d, %v)", st.Reason, st.ExitCode, st.Signal)
+	}
+	// The mount points are not left in the root.
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "data" {
+		t.Errorf("want only data in the root but got %v", entries)
+	}
+}
+
+func TestSandbox_namespacesRootReadOnly(t *testing.T) {
+	for _, writable := range []bool{false, true} {
+		root := t.TempDir()
+		err := os.Mkdir(filepath.Join(root, "data"), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := runSandboxedHelper(t, "write", &sandbox.Config{
+			Namespaces: &sandbox.Namespaces{Root: root, RootWritable: writable, Mounts: helperMounts()},
+		})
+		want := 1
+		if writable {
+			want = 0
+		}
+		if st.Reason != runtime.ExitReasonExited || st.ExitCode != want {
+			t.Errorf("RootWritable=%t: want exit code %d but got %s (%d, %v)", writable, want, st.Reason, st.ExitCode, st.Signal)
+		}
+		_, err = os.Stat(filepath.Join(root, "data", "elsi-test"))
+		if (err == nil) != writable {
+			t.Errorf("RootWritable=%t: unexpected result of writing to the root: %v", writable, err)
+		}
+	}
+}
+
+func TestSandbox_mountOutsideRoot(t *testing.T) {
+	_, err := runtime.NewProcessGuestWithOptions(os.Args[0], nil, runtime.WithSandbox(&sandbox.Config{
+		Namespaces: &sandbox.Namespaces{Mounts: []sandbox.Mount{{Source: os.TempDir(), Target: "../../tmp/x"}}},
+	}))
+	if err == nil {
+		t.Fatal("want error but got nil")
+	}
+}
+
+func TestSandbox_cpuTime(t *testing.T) {
+	st := runSandboxedHelper(t, "spin", &sandbox.Config{
+		Limits: sandbox.Limits{CPUTime: 1 * time.Second},
+	})
+	if st.Reason != runtime.ExitReasonCPULimit {
+		t.Errorf("want %s but got %s", runtime.ExitReasonCPULimit, st.Reason)
+	}
+}
+
+func TestSandbox_openFiles(t *testing.T) {
+	st := runSandboxedHelper(t, "nofile", &sandbox.Config{
+		Limits: sandbox.Limits{OpenFiles: 32},
+	})
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 4 {
+		t.Errorf("want exit code 4 but got %s (%d)", st.Reason, st.ExitCode)
+	}
+}
+
+func TestSandbox_memory(t *testing.T) {
+	// Requires a writable cgroup v2 directory with the memory controller available.
+	parent := os.Getenv("ELSI_TEST_CGROUP_PARENT")
+	if parent == "" {
+		t.Skip("ELSI_TEST_CGROUP_PARENT is not set")
+	}
+	st := runSandboxedHelper(t, "alloc", &sandbox.Config{
+		Limits: sandbox.Limits{CgroupParent: parent, Memory: 64 << 20},
+	})
+	if st.Reason != runtime.ExitReasonMemoryLimit {
+		t.Errorf("want %s but got %s", runtime.ExitReasonMemoryLimit, st.Reason)
+	}
+}
+
+func TestSandbox_cgroupWithoutParent(t *testing.T) {
+	_, err := runtime.NewProcessGuestWithOptions(os.Args[0], nil, runtime.WithSandbox(&sandbox.Config{
+		Limits: sandbox.Limits{Memory: 64 << 20},
+	}))
+	if err == nil {
+		t.Fatal("want error but got nil")
+	}
+}
+
+// Dynamically linked guests need these to load shared libraries.
+var dynamicLinkingSyscalls = []string{"openat", "access", "faccessat"}
+
+func TestSandbox_seccomp(t *testing.T) {
+	st := runSandboxedHelper(t, "seccomp", &sandbox.Config{
+		Seccomp: &sandbox.Seccomp{Allow: dynamicLinkingSyscalls},
+	})
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 0 {
+		t.Errorf("want exit code 0 but got %s (%d, %v)", st.Reason, st.ExitCode, st.Signal)
+	}
+}
+
+func TestSandbox_seccompKill(t *testing.T) {
+	st := runSandboxedHelper(t, "seccomp", &sandbox.Config{
+		Seccomp: &sandbox.Seccomp{Allow: dynamicLinkingSyscalls, Kill: true},
+	})
+	if st.Reason != runtime.ExitReasonSignaled || st.Signal != syscall.SIGSYS {
+		t.Errorf("want to be killed by SIGSYS but got %s (%v)", st.Reason, st.Signal)
+	}
+}
+
+func TestSandbox_seccompUnknownSyscall(t *testing.T) {
+	_, err := runtime.NewProcessGuestWithOptions(os.Args[0], nil, runtime.WithSandbox(&sandbox.Config{
+		Seccomp: &sandbox.Seccomp{Allow: []string{"no_such_syscall"}},
+	}))
+	if err == nil {
+		t.Fatal("want error but got nil")
+	}
+}
@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// SocketGuest is a guest that connects to the host over a Unix domain socket or a loopback TCP connection.
+// The host listens on the address, and Start waits for the guest to connect.
+// Only one guest can attach to a SocketGuest; the listener is closed once the guest is accepted.
+type SocketGuest struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream *socketStream
+}
+
+var _ Guest = (*SocketGuest)(nil)
+
+// ListenUnix creates a SocketGuest that listens on the Unix domain socket at path.
+func ListenUnix(path string) (*SocketGuest, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocketGuest(ln), nil
+}
+
+// ListenTCP creates a SocketGuest that listens on addr, which must be a loopback address.
+func ListenTCP(addr string) (*SocketGuest, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("not a loopback address: %s", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocketGuest(ln), nil
+}
+
+// NewSocketGuest creates a SocketGuest that accepts a guest from ln.
+func NewSocketGuest(ln net.Listener) *SocketGuest {
+	return &SocketGuest{ln: ln}
+}
+
+// Addr returns the address that the guest should connect to.
+func (g *SocketGuest) Addr() net.Addr {
+	return g.ln.Addr()
+}
+
+// Start waits for the guest to connect.
+func (g *SocketGuest) Start() error {
+	conn, err := g.ln.Accept()
+	closeErr := g.ln.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		conn.Close()
+		return closeErr
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.conn = conn
+	g.stream = &socketStream{conn: conn, done: make(chan struct{})}
+	return nil
+}
+
+func (g *SocketGuest) Stream() Stream {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stream
+}
+
+// Wait waits for the guest to disconnect.
+func (g *SocketGuest) Wait() error {
+	g.mu.Lock()
+	stream := g.stream
+	g.mu.Unlock()
+	if stream == nil {
+		return errors.New("guest is not started")
+	}
+	<-stream.done
+	err := stream.conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops listening and disconnects the guest, if any.
+func (g *SocketGuest) Close() error {
+	err := g.ln.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	g.mu.Lock()
+	conn := g.conn
+	g.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	err = conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Kill disconnects the guest, as Close does, so that Wait returns.
+func (g *SocketGuest) Kill() {
+	_ = g.Close()
+}
+
+// socketStream notifies that the guest has disconnected once reading from conn fails.
+type socketStream struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *socketStream) Read(p []byte) (int, error) {
+	n, err := s.conn.Read(p)
+	if err != nil {
+		s.once.Do(func() { close(s.done) })
+		if errors.Is(err, net.ErrClosed) {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+func (s *socketStream) Write(p []byte) (int, error) {
+	return s.conn.Write(p)
+}
@@ -0,0 +1,119 @@
+package runtime_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/slog"
+)
+
+func TestSocketGuest(t *testing.T) {
+	cases := []struct {
+		name   string
+		listen func(t *testing.T) (*runtime.SocketGuest, error)
+	}{
+		{
+			name: "unix",
+			listen: func(t *testing.T) (*runtime.SocketGuest, error) {
+				return runtime.ListenUnix(filepath.Join(t.TempDir(), "guest.sock"))
+			},
+		},
+		{
+			name: "tcp",
+			listen: func(t *testing.T) (*runtime.SocketGuest, error) {
+				return runtime.ListenTCP("127.0.0.1:0")
+			},
+		},
+	}
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+			guest, err := tt.listen(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer guest.Close()
+
+			rt := runtime.NewRuntime(logger, guest)
+			ImportHostAPI(rt, &hostAPIImpl{
+				pingImpl: func(arg *message.String) (*message.String, error) {
+					return &message.String{Value: arg.Value + "Pong"}, nil
+				},
+			})
+
+			conn, err := net.Dial(guest.Addr().Network(), guest.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			err = rt.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			type Result = message.Result[*message.String, *message.Error]
+			got := &Result{}
+			err = got.UnmarshalELRPC(callHostAPI(t, conn, ModuleID, MethodID_HostAPI_Ping, &message.String{Value: "Ping"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &Result{IsOk: true, Ok: &message.String{Value: "PingPong"}}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			conn.Close()
+			err = rt.Wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSocketGuest_Kill(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest, err := runtime.ListenUnix(filepath.Join(t.TempDir(), "guest.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := runtime.NewRuntime(logger, guest)
+	conn, err := net.Dial(guest.Addr().Network(), guest.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rt.Kill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = rt.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after Kill")
+	}
+}
+
+func TestListenTCP_notLoopback(t *testing.T) {
+	_, err := runtime.ListenTCP("0.0.0.0:0")
+	if err == nil {
+		t.Fatal("want error but got nil")
+	}
+}
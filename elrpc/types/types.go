@@ -1,14 +1,40 @@
 package types
 
 import (
+	"context"
+
 	"github.com/genkami/elsi/elrpc/message"
 )
 
 type Runtime interface {
 	Use(moduleID uint32, methodID uint32, handler HostHandler)
+	UseStream(moduleID uint32, methodID uint32, handler StreamHandler)
 	Call(moduleID uint32, methodID uint32, args *message.Any) (*message.Any, error)
+	CallContext(ctx context.Context, moduleID uint32, methodID uint32, args *message.Any) (*message.Any, error)
 }
 
 type HostHandler interface {
 	HandleRequest(*message.Decoder) (message.Message, error)
 }
+
+// ContextHostHandler is a HostHandler that receives the context of the request.
+// The context carries the request's metadata (see builtin.MetadataFromContext) and is canceled at its deadline.
+// The runtime calls HandleRequestContext instead of HandleRequest if a handler implements this interface.
+type ContextHostHandler interface {
+	HostHandler
+	HandleRequestContext(context.Context, *message.Decoder) (message.Message, error)
+}
+
+// StreamHandler handles a streaming method. The request is decoded from dec as usual,
+// and then the handler exchanges an arbitrary number of messages with the guest through s.
+// Returning from HandleStream ends the stream; the returned error, if any, is sent to the guest.
+type StreamHandler interface {
+	HandleStream(ctx context.Context, dec *message.Decoder, s ServerStream) error
+}
+
+type ServerStream interface {
+	// Send sends a message to the guest. It blocks while the guest is not receiving messages.
+	Send(message.Message) error
+	// Recv receives a message from the guest. It returns io.EOF once the guest closes its side of the stream.
+	Recv() (*message.Any, error)
+}
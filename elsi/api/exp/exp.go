@@ -2,6 +2,8 @@
 package exp
 
 import (
+	"context"
+
 	"github.com/genkami/elsi/elrpc/apibuilder"
 	"github.com/genkami/elsi/elrpc/message"
 	"github.com/genkami/elsi/elrpc/types"
@@ -13,6 +15,8 @@ const (
 	MethodID_Stream_Read         = 0x0000_0000
 	MethodID_Stream_Write        = 0x0000_0001
 	MethodID_Stream_Close        = 0x0000_0002
+	MethodID_Stream_ReadChunks   = 0x0000_0003
+	MethodID_Stream_WriteChunks  = 0x0000_0004
 	MethodID_File_Open           = 0x0000_0010
 	MethodID_Stdio_OpenStdHandle = 0x0000_0020
 	MethodID_HTTP_Listen         = 0x0000_0030
@@ -69,12 +73,19 @@ type Stream interface {
 	Read(handle *Handle, size *message.Uint64) (*message.Bytes, error)
 	Write(handle *Handle, buf *message.Bytes) (*message.Uint64, error)
 	Close(handle *Handle) (message.Void, error)
+	// ReadChunks is a streaming method that sends the content of the handle in chunks of at most chunkSize bytes until EOF.
+	ReadChunks(ctx context.Context, handle *Handle, chunkSize *message.Uint64, s *apibuilder.StreamSender[*message.Bytes]) error
+	// WriteChunks is a streaming method that writes every chunk sent by the guest to the handle,
+	// and then returns the total number of bytes written.
+	WriteChunks(ctx context.Context, handle *Handle, s *apibuilder.StreamReceiver[*message.Bytes]) (*message.Uint64, error)
 }
 
 func ImportStream(rt types.Runtime, stream Stream) {
 	rt.Use(ModuleID, MethodID_Stream_Read, apibuilder.HostHandler2[*Handle, *message.Uint64, *message.Bytes](stream.Read))
 	rt.Use(ModuleID, MethodID_Stream_Write, apibuilder.HostHandler2[*Handle, *message.Bytes, *message.Uint64](stream.Write))
 	rt.Use(ModuleID, MethodID_Stream_Close, apibuilder.HostHandler1[*Handle, message.Void](stream.Close))
+	rt.UseStream(ModuleID, MethodID_Stream_ReadChunks, apibuilder.ServerStreamHandler2[*Handle, *message.Uint64, *message.Bytes](stream.ReadChunks))
+	rt.UseStream(ModuleID, MethodID_Stream_WriteChunks, apibuilder.ClientStreamHandler1[*Handle, *message.Bytes, *message.Uint64](stream.WriteChunks))
 }
 
 const (
@@ -0,0 +1,96 @@
+package guest
+
+import (
+	"io"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+// Exporter is a client for builtin.Exporter, which delivers the host's calls to the guest's methods.
+type Exporter struct {
+	c *Conn
+}
+
+func NewExporter(c *Conn) *Exporter {
+	return &Exporter{c: c}
+}
+
+// PollMethodCall waits for a call to the guest's method.
+func (e *Exporter) PollMethodCall() (*builtin.MethodCall, error) {
+	return Call[*builtin.MethodCall](e.c, builtin.ModuleID, builtin.MethodID_Exporter_PollMethodCall)
+}
+
+// PollMethodCalls waits for at most timeoutMillis milliseconds until any method call is queued,
+// and then returns at most maxCalls calls (0 means no limit).
+func (e *Exporter) PollMethodCalls(timeoutMillis, maxCalls uint64) ([]*builtin.MethodCall, error) {
+	calls, err := Call[*message.Array[*builtin.MethodCall]](e.c, builtin.ModuleID, builtin.MethodID_Exporter_PollMethodCalls,
+		&message.Uint64{Value: timeoutMillis}, &message.Uint64{Value: maxCalls})
+	if err != nil {
+		return nil, err
+	}
+	return calls.Items, nil
+}
+
+// SendResult sends the result of a method call.
+func (e *Exporter) SendResult(result *builtin.MethodResult) error {
+	_, err := Call[message.Void](e.c, builtin.ModuleID, builtin.MethodID_Exporter_SendResult, result)
+	return err
+}
+
+// Streaming is a client for builtin.Streaming, which exchanges messages with the host's streaming methods.
+type Streaming struct {
+	c *Conn
+}
+
+func NewStreaming(c *Conn) *Streaming {
+	return &Streaming{c: c}
+}
+
+// Recv receives a message from the stream. It returns io.EOF at the end of the stream.
+func (s *Streaming) Recv(streamID uint64) (*message.Any, error) {
+	data, err := Call[*message.Option[*message.Any]](s.c, builtin.ModuleID, builtin.MethodID_Streaming_Recv,
+		&message.Uint64{Value: streamID})
+	if err != nil {
+		return nil, err
+	}
+	if !data.IsSome {
+		return nil, io.EOF
+	}
+	return data.Some, nil
+}
+
+func (s *Streaming) Send(streamID uint64, data *message.Any) error {
+	_, err := Call[message.Void](s.c, builtin.ModuleID, builtin.MethodID_Streaming_Send,
+		&message.Uint64{Value: streamID}, data)
+	return err
+}
+
+// CloseSend tells the host that the guest has no more messages to send.
+func (s *Streaming) CloseSend(streamID uint64) error {
+	_, err := Call[message.Void](s.c, builtin.ModuleID, builtin.MethodID_Streaming_CloseSend,
+		&message.Uint64{Value: streamID})
+	return err
+}
+
+// Cancel aborts the stream.
+func (s *Streaming) Cancel(streamID uint64) error {
+	_, err := Call[message.Void](s.c, builtin.ModuleID, builtin.MethodID_Streaming_Cancel,
+		&message.Uint64{Value: streamID})
+	return err
+}
+
+// toAny encodes m as an Any.
+func toAny(m message.Marshaler) (*message.Any, error) {
+	enc := message.NewEncoder()
+	err := m.MarshalELRPC(enc)
+	if err != nil {
+		return nil, err
+	}
+	return &message.Any{Raw: enc.Buffer()}, nil
+}
+
+// fromAny decodes a into m.
+func fromAny(a *message.Any, m message.Unmarshaler) error {
+	return m.UnmarshalELRPC(message.NewDecoder(a.Raw))
+}
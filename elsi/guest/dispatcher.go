@@ -0,0 +1,192 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/types"
+)
+
+// Dispatcher runs the guest's methods called by the host.
+// Handlers are the same as the host's, so that apibuilder.HostHandler1 and so on can be used to implement them:
+//
+//	d := guest.NewDispatcher(conn)
+//	d.Handle(modID, methodID, apibuilder.HostHandler1[*message.String, *message.String](ping))
+//	err := d.Serve(ctx)
+//
+// Each call runs in its own goroutine, and the guest can keep calling the host's methods through the same Conn meanwhile.
+// Handlers that implement types.ContextHostHandler are canceled when the host cancels the call.
+type Dispatcher struct {
+	c        *Conn
+	exporter *Exporter
+
+	mu       sync.Mutex
+	handlers map[uint64]types.HostHandler
+	running  map[uint64]*runningCall
+}
+
+type runningCall struct {
+	cancel context.CancelFunc
+	// Whether the host has canceled the call, in which case it no longer waits for the result.
+	canceledByHost bool
+}
+
+func NewDispatcher(c *Conn) *Dispatcher {
+	return &Dispatcher{
+		c:        c,
+		exporter: NewExporter(c),
+		handlers: make(map[uint64]types.HostHandler),
+		running:  make(map[uint64]*runningCall),
+	}
+}
+
+// Handle registers the handler of a method. It must be called before Serve.
+func (d *Dispatcher) Handle(moduleID, methodID uint32, h types.HostHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[fullID(moduleID, methodID)] = h
+}
+
+// Serve switches the connection to push mode, and runs the methods called by the host until ctx is done
+// or the connection fails. It waits for the running methods to return before returning.
+func (d *Dispatcher) Serve(ctx context.Context) error {
+	calls := make(chan *builtin.MethodCall)
+	err := d.c.enablePush(calls)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		// Keep receiving frames so that the running methods and the guest can still call the host's methods.
+		go d.reject(calls)
+		wg.Wait()
+	}()
+	for {
+		select {
+		case call, ok := <-calls:
+			if !ok {
+				return d.c.readErr
+			}
+			if call.ModuleID == builtin.ModuleID && call.MethodID == builtin.MethodID_Notification_CancelCall {
+				d.cancel(call)
+				continue
+			}
+			callCtx := d.start(ctx, call.CallID)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.run(callCtx, call)
+			}()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// reject fails the method calls that arrive after Serve returns.
+func (d *Dispatcher) reject(calls <-chan *builtin.MethodCall) {
+	for call := range calls {
+		if call.ModuleID == builtin.ModuleID && call.MethodID == builtin.MethodID_Notification_CancelCall {
+			continue
+		}
+		call := call
+		go func() {
+			_ = d.exporter.SendResult(&builtin.MethodResult{
+				CallID: call.CallID,
+				RetVal: &message.Result[*message.Any, *message.Error]{Err: &message.Error{
+					ModuleID: builtin.ModuleID,
+					Code:     builtin.CodeUnavailable,
+					Message:  "the guest is no longer serving method calls",
+				}},
+			})
+		}()
+	}
+}
+
+func (d *Dispatcher) start(ctx context.Context, callID uint64) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running[callID] = &runningCall{cancel: cancel}
+	return ctx
+}
+
+func (d *Dispatcher) cancel(notification *builtin.MethodCall) {
+	var callID message.Uint64
+	err := fromAny(notification.Args, &callID)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rc, ok := d.running[callID.Value]; ok {
+		rc.canceledByHost = true
+		rc.cancel()
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context, call *builtin.MethodCall) {
+	resp, err := d.handle(ctx, call)
+	d.mu.Lock()
+	rc := d.running[call.CallID]
+	delete(d.running, call.CallID)
+	d.mu.Unlock()
+	rc.cancel()
+	if rc.canceledByHost {
+		return
+	}
+
+	retVal := &message.Result[*message.Any, *message.Error]{}
+	if err == nil {
+		retVal.Ok, err = toAny(resp)
+	}
+	if err != nil {
+		retVal.Err = toELRPCError(err)
+	} else {
+		retVal.IsOk = true
+	}
+	// The host discards the result if the call has been canceled in the meantime, so the error can be ignored.
+	_ = d.exporter.SendResult(&builtin.MethodResult{CallID: call.CallID, RetVal: retVal})
+}
+
+func (d *Dispatcher) handle(ctx context.Context, call *builtin.MethodCall) (message.Message, error) {
+	d.mu.Lock()
+	h, ok := d.handlers[fullID(call.ModuleID, call.MethodID)]
+	d.mu.Unlock()
+	if !ok {
+		return nil, &message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeUnimplemented,
+			Message:  fmt.Sprintf("method %X in module %X is not implemented", call.MethodID, call.ModuleID),
+		}
+	}
+	dec := message.NewDecoder(call.Args.Raw)
+	if ctxHandler, ok := h.(types.ContextHostHandler); ok {
+		return ctxHandler.HandleRequestContext(ctx, dec)
+	}
+	return h.HandleRequest(dec)
+}
+
+// toELRPCError converts an error returned by a handler into the one that is sent to the host.
+func toELRPCError(err error) *message.Error {
+	var elrpcErr *message.Error
+	if errors.As(err, &elrpcErr) {
+		return elrpcErr
+	}
+	return &message.Error{
+		ModuleID: builtin.ModuleID,
+		Code:     builtin.CodeInternal,
+		Message:  err.Error(),
+	}
+}
+
+func fullID(moduleID, methodID uint32) uint64 {
+	return uint64(moduleID)<<32 | uint64(methodID)
+}
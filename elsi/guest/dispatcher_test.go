@@ -0,0 +1,212 @@
+package guest_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/guest"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	testModuleID = 0x0000_ffff
+
+	methodID_Host_Upper   = 0x0000_0001
+	methodID_Guest_Ping   = 0x0000_0002
+	methodID_Guest_Block  = 0x0000_0003
+	methodID_Guest_Quit   = 0x0000_0004
+	methodID_Guest_Nope   = 0x0000_0005
+	methodID_Guest_Failed = 0x0000_0006
+)
+
+func TestDispatcher(t *testing.T) {
+	blockCanceled := make(chan struct{})
+	g := runtime.NewGoroutineGuest(func(ctx context.Context, s runtime.Stream) int {
+		conn := guest.NewConn(s, s)
+		d := guest.NewDispatcher(conn)
+		// Calls the host's method while handling the host's call.
+		d.Handle(testModuleID, methodID_Guest_Ping, apibuilder.HostHandler1[*message.String, *message.String](
+			func(s *message.String) (*message.String, error) {
+				upper, err := guest.Call[*message.String](conn, testModuleID, methodID_Host_Upper, s)
+				if err != nil {
+					return nil, err
+				}
+				return &message.String{Value: upper.Value + "!"}, nil
+			}))
+		d.Handle(testModuleID, methodID_Guest_Block, apibuilder.ContextHostHandler0[message.Void](
+			func(ctx context.Context) (message.Void, error) {
+				<-ctx.Done()
+				close(blockCanceled)
+				return message.Void{}, ctx.Err()
+			}))
+		d.Handle(testModuleID, methodID_Guest_Failed, apibuilder.HostHandler0[message.Void](
+			func() (message.Void, error) {
+				return message.Void{}, &message.Error{ModuleID: testModuleID, Code: 42, Message: "failed"}
+			}))
+		ctx, cancel := context.WithCancel(ctx)
+		d.Handle(testModuleID, methodID_Guest_Quit, apibuilder.HostHandler0[message.Void](
+			func() (message.Void, error) {
+				cancel()
+				return message.Void{}, nil
+			}))
+		err := d.Serve(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want context.Canceled but got %v", err)
+		}
+		// The guest can still call the host's methods.
+		upper, err := guest.Call[*message.String](conn, testModuleID, methodID_Host_Upper, &message.String{Value: "bye"})
+		if err != nil || upper.Value != "BYE" {
+			t.Errorf("want BYE but got %v, %v", upper, err)
+		}
+		return 0
+	})
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	rt := runtime.NewRuntime(logger, g)
+	rt.Use(testModuleID, methodID_Host_Upper, apibuilder.HostHandler1[*message.String, *message.String](
+		func(s *message.String) (*message.String, error) {
+			return &message.String{Value: strings.ToUpper(s.Value)}, nil
+		}))
+	ping := apibuilder.NewGuestDelegator1[*message.String, *message.String](rt, testModuleID, methodID_Guest_Ping)
+	block := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Block)
+	nope := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Nope)
+	failed := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Failed)
+	quit := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Quit)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ping.Call(&message.String{Value: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != "HELLO!" {
+		t.Errorf("want HELLO! but got %q", got.Value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = block.CallContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded but got %v", err)
+	}
+	select {
+	case <-blockCanceled:
+	case <-time.After(5 * time.Second):
+		t.Error("the handler is not canceled")
+	}
+
+	var elrpcErr *message.Error
+	_, err = nope.Call()
+	if !errors.As(err, &elrpcErr) || elrpcErr.Code != builtin.CodeUnimplemented {
+		t.Errorf("want CodeUnimplemented but got %v", err)
+	}
+	_, err = failed.Call()
+	if !errors.As(err, &elrpcErr) || elrpcErr.ModuleID != testModuleID || elrpcErr.Code != 42 {
+		t.Errorf("want code 42 but got %v", err)
+	}
+
+	_, err = quit.Call()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcher_Serve_callsAfterCancel(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	proceed := make(chan struct{})
+	g := runtime.NewGoroutineGuest(func(ctx context.Context, s runtime.Stream) int {
+		conn := guest.NewConn(s, s)
+		d := guest.NewDispatcher(conn)
+		// Calls the host's method after Serve is canceled.
+		d.Handle(testModuleID, methodID_Guest_Block, apibuilder.ContextHostHandler1[*message.String, *message.String](
+			func(ctx context.Context, s *message.String) (*message.String, error) {
+				close(started)
+				<-ctx.Done()
+				close(canceled)
+				<-proceed
+				return guest.Call[*message.String](conn, testModuleID, methodID_Host_Upper, s)
+			}))
+		ctx, cancel := context.WithCancel(ctx)
+		d.Handle(testModuleID, methodID_Guest_Quit, apibuilder.HostHandler0[message.Void](
+			func() (message.Void, error) {
+				cancel()
+				return message.Void{}, nil
+			}))
+		_ = d.Serve(ctx)
+		return 0
+	})
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	rt := runtime.NewRuntime(logger, g)
+	rt.Use(testModuleID, methodID_Host_Upper, apibuilder.HostHandler1[*message.String, *message.String](
+		func(s *message.String) (*message.String, error) {
+			return &message.String{Value: strings.ToUpper(s.Value)}, nil
+		}))
+	block := apibuilder.NewGuestDelegator1[*message.String, *message.String](rt, testModuleID, methodID_Guest_Block)
+	nope := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Nope)
+	quit := apibuilder.NewGuestDelegator0[message.Void](rt, testModuleID, methodID_Guest_Quit)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := make(chan *message.String, 1)
+	go func() {
+		ret, err := block.Call(&message.String{Value: "late"})
+		if err != nil {
+			t.Error(err)
+		}
+		blocked <- ret
+	}()
+	<-started
+	_, err = quit.Call()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-canceled
+	// Let Serve start waiting for the running method.
+	time.Sleep(10 * time.Millisecond)
+
+	// A call that arrives meanwhile is rejected without blocking the connection.
+	rejected := make(chan error, 1)
+	go func() {
+		_, err := nope.Call()
+		rejected <- err
+	}()
+	select {
+	case err := <-rejected:
+		var elrpcErr *message.Error
+		if !errors.As(err, &elrpcErr) || elrpcErr.Code != builtin.CodeUnavailable {
+			t.Errorf("want CodeUnavailable but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call is not rejected")
+	}
+	// The running method can still call the host's methods.
+	close(proceed)
+	select {
+	case ret := <-blocked:
+		if ret == nil || ret.Value != "LATE" {
+			t.Errorf("want LATE but got %v", ret)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the running method is deadlocked")
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
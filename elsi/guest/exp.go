@@ -0,0 +1,171 @@
+package guest
+
+import (
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elsi/api/exp"
+)
+
+// Stream is a client for exp.Stream.
+type Stream struct {
+	c         *Conn
+	streaming *Streaming
+}
+
+func NewStream(c *Conn) *Stream {
+	return &Stream{c: c, streaming: NewStreaming(c)}
+}
+
+// Read reads at most size bytes from the handle. It returns an empty slice at EOF.
+func (s *Stream) Read(h *exp.Handle, size uint64) ([]byte, error) {
+	buf, err := Call[*message.Bytes](s.c, exp.ModuleID, exp.MethodID_Stream_Read, h, &message.Uint64{Value: size})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Value, nil
+}
+
+// Write writes buf to the handle, and returns the number of bytes written.
+func (s *Stream) Write(h *exp.Handle, buf []byte) (uint64, error) {
+	n, err := Call[*message.Uint64](s.c, exp.ModuleID, exp.MethodID_Stream_Write, h, &message.Bytes{Value: buf})
+	if err != nil {
+		return 0, err
+	}
+	return n.Value, nil
+}
+
+func (s *Stream) Close(h *exp.Handle) error {
+	_, err := Call[message.Void](s.c, exp.ModuleID, exp.MethodID_Stream_Close, h)
+	return err
+}
+
+// ReadChunks starts reading the handle in chunks of at most chunkSize bytes until EOF.
+func (s *Stream) ReadChunks(h *exp.Handle, chunkSize uint64) (*ChunkReader, error) {
+	id, err := Call[*message.Uint64](s.c, exp.ModuleID, exp.MethodID_Stream_ReadChunks, h, &message.Uint64{Value: chunkSize})
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkReader{s: s.streaming, id: id.Value}, nil
+}
+
+// WriteChunks starts writing chunks to the handle.
+func (s *Stream) WriteChunks(h *exp.Handle) (*ChunkWriter, error) {
+	id, err := Call[*message.Uint64](s.c, exp.ModuleID, exp.MethodID_Stream_WriteChunks, h)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkWriter{s: s.streaming, id: id.Value}, nil
+}
+
+// ChunkReader receives the chunks sent by Stream.ReadChunks.
+type ChunkReader struct {
+	s  *Streaming
+	id uint64
+}
+
+// Next returns the next chunk. It returns io.EOF after the last chunk.
+func (r *ChunkReader) Next() ([]byte, error) {
+	data, err := r.s.Recv(r.id)
+	if err != nil {
+		return nil, err
+	}
+	var chunk message.Bytes
+	err = fromAny(data, &chunk)
+	if err != nil {
+		return nil, err
+	}
+	return chunk.Value, nil
+}
+
+// Cancel stops reading before EOF.
+func (r *ChunkReader) Cancel() error {
+	return r.s.Cancel(r.id)
+}
+
+// ChunkWriter sends chunks to Stream.WriteChunks.
+type ChunkWriter struct {
+	s  *Streaming
+	id uint64
+}
+
+// Write sends p as a chunk.
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+	data, err := toAny(&message.Bytes{Value: p})
+	if err != nil {
+		return 0, err
+	}
+	err = w.s.Send(w.id, data)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseAndRecv finishes writing, and returns the total number of bytes written to the handle.
+func (w *ChunkWriter) CloseAndRecv() (uint64, error) {
+	err := w.s.CloseSend(w.id)
+	if err != nil {
+		return 0, err
+	}
+	data, err := w.s.Recv(w.id)
+	if err != nil {
+		return 0, err
+	}
+	var n message.Uint64
+	err = fromAny(data, &n)
+	if err != nil {
+		return 0, err
+	}
+	return n.Value, nil
+}
+
+// File is a client for exp.File.
+type File struct {
+	c *Conn
+}
+
+func NewFile(c *Conn) *File {
+	return &File{c: c}
+}
+
+// Open opens the file at path. mode is a combination of exp.OpenModeCreate, exp.OpenModeRead and so on.
+func (f *File) Open(path string, mode uint64) (*exp.Handle, error) {
+	return Call[*exp.Handle](f.c, exp.ModuleID, exp.MethodID_File_Open, &message.String{Value: path}, &message.Uint64{Value: mode})
+}
+
+// Stdio is a client for exp.Stdio.
+type Stdio struct {
+	c *Conn
+}
+
+func NewStdio(c *Conn) *Stdio {
+	return &Stdio{c: c}
+}
+
+// OpenStdHandle opens the host's stdin, stdout or stderr specified by exp.HandleTypeStdin and so on.
+func (s *Stdio) OpenStdHandle(handleType uint8) (*exp.Handle, error) {
+	return Call[*exp.Handle](s.c, exp.ModuleID, exp.MethodID_Stdio_OpenStdHandle, &message.Uint8{Value: handleType})
+}
+
+// HTTP is a client for exp.HTTP.
+type HTTP struct {
+	c *Conn
+}
+
+func NewHTTP(c *Conn) *HTTP {
+	return &HTTP{c: c}
+}
+
+// Listen starts accepting requests on the listener configured by the host with the given name.
+func (h *HTTP) Listen(name string) (*exp.Handle, error) {
+	return Call[*exp.Handle](h.c, exp.ModuleID, exp.MethodID_HTTP_Listen, &message.String{Value: name})
+}
+
+// PollRequest waits for a request to the listener.
+func (h *HTTP) PollRequest(listener *exp.Handle) (*exp.ServerRequest, error) {
+	return Call[*exp.ServerRequest](h.c, exp.ModuleID, exp.MethodID_HTTP_PollRequest, listener)
+}
+
+// SendResponseHeader sends the header of the response to the request, and returns the handle to write the body.
+func (h *HTTP) SendResponseHeader(listener *exp.Handle, requestID uint64, header *exp.ServerResponseHeader) (*exp.Handle, error) {
+	return Call[*exp.Handle](h.c, exp.ModuleID, exp.MethodID_HTTP_SendResponse, listener, &message.Uint64{Value: requestID}, header)
+}
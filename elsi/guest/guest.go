@@ -0,0 +1,272 @@
+// Package guest is an SDK for guests written in Go.
+// It owns the connection to the host, and provides typed clients for the host modules:
+//
+//	conn, err := guest.Connect()
+//	stdout, err := guest.NewStdio(conn).OpenStdHandle(exp.HandleTypeStdout)
+//	_, err = guest.NewStream(conn).Write(stdout, []byte("Hello, world!\n"))
+//
+// Methods that fail on the host return *message.Error, which can be inspected by errors.As.
+package guest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+// EnvRPCFDs is the environment variable that holds the file descriptors for ELRPC, as in "R,W".
+// It is set by hosts that run the guest with runtime.NewProcessGuestFD.
+const EnvRPCFDs = "ELSI_RPC_FDS"
+
+// Conn is a connection to the host. It is safe for concurrent use.
+// Calls are sent one at a time, and their responses are received in the same order.
+type Conn struct {
+	mu sync.Mutex
+	r  io.Reader
+	w  io.Writer
+
+	// Set by enablePush; see Dispatcher.
+	push bool
+	// The callers waiting for responses in push mode, in the order of the requests.
+	pending []chan pushedResponse
+	// Closed when the connection fails in push mode, after which readErr is valid.
+	broken  chan struct{}
+	readErr error
+}
+
+type pushedResponse struct {
+	dec *message.Decoder
+	err error
+}
+
+// NewConn creates a connection that reads responses from r and writes requests to w.
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{r: r, w: w}
+}
+
+// Connect connects to the host through the file descriptors in EnvRPCFDs if set, or stdin and stdout otherwise.
+func Connect() (*Conn, error) {
+	fds, ok := os.LookupEnv(EnvRPCFDs)
+	if !ok {
+		return NewConn(os.Stdin, os.Stdout), nil
+	}
+	r, w, ok := strings.Cut(fds, ",")
+	if !ok {
+		return nil, fmt.Errorf("guest: invalid %s: %q", EnvRPCFDs, fds)
+	}
+	rfd, err := strconv.ParseUint(r, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("guest: invalid %s: %w", EnvRPCFDs, err)
+	}
+	wfd, err := strconv.ParseUint(w, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("guest: invalid %s: %w", EnvRPCFDs, err)
+	}
+	return NewConn(os.NewFile(uintptr(rfd), "elsi-rpc-r"), os.NewFile(uintptr(wfd), "elsi-rpc-w")), nil
+}
+
+// Call calls a method of the host, and decodes its result into resp.
+// It returns *message.Error if the method fails.
+func (c *Conn) Call(moduleID, methodID uint32, resp message.Unmarshaler, args ...message.Marshaler) error {
+	req, err := encodeRequest(moduleID, methodID, args...)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.push {
+		return c.callPushed(req, resp)
+	}
+	defer c.mu.Unlock()
+	return c.roundTrip(req, resp)
+}
+
+// encodeRequest encodes a request as a frame.
+func encodeRequest(moduleID, methodID uint32, args ...message.Marshaler) ([]byte, error) {
+	enc := message.NewEncoder()
+	err := enc.EncodeUint32(moduleID)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.EncodeUint32(methodID)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		err = arg.MarshalELRPC(enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	body := enc.Buffer()
+	req, err := message.AppendLength(make([]byte, 0, message.LengthSize+len(body)), len(body))
+	if err != nil {
+		return nil, err
+	}
+	return append(req, body...), nil
+}
+
+// roundTrip sends req and receives its response. The caller must hold c.mu.
+func (c *Conn) roundTrip(req []byte, resp message.Unmarshaler) error {
+	_, err := c.w.Write(req)
+	if err != nil {
+		return err
+	}
+	buf, err := readFrame(c.r)
+	if err != nil {
+		return err
+	}
+	return decodeResult(message.NewDecoder(buf), resp)
+}
+
+// callPushed sends req, and waits for readPushed to receive its response. The caller must hold c.mu, which is released.
+func (c *Conn) callPushed(req []byte, resp message.Unmarshaler) error {
+	ch := make(chan pushedResponse, 1)
+	select {
+	case <-c.broken:
+		c.mu.Unlock()
+		return c.readErr
+	default:
+	}
+	_, err := c.w.Write(req)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.pending = append(c.pending, ch)
+	c.mu.Unlock()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		return decodeResult(r.dec, resp)
+	case <-c.broken:
+		return c.readErr
+	}
+}
+
+// enablePush switches the connection to push mode, in which the host sends method calls to calls as soon as they are queued.
+// calls is closed when the connection fails.
+func (c *Conn) enablePush(calls chan<- *builtin.MethodCall) error {
+	req, err := encodeRequest(builtin.ModuleID, builtin.MethodID_Exporter_EnablePush)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.push {
+		return errors.New("guest: push mode is already enabled")
+	}
+	err = c.roundTrip(req, message.Void{})
+	if err != nil {
+		return err
+	}
+	c.push = true
+	c.broken = make(chan struct{})
+	go c.readPushed(calls)
+	return nil
+}
+
+// readPushed receives the frames in push mode until the connection fails.
+func (c *Conn) readPushed(calls chan<- *builtin.MethodCall) {
+	err := c.dispatchPushed(calls)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readErr = err
+	close(c.broken)
+	close(calls)
+}
+
+func (c *Conn) dispatchPushed(calls chan<- *builtin.MethodCall) error {
+	for {
+		buf, err := readFrame(c.r)
+		if err != nil {
+			return err
+		}
+		dec := message.NewDecoder(buf)
+		kind, err := dec.DecodeUint8()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case builtin.FrameKindResponse:
+			c.mu.Lock()
+			if len(c.pending) == 0 {
+				c.mu.Unlock()
+				return errors.New("guest: received an unexpected response")
+			}
+			ch := c.pending[0]
+			c.pending = c.pending[1:]
+			c.mu.Unlock()
+			ch <- pushedResponse{dec: dec}
+		case builtin.FrameKindMethodCall:
+			call := &builtin.MethodCall{}
+			err := call.UnmarshalELRPC(dec)
+			if err != nil {
+				return err
+			}
+			calls <- call
+		default:
+			return fmt.Errorf("guest: unknown frame kind: %d", kind)
+		}
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [message.LengthSize]byte
+	_, err := io.ReadFull(r, lenBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	length, err := message.DecodeLength(lenBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decodeResult decodes a Result whose Ok value is decoded into resp.
+func decodeResult(dec *message.Decoder, resp message.Unmarshaler) error {
+	tag, err := dec.DecodeVariantTag()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case 0:
+		return resp.UnmarshalELRPC(dec)
+	case 1:
+		e := &message.Error{}
+		err := e.UnmarshalELRPC(dec)
+		if err != nil {
+			return err
+		}
+		return e
+	default:
+		return fmt.Errorf("guest: invalid result variant: %d", tag)
+	}
+}
+
+// Call calls a method of the host, and returns its result. It returns *message.Error if the method fails.
+func Call[R message.Message](c *Conn, moduleID, methodID uint32, args ...message.Marshaler) (R, error) {
+	var zero R
+	resp := message.NewMessage[R]()
+	err := c.Call(moduleID, methodID, resp, args...)
+	if err != nil {
+		return zero, err
+	}
+	return resp.(R), nil
+}
@@ -0,0 +1,154 @@
+package guest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/api/exp"
+	"github.com/genkami/elsi/elsi/guest"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+	"golang.org/x/exp/slog"
+)
+
+// run runs fn as a guest of a runtime that provides exp, whose stdout is written to the returned buffer.
+func run(t *testing.T, fn func(c *guest.Conn) error) *bytes.Buffer {
+	t.Helper()
+	var guestErr error
+	g := runtime.NewGoroutineGuest(func(_ context.Context, s runtime.Stream) int {
+		guestErr = fn(guest.NewConn(s, s))
+		return 0
+	})
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	rt := runtime.NewRuntime(logger, g)
+	stdout := &bytes.Buffer{}
+	hs := expimpl.NewHandleSet()
+	exp.UseWorld(rt, &exp.Imports{
+		Stream: expimpl.NewStream(hs),
+		File:   expimpl.NewFile(hs),
+		Stdio: expimpl.NewStdio(hs, map[uint8]expimpl.StdHandleCtor{
+			exp.HandleTypeStdout: func() (any, error) { return stdout, nil },
+		}),
+		HTTP: expimpl.NewHTTP(logger, hs, nil),
+	})
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guestErr != nil {
+		t.Fatal(guestErr)
+	}
+	return stdout
+}
+
+func TestStream_Write(t *testing.T) {
+	stdout := run(t, func(c *guest.Conn) error {
+		h, err := guest.NewStdio(c).OpenStdHandle(exp.HandleTypeStdout)
+		if err != nil {
+			return err
+		}
+		n, err := guest.NewStream(c).Write(h, []byte("Hello, world!\n"))
+		if err != nil {
+			return err
+		}
+		if n != 14 {
+			t.Errorf("want 14 bytes but got %d", n)
+		}
+		return nil
+	})
+	if stdout.String() != "Hello, world!\n" {
+		t.Errorf("want %q but got %q", "Hello, world!\n", stdout.String())
+	}
+}
+
+func TestConn_Call_error(t *testing.T) {
+	run(t, func(c *guest.Conn) error {
+		_, err := guest.NewStdio(c).OpenStdHandle(exp.HandleTypeStdin)
+		var elrpcErr *message.Error
+		if !errors.As(err, &elrpcErr) {
+			t.Errorf("want *message.Error but got %v", err)
+		}
+
+		_, err = guest.Call[*message.Uint8](c, 0x0000_ffff, 0x0000_0001)
+		if !errors.As(err, &elrpcErr) {
+			t.Errorf("want *message.Error but got %v", err)
+		}
+		return nil
+	})
+}
+
+func TestStream_chunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	run(t, func(c *guest.Conn) error {
+		file := guest.NewFile(c)
+		stream := guest.NewStream(c)
+		h, err := file.Open(path, exp.OpenModeCreate|exp.OpenModeWrite)
+		if err != nil {
+			return err
+		}
+		w, err := stream.WriteChunks(h)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range []string{"foo", "bar", "baz"} {
+			_, err := w.Write([]byte(chunk))
+			if err != nil {
+				return err
+			}
+		}
+		n, err := w.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		if n != 9 {
+			t.Errorf("want 9 bytes but got %d", n)
+		}
+		err = stream.Close(h)
+		if err != nil {
+			return err
+		}
+
+		h, err = file.Open(path, exp.OpenModeRead)
+		if err != nil {
+			return err
+		}
+		r, err := stream.ReadChunks(h, 4)
+		if err != nil {
+			return err
+		}
+		var got []byte
+		for {
+			chunk, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if len(chunk) > 4 {
+				t.Errorf("too large chunk: %q", chunk)
+			}
+			got = append(got, chunk...)
+		}
+		if string(got) != "foobarbaz" {
+			t.Errorf("want foobarbaz but got %q", got)
+		}
+		return stream.Close(h)
+	})
+}
+
+func TestEnvRPCFDs(t *testing.T) {
+	if guest.EnvRPCFDs != runtime.EnvRPCFDs {
+		t.Errorf("want %q but got %q", runtime.EnvRPCFDs, guest.EnvRPCFDs)
+	}
+}
@@ -2,6 +2,7 @@ package expimpl
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/genkami/elsi/elrpc/message"
 	"github.com/genkami/elsi/elsi/api/exp"
@@ -25,7 +26,13 @@ func (f *File) Open(path *message.String, mode *message.Uint64) (*exp.Handle, er
 		// TODO: convert to ELRPC error
 		return nil, err
 	}
-	hID := f.hs.Register(file)
+	// The absolute path is needed to reopen the file on restore.
+	absPath, err := filepath.Abs(path.Value)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	hID := f.hs.register(file, saveFile(file, absPath, openMode))
 	return &exp.Handle{ID: hID}, nil
 }
 
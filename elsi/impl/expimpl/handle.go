@@ -1,24 +1,40 @@
 package expimpl
 
-import "sync"
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
 
 type HandleSet struct {
 	mu    sync.Mutex
 	next  uint64
 	items map[uint64]any
+	// Functions that save the handles that can be restored by RestoreHandleSet.
+	savers map[uint64]func() (*HandleState, error)
 }
 
 func NewHandleSet() *HandleSet {
 	return &HandleSet{
-		items: make(map[uint64]any),
+		items:  make(map[uint64]any),
+		savers: make(map[uint64]func() (*HandleState, error)),
 	}
 }
 
 func (hs *HandleSet) Register(v any) uint64 {
+	return hs.register(v, nil)
+}
+
+func (hs *HandleSet) register(v any, save func() (*HandleState, error)) uint64 {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
 	hs.next++
 	hs.items[hs.next] = v
+	if save != nil {
+		hs.savers[hs.next] = save
+	}
 	return hs.next
 }
 
@@ -34,5 +50,135 @@ func (hs *HandleSet) Remove(handle uint64) (any, bool) {
 	defer hs.mu.Unlock()
 	v, ok := hs.items[handle]
 	delete(hs.items, handle)
+	delete(hs.savers, handle)
 	return v, ok
 }
+
+const (
+	// A file opened by File.Open.
+	HandleKindFile = "file"
+	// A handle opened by Stdio.OpenStdHandle.
+	HandleKindStd = "std"
+)
+
+// HandleState is a saved handle.
+type HandleState struct {
+	ID   uint64 `json:"id"`
+	Kind string `json:"kind"`
+
+	// The absolute path, the flags given to os.OpenFile and the current offset of a file.
+	Path   string `json:"path,omitempty"`
+	Flag   int    `json:"flag,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+
+	// The type of a standard handle, such as exp.HandleTypeStdout.
+	StdType uint8 `json:"std_type,omitempty"`
+}
+
+// HandleSetState is a saved HandleSet.
+type HandleSetState struct {
+	Next    uint64         `json:"next"`
+	Handles []*HandleState `json:"handles"`
+	// The handles that cannot be saved, such as HTTP requests. They no longer exist after restore.
+	Dropped []uint64 `json:"dropped,omitempty"`
+}
+
+// Snapshot saves the handles that can be reopened later: files and standard handles.
+func (hs *HandleSet) Snapshot() *HandleSetState {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	st := &HandleSetState{Next: hs.next}
+	for id := range hs.items {
+		save, ok := hs.savers[id]
+		if !ok {
+			st.Dropped = append(st.Dropped, id)
+			continue
+		}
+		h, err := save()
+		if err != nil {
+			st.Dropped = append(st.Dropped, id)
+			continue
+		}
+		h.ID = id
+		st.Handles = append(st.Handles, h)
+	}
+	sort.Slice(st.Handles, func(i, j int) bool {
+		return st.Handles[i].ID < st.Handles[j].ID
+	})
+	sort.Slice(st.Dropped, func(i, j int) bool {
+		return st.Dropped[i] < st.Dropped[j]
+	})
+	return st
+}
+
+// RestoreHandleSet reopens the handles in st. Files are reopened at the same paths and offsets,
+// and standard handles are created by stdHandles as in NewStdio.
+func RestoreHandleSet(st *HandleSetState, stdHandles map[uint8]StdHandleCtor) (*HandleSet, error) {
+	hs := NewHandleSet()
+	hs.next = st.Next
+	for _, h := range st.Handles {
+		v, save, err := restoreHandle(h, stdHandles)
+		if err != nil {
+			hs.closeAll()
+			return nil, fmt.Errorf("failed to restore handle %d: %w", h.ID, err)
+		}
+		hs.items[h.ID] = v
+		hs.savers[h.ID] = save
+	}
+	return hs, nil
+}
+
+func restoreHandle(h *HandleState, stdHandles map[uint8]StdHandleCtor) (any, func() (*HandleState, error), error) {
+	switch h.Kind {
+	case HandleKindFile:
+		// The file must not be truncated or created again.
+		file, err := os.OpenFile(h.Path, h.Flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Flag&os.O_APPEND == 0 {
+			_, err = file.Seek(h.Offset, io.SeekStart)
+			if err != nil {
+				file.Close()
+				return nil, nil, err
+			}
+		}
+		return file, saveFile(file, h.Path, h.Flag), nil
+	case HandleKindStd:
+		ctor, ok := stdHandles[h.StdType]
+		if !ok {
+			return nil, nil, errInvalidHandleType
+		}
+		v, err := ctor()
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, saveStd(h.StdType), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown handle kind %q", h.Kind)
+	}
+}
+
+func (hs *HandleSet) closeAll() {
+	for _, v := range hs.items {
+		if c, ok := v.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func saveFile(file *os.File, path string, flag int) func() (*HandleState, error) {
+	return func() (*HandleState, error) {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return &HandleState{Kind: HandleKindFile, Path: path, Flag: flag, Offset: offset}, nil
+	}
+}
+
+func saveStd(hType uint8) func() (*HandleState, error) {
+	return func() (*HandleState, error) {
+		return &HandleState{Kind: HandleKindStd, StdType: hType}, nil
+	}
+}
@@ -1,6 +1,7 @@
 package expimpl
 
 import (
+	"errors"
 	"io"
 	"net"
 	"net/http"
@@ -33,6 +34,8 @@ type httpListener struct {
 	hs      *HandleSet
 	lis     net.Listener
 	waiters httpWaiterSet
+	// Closed when the listener stops accepting connections, after which no guest responds to the pending requests.
+	stopped chan struct{}
 }
 
 var (
@@ -51,14 +54,17 @@ type httpWaiter struct {
 	respHeaderCh    chan *exp.ServerResponseHeader
 	respHandleCh    chan *exp.Handle
 	respBodyCloseCh chan struct{}
+	// Closed when ServeHTTP returns.
+	done chan struct{}
 }
 
 var _ exp.HTTP = (*HTTP)(nil)
 
 func NewHTTP(logger *slog.Logger, hs *HandleSet, allowedListeners map[string]HttpListenerConfig) *HTTP {
 	return &HTTP{
-		logger: logger,
-		hs:     hs,
+		logger:           logger,
+		hs:               hs,
+		allowedListeners: allowedListeners,
 	}
 }
 
@@ -84,10 +90,12 @@ func (h *HTTP) Listen(name *message.String) (*exp.Handle, error) {
 		waiters: httpWaiterSet{
 			all: make(map[uint64]*httpWaiter),
 		},
+		stopped: make(chan struct{}),
 	}
 	go func() {
+		defer close(listener.stopped)
 		err := http.Serve(lis, listener)
-		if err != nil && err != http.ErrServerClosed {
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
 			logger.Error("server terminated unexpectedly",
 				slog.String("error", err.Error()))
 		}
@@ -139,13 +147,22 @@ func (lis *httpListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Body:   &exp.Handle{ID: reqHandle},
 	}
 	waiter := lis.enqueue(req)
+	defer lis.dequeue(req.RequestID)
 
 	// meanwhile:
 	// * guest calls HTTP.PollRequest
 	// * guest calls Stream.Read to reqHandle
 	// * guest calls HTTP.SendResponseHeader
 
-	respHeader := <-waiter.respHeaderCh
+	var respHeader *exp.ServerResponseHeader
+	select {
+	case respHeader = <-waiter.respHeaderCh:
+	case <-lis.stopped:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
 	w.WriteHeader(int(respHeader.Status))
 
 	respHandle := lis.hs.Register(&httpResponseWriter{
@@ -166,24 +183,37 @@ func (lis *httpListener) enqueue(req *exp.ServerRequest) *httpWaiter {
 		respHeaderCh:    make(chan *exp.ServerResponseHeader, 1),
 		respHandleCh:    make(chan *exp.Handle, 1),
 		respBodyCloseCh: make(chan struct{}, 1),
+		done:            make(chan struct{}),
 	}
 	lis.waiters.mu.Lock()
 	defer lis.waiters.mu.Unlock()
 	lis.waiters.next++
+	req.RequestID = lis.waiters.next
 	lis.waiters.all[lis.waiters.next] = w
 	lis.waiters.queue = append(lis.waiters.queue, req)
 	return w
 }
 
+// dequeue forgets the request after its response is finished.
+func (lis *httpListener) dequeue(reqID uint64) {
+	lis.waiters.mu.Lock()
+	defer lis.waiters.mu.Unlock()
+	close(lis.waiters.all[reqID].done)
+	delete(lis.waiters.all, reqID)
+}
+
 func (lis *httpListener) pollRequest() (*exp.ServerRequest, error) {
 	lis.waiters.mu.Lock()
 	defer lis.waiters.mu.Unlock()
-	if len(lis.waiters.queue) == 0 {
-		return nil, errNoRequest
+	for len(lis.waiters.queue) > 0 {
+		req := lis.waiters.queue[0]
+		lis.waiters.queue = lis.waiters.queue[1:]
+		// Skip the requests that have been abandoned.
+		if _, ok := lis.waiters.all[req.RequestID]; ok {
+			return req, nil
+		}
 	}
-	req := lis.waiters.queue[0]
-	lis.waiters.queue = lis.waiters.queue[1:]
-	return req, nil
+	return nil, errNoRequest
 }
 
 func (list *httpListener) sendResponseHeader(reqID *message.Uint64, header *exp.ServerResponseHeader) (*exp.Handle, error) {
@@ -194,8 +224,13 @@ func (list *httpListener) sendResponseHeader(reqID *message.Uint64, header *exp.
 		return nil, errNoSuchHandle
 	}
 	w.respHeaderCh <- header
-	respHandle := <-w.respHandleCh
-	return respHandle, nil
+	select {
+	case respHandle := <-w.respHandleCh:
+		return respHandle, nil
+	case <-w.done:
+		// The request has been abandoned.
+		return nil, errNoSuchHandle
+	}
 }
 
 func (l *httpListener) Close() error {
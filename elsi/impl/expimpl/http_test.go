@@ -0,0 +1,89 @@
+package expimpl_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elsi/api/exp"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+	"golang.org/x/exp/slog"
+)
+
+func TestHTTP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	hs := expimpl.NewHandleSet()
+	h := expimpl.NewHTTP(logger, hs, map[string]expimpl.HttpListenerConfig{
+		"default": {Ctor: func(string) (net.Listener, error) { return lis, nil }},
+	})
+	stream := expimpl.NewStream(hs)
+
+	_, err = h.Listen(&message.String{Value: "unknown"})
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+	handle, err := h.Listen(&message.String{Value: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	// Serves two requests so that a request ID of zero would collide with the next one.
+	for _, want := range []string{"first", "second"} {
+		type result struct {
+			body string
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := http.Get("http://" + lis.Addr().String() + "/" + want)
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			done <- result{body: string(body), err: err}
+		}()
+
+		var req *exp.ServerRequest
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			req, err = h.PollRequest(handle)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("no request: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if req.Path != "/"+want {
+			t.Errorf("want /%s but got %s", want, req.Path)
+		}
+		resp, err := h.SendResponseHeader(handle, &message.Uint64{Value: req.RequestID}, &exp.ServerResponseHeader{Status: http.StatusOK})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Write(resp, &message.Bytes{Value: []byte(want)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Close(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := <-done
+		if got.err != nil || got.body != want {
+			t.Errorf("want %q but got %q, %v", want, got.body, got.err)
+		}
+	}
+}
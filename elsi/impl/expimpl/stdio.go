@@ -34,6 +34,6 @@ func (s *Stdio) OpenStdHandle(hType *message.Uint8) (*exp.Handle, error) {
 	if err != nil {
 		return nil, err
 	}
-	hID := s.hs.Register(instance)
+	hID := s.hs.register(instance, saveStd(hType.Value))
 	return &exp.Handle{ID: hID}, nil
 }
@@ -1,12 +1,25 @@
 package expimpl
 
 import (
+	"context"
+	"errors"
 	"io"
 
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/apibuilder"
 	"github.com/genkami/elsi/elrpc/message"
 	"github.com/genkami/elsi/elsi/api/exp"
 )
 
+// MaxChunkSize is the largest chunk that Stream.ReadChunks sends. Larger chunk sizes requested by the guest are reduced to it.
+const MaxChunkSize = 1 << 20
+
+var errZeroChunkSize = &message.Error{
+	ModuleID: builtin.ModuleID,
+	Code:     builtin.CodeInvalidRequest,
+	Message:  "chunk size must be positive",
+}
+
 type Stream struct {
 	hs *HandleSet
 }
@@ -69,3 +82,68 @@ func (s *Stream) Close(handle *exp.Handle) (message.Void, error) {
 	}
 	return message.Void{}, nil
 }
+
+func (s *Stream) ReadChunks(ctx context.Context, handle *exp.Handle, chunkSize *message.Uint64, sender *apibuilder.StreamSender[*message.Bytes]) error {
+	if chunkSize.Value == 0 {
+		return errZeroChunkSize
+	}
+	size := chunkSize.Value
+	if size > MaxChunkSize {
+		size = MaxChunkSize
+	}
+	instance, ok := s.hs.Get(handle.ID)
+	if !ok {
+		return errNoSuchHandle
+	}
+	r, ok := instance.(io.Reader)
+	if !ok {
+		return errUnsupported
+	}
+	for {
+		buf := make([]byte, size)
+		n, err := r.Read(buf)
+		if n > 0 {
+			sendErr := sender.Send(&message.Bytes{Value: buf[:n]})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			// TODO: convert to ELRPC error
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Stream) WriteChunks(ctx context.Context, handle *exp.Handle, receiver *apibuilder.StreamReceiver[*message.Bytes]) (*message.Uint64, error) {
+	instance, ok := s.hs.Get(handle.ID)
+	if !ok {
+		return nil, errNoSuchHandle
+	}
+	w, ok := instance.(io.Writer)
+	if !ok {
+		return nil, errUnsupported
+	}
+	var total uint64
+	for {
+		chunk, err := receiver.Recv()
+		if errors.Is(err, io.EOF) {
+			return &message.Uint64{Value: total}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, err := w.Write(chunk.Value)
+		total += uint64(n)
+		if err != nil {
+			// TODO: convert to ELRPC error
+			return nil, err
+		}
+	}
+}
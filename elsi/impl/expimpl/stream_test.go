@@ -0,0 +1,74 @@
+package expimpl_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elsi/api/exp"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+)
+
+type mockServerStream struct {
+	sent []message.Message
+}
+
+func (s *mockServerStream) Send(m message.Message) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *mockServerStream) Recv() (*message.Any, error) {
+	return nil, io.EOF
+}
+
+func readChunks(t *testing.T, content []byte, chunkSize uint64) ([]message.Message, error) {
+	t.Helper()
+	hs := expimpl.NewHandleSet()
+	h := hs.Register(bytes.NewReader(content))
+	enc := message.NewEncoder()
+	err := (&exp.Handle{ID: h}).MarshalELRPC(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.EncodeUint64(chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := apibuilder.ServerStreamHandler2[*exp.Handle, *message.Uint64, *message.Bytes](expimpl.NewStream(hs).ReadChunks)
+	s := &mockServerStream{}
+	err = handler.HandleStream(context.Background(), message.NewDecoder(enc.Buffer()), s)
+	return s.sent, err
+}
+
+func TestStream_ReadChunks_zero(t *testing.T) {
+	_, err := readChunks(t, []byte("foo"), 0)
+	var elrpcErr *message.Error
+	if !errors.As(err, &elrpcErr) || elrpcErr.Code != builtin.CodeInvalidRequest {
+		t.Errorf("want CodeInvalidRequest but got %v", err)
+	}
+}
+
+func TestStream_ReadChunks_huge(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), expimpl.MaxChunkSize+1)
+	sent, err := readChunks(t, content, 1<<62)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for _, m := range sent {
+		chunk := m.(*message.Bytes).Value
+		if len(chunk) > expimpl.MaxChunkSize {
+			t.Errorf("want at most %d bytes but got %d", expimpl.MaxChunkSize, len(chunk))
+		}
+		got = append(got, chunk...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("want %d bytes but got %d", len(content), len(got))
+	}
+}
@@ -0,0 +1,880 @@
+// Package befunge implements a Befunge-93/98 interpreter guest.
+//
+// The character and number I/O instructions (`,` `.` `~` `&`) write to and read from the ELRPC stream.
+// In Befunge-98, the ELRP fingerprint (see Config.ELRPC) provides instructions that make ELRPC calls
+// without encoding messages by hand.
+package befunge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+type Mode int
+
+const (
+	// Befunge-93: an 80x25 playfield, and unknown instructions are errors.
+	Befunge93 Mode = iota
+	// Funge-98 restricted to two dimensions, without concurrency and file I/O.
+	Befunge98
+)
+
+type Config struct {
+	// Enables the ELRP fingerprint. It is only available in Befunge-98.
+	ELRPC bool
+	// The seed of `?`. A fixed seed is used if zero.
+	Seed uint64
+}
+
+func (c *Config) elrpc() bool {
+	return c != nil && c.ELRPC
+}
+
+func (c *Config) seed() uint64 {
+	if c == nil || c.Seed == 0 {
+		return 0x9e37_79b9_7f4a_7c15
+	}
+	return c.Seed
+}
+
+// SyntaxError reports a Befunge-93 program that does not fit in the playfield.
+type SyntaxError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("befunge: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// RuntimeError reports an instruction that failed. X and Y are the position of the instruction in Funge-Space.
+type RuntimeError struct {
+	X, Y int64
+	Msg  string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("befunge: (%d, %d): %s", e.X, e.Y, e.Msg)
+}
+
+var ErrInfiniteLoop = errors.New("befunge: the IP loops forever without executing instructions")
+
+type cell struct {
+	pos vector
+	c   int64
+}
+
+// Program is a parsed Befunge program.
+type Program struct {
+	mode  Mode
+	cells []cell
+}
+
+// Parse loads src into Funge-Space. Lines are separated by LF, CR or CRLF, and form feeds are ignored.
+func Parse(src []byte, mode Mode) (*Program, error) {
+	p := &Program{mode: mode}
+	var x, y int64
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch c {
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				i++
+			}
+			fallthrough
+		case '\n':
+			x = 0
+			y++
+			continue
+		case '\f':
+			continue
+		}
+		if c != ' ' {
+			if mode == Befunge93 && (x >= width93 || y >= height93) {
+				return nil, &SyntaxError{
+					Line:   int(y) + 1,
+					Column: int(x) + 1,
+					Msg:    fmt.Sprintf("program exceeds the %dx%d playfield", width93, height93),
+				}
+			}
+			p.cells = append(p.cells, cell{pos: vector{x, y}, c: int64(c)})
+		}
+		x++
+	}
+	return p, nil
+}
+
+type Machine struct {
+	mode Mode
+	conf *Config
+
+	in  *bufio.Reader
+	out *bufio.Writer
+
+	space  *space
+	pos    vector
+	delta  vector
+	offset vector // the storage offset
+	// The stack stack. The last one is the top of the stack stack (TOSS).
+	stacks     [][]int64
+	stringMode bool
+	// The semantics of the instructions A to Z, each of which is a stack of the IDs of the fingerprints.
+	semantics [26][]int64
+	// The encoded arguments of the next ELRPC call made by the ELRP fingerprint.
+	args []byte
+	rng  uint64
+
+	budget   *interp.Budget
+	exitCode int
+}
+
+var (
+	_ interp.Machine   = (*Machine)(nil)
+	_ interp.Metered   = (*Machine)(nil)
+	_ interp.ExitCoder = (*Machine)(nil)
+)
+
+type instruction func(m *Machine) error
+
+func (p *Program) NewMachine(in io.Reader, out io.Writer, conf *Config) *Machine {
+	m := &Machine{
+		mode:   p.mode,
+		conf:   conf,
+		in:     bufio.NewReader(in),
+		out:    bufio.NewWriter(out),
+		space:  newSpace(p.mode == Befunge93),
+		delta:  east,
+		stacks: [][]int64{nil},
+		rng:    conf.seed(),
+	}
+	for _, c := range p.cells {
+		m.space.put(c.pos, c.c)
+	}
+	return m
+}
+
+// NewGuest creates a guest that runs p. The program talks ELRPC through its I/O instructions.
+func NewGuest(p *Program, conf *Config, opts ...interp.Option) *interp.Guest {
+	return interp.NewGuest(func(s runtime.Stream) interp.Machine {
+		return p.NewMachine(s, s, conf)
+	}, opts...)
+}
+
+// SetBudget lets the instructions that take counts, such as `k` and `{`, charge fuel for them.
+func (m *Machine) SetBudget(b *interp.Budget) {
+	m.budget = b
+}
+
+func (m *Machine) charge(n uint64) error {
+	if m.budget == nil {
+		return nil
+	}
+	return m.budget.Charge(n)
+}
+
+// interrupted returns the error of the context once it is done. Instructions that loop for long check it now and then.
+func (m *Machine) interrupted() error {
+	if m.budget == nil {
+		return nil
+	}
+	return m.budget.Err()
+}
+
+// ExitCode returns the exit code given to `q`, or 0 if the program halted by `@`.
+func (m *Machine) ExitCode() int {
+	return m.exitCode
+}
+
+func (m *Machine) Step() (bool, error) {
+	if m.mode == Befunge98 && !m.stringMode {
+		err := m.skipIdle()
+		if err != nil {
+			return false, err
+		}
+	}
+	c := m.space.get(m.pos)
+	if m.stringMode {
+		m.stepString(c)
+		return false, nil
+	}
+	halted, err := m.execute(c)
+	if err != nil {
+		return false, err
+	}
+	if halted {
+		return true, m.out.Flush()
+	}
+	m.move()
+	return false, nil
+}
+
+func (m *Machine) move() {
+	m.pos = m.space.next(m.pos, m.delta)
+}
+
+// skipIdle moves the IP past spaces and `;`-delimited comments, which take no time in Befunge-98.
+func (m *Machine) skipIdle() error {
+	start := m.pos
+	visited := false
+	inComment := false
+	for {
+		c := m.space.get(m.pos)
+		if inComment {
+			inComment = c != ';'
+		} else if c == ';' {
+			inComment = true
+		} else if c != ' ' {
+			return nil
+		}
+		if _, ok := m.space.entry(m.pos, m.delta); !ok && !m.space.inBounds(m.pos) {
+			// The IP never comes back to the program.
+			return ErrInfiniteLoop
+		}
+		m.move()
+		if m.pos == start {
+			// The comment state may differ after the first round.
+			if visited {
+				return ErrInfiniteLoop
+			}
+			visited = true
+		}
+	}
+}
+
+func (m *Machine) stepString(c int64) {
+	if c == '"' {
+		m.stringMode = false
+		m.move()
+		return
+	}
+	m.push(c)
+	m.move()
+	if m.mode == Befunge98 && c == ' ' {
+		// SGML-style spaces: a run of spaces is pushed as a single one.
+		start := m.pos
+		for m.space.get(m.pos) == ' ' {
+			m.move()
+			if m.pos == start {
+				break
+			}
+		}
+	}
+}
+
+func (m *Machine) errorf(format string, args ...any) error {
+	return &RuntimeError{X: m.pos.x, Y: m.pos.y, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (m *Machine) execute(c int64) (halted bool, err error) {
+	switch c {
+	case ' ':
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		m.push(c - '0')
+	case '+':
+		b, a := m.pop(), m.pop()
+		m.push(a + b)
+	case '-':
+		b, a := m.pop(), m.pop()
+		m.push(a - b)
+	case '*':
+		b, a := m.pop(), m.pop()
+		m.push(a * b)
+	case '/':
+		b, a := m.pop(), m.pop()
+		if b == 0 {
+			m.push(0)
+		} else {
+			m.push(a / b)
+		}
+	case '%':
+		b, a := m.pop(), m.pop()
+		if b == 0 {
+			m.push(0)
+		} else {
+			m.push(a % b)
+		}
+	case '!':
+		m.push(boolToCell(m.pop() == 0))
+	case '`':
+		b, a := m.pop(), m.pop()
+		m.push(boolToCell(a > b))
+	case '>':
+		m.delta = east
+	case '<':
+		m.delta = west
+	case '^':
+		m.delta = north
+	case 'v':
+		m.delta = south
+	case '?':
+		m.delta = []vector{east, south, west, north}[m.random()%4]
+	case '_':
+		if m.pop() == 0 {
+			m.delta = east
+		} else {
+			m.delta = west
+		}
+	case '|':
+		if m.pop() == 0 {
+			m.delta = south
+		} else {
+			m.delta = north
+		}
+	case '"':
+		m.stringMode = true
+	case ':':
+		v := m.pop()
+		m.push(v)
+		m.push(v)
+	case '\\':
+		b, a := m.pop(), m.pop()
+		m.push(b)
+		m.push(a)
+	case '$':
+		m.pop()
+	case '.':
+		_, err := m.out.WriteString(strconv.FormatInt(m.pop(), 10) + " ")
+		if err != nil {
+			return false, err
+		}
+	case ',':
+		err := m.out.WriteByte(byte(m.pop()))
+		if err != nil {
+			return false, err
+		}
+	case '~':
+		err := m.readChar()
+		if err != nil {
+			return false, err
+		}
+	case '&':
+		err := m.readNumber()
+		if err != nil {
+			return false, err
+		}
+	case '#':
+		m.move()
+	case 'g':
+		p := m.popVector().add(m.offset)
+		m.push(m.space.get(p))
+	case 'p':
+		p := m.popVector().add(m.offset)
+		m.space.put(p, m.pop())
+	case '@':
+		return true, nil
+	default:
+		if m.mode == Befunge93 {
+			return false, m.errorf("unknown instruction %q", rune(c))
+		}
+		return m.execute98(c)
+	}
+	return false, nil
+}
+
+func (m *Machine) execute98(c int64) (halted bool, err error) {
+	switch c {
+	case 'a', 'b', 'c', 'd', 'e', 'f':
+		m.push(c - 'a' + 10)
+	case '\'':
+		m.move()
+		m.push(m.space.get(m.pos))
+	case 's':
+		m.move()
+		m.space.put(m.pos, m.pop())
+	case '[':
+		m.delta = vector{m.delta.y, -m.delta.x}
+	case ']':
+		m.delta = vector{-m.delta.y, m.delta.x}
+	case 'x':
+		m.delta = m.popVector()
+	case 'j':
+		m.jump(m.pop())
+	case 'k':
+		return m.iterate()
+	case 'n':
+		m.stacks[len(m.stacks)-1] = nil
+	case 'w':
+		b, a := m.pop(), m.pop()
+		if a < b {
+			m.delta = vector{m.delta.y, -m.delta.x}
+		} else if a > b {
+			m.delta = vector{-m.delta.y, m.delta.x}
+		}
+	case 'z':
+	case 'q':
+		m.exitCode = int(m.pop())
+		return true, nil
+	case '{':
+		return false, m.beginBlock()
+	case '}':
+		return false, m.endBlock()
+	case 'u':
+		return false, m.stackUnderStack()
+	case 'y':
+		m.sysInfo(m.pop())
+	case '(':
+		m.loadFingerprint()
+	case ')':
+		m.unloadFingerprint()
+	default:
+		if 'A' <= c && c <= 'Z' {
+			sem := m.semantics[c-'A']
+			if len(sem) > 0 {
+				f, _ := m.findFingerprint(sem[len(sem)-1])
+				return false, f.sems[byte(c)](m)
+			}
+		}
+		// `r`, and the instructions that are not supported (`t`, `i`, `o`, `=`, `h`, `l` and `m`) reflect.
+		m.reflect()
+	}
+	return false, nil
+}
+
+func (m *Machine) reflect() {
+	m.delta = vector{-m.delta.x, -m.delta.y}
+}
+
+func (m *Machine) jump(n int64) {
+	d := m.delta
+	if n < 0 {
+		d = vector{-d.x, -d.y}
+	}
+	m.pos = m.space.advance(m.pos, d, abs64(n))
+}
+
+// iterate executes the next instruction n times at the current position. `0k` skips the next instruction.
+func (m *Machine) iterate() (bool, error) {
+	n, err := m.popCount(positive)
+	if err != nil {
+		return false, err
+	}
+	at, delta := m.pos, m.delta
+	m.move()
+	err = m.skipIdle()
+	if err != nil {
+		return false, err
+	}
+	target := m.pos
+	c := m.space.get(target)
+	m.pos = at
+	if n <= 0 {
+		m.pos = target
+		return false, nil
+	}
+	for i := uint64(n); i > 0; i-- {
+		if i%checkInterval == 0 {
+			err := m.interrupted()
+			if err != nil {
+				return false, err
+			}
+		}
+		halted, err := m.execute(c)
+		if halted || err != nil {
+			return halted, err
+		}
+	}
+	// Skip the iterated instruction unless it moved the IP.
+	if m.pos == at && m.delta == delta {
+		m.pos = target
+	}
+	return false, nil
+}
+
+func (m *Machine) readChar() error {
+	err := m.out.Flush()
+	if err != nil {
+		return err
+	}
+	b, err := m.in.ReadByte()
+	if errors.Is(err, io.EOF) {
+		m.inputEOF()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.push(int64(b))
+	return nil
+}
+
+// readNumber reads a decimal number, ignoring the preceding non-digit characters.
+func (m *Machine) readNumber() error {
+	err := m.out.Flush()
+	if err != nil {
+		return err
+	}
+	var n int64
+	digits := 0
+	for {
+		b, err := m.in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if '0' <= b && b <= '9' {
+			n = n*10 + int64(b-'0')
+			digits++
+			continue
+		}
+		if digits > 0 {
+			err := m.in.UnreadByte()
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if digits == 0 {
+		m.inputEOF()
+		return nil
+	}
+	m.push(n)
+	return nil
+}
+
+func (m *Machine) inputEOF() {
+	if m.mode == Befunge93 {
+		m.push(-1)
+	} else {
+		m.reflect()
+	}
+}
+
+func (m *Machine) random() uint64 {
+	// xorshift64*
+	m.rng ^= m.rng >> 12
+	m.rng ^= m.rng << 25
+	m.rng ^= m.rng >> 27
+	return m.rng * 0x2545_f491_4f6c_dd1d
+}
+
+func (m *Machine) push(v int64) {
+	i := len(m.stacks) - 1
+	m.stacks[i] = append(m.stacks[i], v)
+}
+
+// popCount pops the count of an instruction after charging cost(count) units of fuel for it.
+// The stack is left intact if the fuel runs out.
+func (m *Machine) popCount(cost func(n int64) uint64) (int64, error) {
+	var n int64
+	if toss := m.stacks[len(m.stacks)-1]; len(toss) > 0 {
+		n = toss[len(toss)-1]
+	}
+	err := m.charge(cost(n))
+	if err != nil {
+		return 0, err
+	}
+	return m.pop(), nil
+}
+
+// pop pops a value from the TOSS. An empty stack acts as if it had infinitely many zeros.
+func (m *Machine) pop() int64 {
+	return popFrom(&m.stacks[len(m.stacks)-1])
+}
+
+func popFrom(s *[]int64) int64 {
+	n := len(*s)
+	if n == 0 {
+		return 0
+	}
+	v := (*s)[n-1]
+	*s = (*s)[:n-1]
+	return v
+}
+
+func (m *Machine) pushVector(v vector) {
+	m.push(v.x)
+	m.push(v.y)
+}
+
+func (m *Machine) popVector() vector {
+	y := m.pop()
+	x := m.pop()
+	return vector{x, y}
+}
+
+// push0gnirts pushes s so that popping yields s in order followed by 0.
+func (m *Machine) push0gnirts(s []byte) {
+	m.push(0)
+	for i := len(s) - 1; i >= 0; i-- {
+		m.push(int64(s[i]))
+	}
+}
+
+func (m *Machine) pop0gnirts() []byte {
+	var s []byte
+	for {
+		c := m.pop()
+		if c == 0 {
+			return s
+		}
+		s = append(s, byte(c))
+	}
+}
+
+// The number of iterations between checks of the context in the loops of a single instruction.
+const checkInterval = 1024
+
+// pushZeros pushes n zeros onto s. The fuel must already be charged.
+func (m *Machine) pushZeros(s *[]int64, n uint64) error {
+	var zeros [checkInterval]int64
+	for n > 0 {
+		err := m.interrupted()
+		if err != nil {
+			return err
+		}
+		k := minUint64(n, checkInterval)
+		*s = append(*s, zeros[:k]...)
+		n -= k
+	}
+	return nil
+}
+
+// transfer moves the top n elements of src onto dst, preserving their order.
+// The missing elements of src are zeros, which are put below the others. The fuel must already be charged.
+func (m *Machine) transfer(dst, src *[]int64, n uint64) error {
+	k := minUint64(n, uint64(len(*src)))
+	err := m.pushZeros(dst, n-k)
+	if err != nil {
+		return err
+	}
+	i := uint64(len(*src)) - k
+	*dst = append(*dst, (*src)[i:]...)
+	*src = (*src)[:i]
+	return nil
+}
+
+// dropFrom pops n elements from s. Popping an empty stack does nothing.
+func dropFrom(s *[]int64, n uint64) {
+	*s = (*s)[:uint64(len(*s))-minUint64(n, uint64(len(*s)))]
+}
+
+func (m *Machine) beginBlock() error {
+	n, err := m.popCount(abs64)
+	if err != nil {
+		return err
+	}
+	soss := &m.stacks[len(m.stacks)-1]
+	var toss []int64
+	if n > 0 {
+		err = m.transfer(&toss, soss, uint64(n))
+	} else {
+		err = m.pushZeros(soss, abs64(n))
+	}
+	if err != nil {
+		return err
+	}
+	*soss = append(*soss, m.offset.x, m.offset.y)
+	m.offset = m.pos.add(m.delta)
+	m.stacks = append(m.stacks, toss)
+	return nil
+}
+
+func (m *Machine) endBlock() error {
+	if len(m.stacks) == 1 {
+		m.reflect()
+		return nil
+	}
+	// Popping the SOSS by a negative count takes no time.
+	n, err := m.popCount(positive)
+	if err != nil {
+		return err
+	}
+	toss := &m.stacks[len(m.stacks)-1]
+	soss := &m.stacks[len(m.stacks)-2]
+	y := popFrom(soss)
+	x := popFrom(soss)
+	m.offset = vector{x, y}
+	if n > 0 {
+		err := m.transfer(soss, toss, uint64(n))
+		if err != nil {
+			return err
+		}
+	} else {
+		dropFrom(soss, abs64(n))
+	}
+	m.stacks = m.stacks[:len(m.stacks)-1]
+	return nil
+}
+
+func (m *Machine) stackUnderStack() error {
+	if len(m.stacks) == 1 {
+		m.pop()
+		m.reflect()
+		return nil
+	}
+	n, err := m.popCount(abs64)
+	if err != nil {
+		return err
+	}
+	toss := &m.stacks[len(m.stacks)-1]
+	soss := &m.stacks[len(m.stacks)-2]
+	src, dst := soss, toss
+	if n < 0 {
+		src, dst = toss, soss
+	}
+	count := abs64(n)
+	// The elements are moved one by one, so their order is reversed.
+	k := minUint64(count, uint64(len(*src)))
+	for i := uint64(0); i < k; i++ {
+		*dst = append(*dst, popFrom(src))
+	}
+	return m.pushZeros(dst, count-k)
+}
+
+// The handprint of this interpreter, reported by `y`.
+const handprint = 'E'<<24 | 'L'<<16 | 'S'<<8 | 'I'
+
+// sysInfo implements `y`. It pushes all the information if n <= 0, or only the nth cell otherwise.
+func (m *Machine) sysInfo(n int64) {
+	before := len(m.stacks[len(m.stacks)-1])
+	sizes := make([]int64, len(m.stacks))
+	for i, s := range m.stacks {
+		sizes[len(m.stacks)-1-i] = int64(len(s))
+	}
+	now := time.Now().UTC()
+
+	// Pushed in reverse order so that the first cell is on top.
+	m.push(0) // no environment variables
+	m.push(0) // no command line arguments
+	m.push(0)
+	for i := len(sizes) - 1; i >= 0; i-- {
+		m.push(sizes[i])
+	}
+	m.push(int64(len(sizes)))
+	m.push(int64(now.Hour()*256*256 + now.Minute()*256 + now.Second()))
+	m.push(int64((now.Year()-1900)*256*256 + int(now.Month())*256 + now.Day()))
+	m.pushVector(m.space.max.sub(m.space.min))
+	m.pushVector(m.space.min)
+	m.pushVector(m.offset)
+	m.pushVector(m.delta)
+	m.pushVector(m.pos)
+	m.push(0)   // team number
+	m.push(0)   // IP ID
+	m.push(2)   // number of dimensions
+	m.push('/') // path separator
+	m.push(0)   // `=` is not available
+	m.push(1)   // version
+	m.push(handprint)
+	m.push(8) // bytes per cell
+	m.push(0) // flags: none of `t`, `i`, `o` and `=` is implemented, and I/O is buffered
+
+	if n <= 0 {
+		return
+	}
+	toss := &m.stacks[len(m.stacks)-1]
+	var v int64
+	if i := len(*toss) - int(n); i >= 0 {
+		v = (*toss)[i]
+	}
+	*toss = (*toss)[:before]
+	m.push(v)
+}
+
+type fingerprint struct {
+	id   int64
+	sems map[byte]instruction
+}
+
+func (m *Machine) fingerprints() []fingerprint {
+	if m.conf.elrpc() {
+		return []fingerprint{elrpFingerprint}
+	}
+	return nil
+}
+
+func (m *Machine) popFingerprintID() int64 {
+	n := m.pop()
+	if n <= 0 {
+		return 0
+	}
+	k := minUint64(uint64(n), uint64(len(m.stacks[len(m.stacks)-1])))
+	var id int64
+	for i := uint64(0); i < k; i++ {
+		id = id*256 + m.pop()
+	}
+	// The rest pops zeros, which shift id out after 8 of them.
+	if rest := uint64(n) - k; rest >= 8 {
+		id = 0
+	} else {
+		id <<= 8 * rest
+	}
+	return id
+}
+
+func (m *Machine) findFingerprint(id int64) (fingerprint, bool) {
+	for _, f := range m.fingerprints() {
+		if f.id == id {
+			return f, true
+		}
+	}
+	return fingerprint{}, false
+}
+
+func (m *Machine) loadFingerprint() {
+	id := m.popFingerprintID()
+	f, ok := m.findFingerprint(id)
+	if !ok {
+		m.reflect()
+		return
+	}
+	for c := range f.sems {
+		m.semantics[c-'A'] = append(m.semantics[c-'A'], id)
+	}
+	m.push(id)
+	m.push(1)
+}
+
+func (m *Machine) unloadFingerprint() {
+	id := m.popFingerprintID()
+	f, ok := m.findFingerprint(id)
+	if !ok {
+		m.reflect()
+		return
+	}
+	for c := range f.sems {
+		sem := &m.semantics[c-'A']
+		if len(*sem) > 0 {
+			*sem = (*sem)[:len(*sem)-1]
+		}
+	}
+}
+
+// positive returns n if it is positive, or 0 otherwise.
+func positive(n int64) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return uint64(n)
+}
+
+// abs64 returns |n|, which does not overflow even if n is math.MinInt64.
+func abs64(n int64) uint64 {
+	if n < 0 {
+		return -uint64(n)
+	}
+	return uint64(n)
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func boolToCell(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,298 @@
+package befunge_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+	"github.com/genkami/elsi/elsi/interp/befunge"
+	"golang.org/x/exp/slog"
+)
+
+func run(t *testing.T, src string, mode befunge.Mode, input string) (string, *befunge.Machine, error) {
+	t.Helper()
+	prog, err := befunge.Parse([]byte(src), mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	m := prog.NewMachine(strings.NewReader(input), out, nil)
+	_, err = interp.Run(context.Background(), m)
+	return out.String(), m, err
+}
+
+func TestMachine_befunge93(t *testing.T) {
+	cases := []struct {
+		name  string
+		src   string
+		input string
+		want  string
+	}{
+		{"hello", `"!dlroW ,olleH">:#,_@`, "", "Hello, World!"},
+		{"number I/O", "&&+.@", "12 30", "42 "},
+		{"char I/O", "~~\\,,@", "ab", "ab"},
+		{"EOF", "~.@", "", "-1 "},
+		{"division by zero", "50/.50%.@", "", "0 0 "},
+		{"wrap around", "<@,,,\"abc\"", "", "abc"},
+		{"multiline", "v\n>\"k\",@", "", "k"},
+		{"get and put", "55+1p@\n", "", ""},
+		{"self-modifying", "\".\"70p1 @", "", "1 "},
+	}
+	for _, tt := range cases {
+		got, _, err := run(t, tt.src, befunge.Befunge93, tt.input)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: want %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMachine_befunge98(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"hex digits", "af+.@", "25 "},
+		{"fetch character", "'a,@", "a"},
+		{"comment", "1;2.;.@", "1 "},
+		{"iterate", "\"olleh\"5k,a,@", "hello\n"},
+		{"skip by iterate", "10k..@", "1 "},
+		{"Lahey-space wrapping", "<@.+ff", "30 "},
+		{"reflect unknown", "1#.Z@", "1 "},
+		{"jump", "2j12.@", "0 "},
+		{"turn", "]@\n.\n@", "0 "},
+		{"absolute delta", "01x\n  .\n  @", "0 "},
+		{"stack stack", "122{..0}@", "2 1 "},
+		{"SGML spaces", "\"a  b\"...@", "98 32 97 "},
+		{"sysinfo", "1y.@", "0 "},
+	}
+	for _, tt := range cases {
+		got, _, err := run(t, tt.src, befunge.Befunge98, "")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: want %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMachine_exitCode(t *testing.T) {
+	_, m, err := run(t, "7q", befunge.Befunge98, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ExitCode() != 7 {
+		t.Errorf("want 7 but got %d", m.ExitCode())
+	}
+}
+
+func TestMachine_infiniteLoop(t *testing.T) {
+	// The second one starts outside the program and moves away forever.
+	for _, src := range []string{";@;", "\n@"} {
+		_, _, err := run(t, src, befunge.Befunge98, "")
+		if !errors.Is(err, befunge.ErrInfiniteLoop) {
+			t.Errorf("%q: want ErrInfiniteLoop but got %v", src, err)
+		}
+	}
+}
+
+func TestMachine_unknownInstruction(t *testing.T) {
+	_, _, err := run(t, "v\n>X", befunge.Befunge93, "")
+	var runtimeErr *befunge.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("want RuntimeError but got %v", err)
+	}
+	if runtimeErr.X != 1 || runtimeErr.Y != 1 {
+		t.Errorf("want (1, 1) but got (%d, %d)", runtimeErr.X, runtimeErr.Y)
+	}
+}
+
+func TestParse_syntaxError(t *testing.T) {
+	cases := []struct {
+		src          string
+		line, column int
+	}{
+		{strings.Repeat(" ", 80) + "@", 1, 81},
+		{strings.Repeat("\n", 25) + "@", 26, 1},
+		{strings.Repeat("\r\n", 2) + strings.Repeat(" ", 85) + "@", 3, 86},
+	}
+	for _, tt := range cases {
+		_, err := befunge.Parse([]byte(tt.src), befunge.Befunge93)
+		var syntaxErr *befunge.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("want SyntaxError but got %v", err)
+			continue
+		}
+		if syntaxErr.Line != tt.line || syntaxErr.Column != tt.column {
+			t.Errorf("want %d:%d but got %d:%d", tt.line, tt.column, syntaxErr.Line, syntaxErr.Column)
+		}
+	}
+
+	// Funge-Space is unbounded in Befunge-98.
+	_, err := befunge.Parse([]byte(strings.Repeat(" ", 80)+"@"), befunge.Befunge98)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGuest_fingerprint(t *testing.T) {
+	const modID = 'd'
+	// Loads ELRP, sends "hi" to method 1, and then sends the result to method 2.
+	// Finally, calls method 3 and exits with the error code.
+	src := `"PRLE"4($$0"ih"S'd1C$S'd2C$'d3C$q`
+	prog, err := befunge.Parse([]byte(src), befunge.Befunge98)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := befunge.NewGuest(prog, &befunge.Config{ELRPC: true})
+	rt := runtime.NewRuntime(logger, guest)
+	rt.Use(modID, 1, apibuilder.HostHandler1[*message.String, *message.String](func(s *message.String) (*message.String, error) {
+		return &message.String{Value: strings.ToUpper(s.Value)}, nil
+	}))
+	var got string
+	rt.Use(modID, 2, apibuilder.HostHandler1[*message.String, *message.String](func(s *message.String) (*message.String, error) {
+		got = s.Value
+		return s, nil
+	}))
+	rt.Use(modID, 3, apibuilder.HostHandler0[*message.String](func() (*message.String, error) {
+		return nil, &message.Error{ModuleID: modID, Code: 42, Message: "failed"}
+	}))
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 42 {
+		t.Errorf("want exit code 42 but got %v", err)
+	}
+	if got != "HI" {
+		t.Errorf("want HI but got %q", got)
+	}
+}
+
+func TestGuest_hugeCount(t *testing.T) {
+	// ff*:*:*:* pushes 15^16, which is about 6.6e18.
+	cases := []struct {
+		name string
+		src  string
+		// Whether the instruction allocates memory according to the count, which only the fuel limits.
+		allocates bool
+	}{
+		{"jump", "ff*:*:*:*j@", false},
+		{"iterate", "ff*:*:*:*k$@", false},
+		{"begin block", "ff*:*:*:*{@", true},
+		{"begin block with a negative count", "0ff*:*:*:*-{@", true},
+		{"end block", "0{ff*:*:*:*}@", true},
+		{"end block with a negative count", "0{0ff*:*:*:*-}@", false},
+		{"stack under stack", "0{ff*:*:*:*u@", true},
+		{"stack under stack with a negative count", "0{0ff*:*:*:*-u@", true},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	for _, tt := range cases {
+		prog, err := befunge.Parse([]byte(tt.src), befunge.Befunge98)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := [][]interp.Option{{interp.WithFuel(100)}}
+		if !tt.allocates {
+			opts = append(opts, []interp.Option{interp.WithTimeout(200 * time.Millisecond)})
+		}
+		for _, opt := range opts {
+			start := time.Now()
+			rt := runtime.NewRuntime(logger, befunge.NewGuest(prog, nil, opt...))
+			err := rt.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rt.Wait()
+			if err != nil && !errors.Is(err, interp.ErrFuelExhausted) && !errors.Is(err, interp.ErrDeadlineExceeded) {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("%s: took %s", tt.name, elapsed)
+			}
+		}
+	}
+}
+
+func TestMachine_fingerprintDisabled(t *testing.T) {
+	// `(` reflects unless the fingerprint is enabled, so `.` is not executed.
+	got, _, err := run(t, `"PRLE"4(.@`, befunge.Befunge98, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Errorf("want nothing but got %q", got)
+	}
+}
+
+func TestMachine_MarshalState(t *testing.T) {
+	cases := []struct {
+		name  string
+		src   string
+		mode  befunge.Mode
+		input string
+		want  string
+	}{
+		{"input", "&~,&+.@", befunge.Befunge93, "12x30", "x42 "},
+		{"self-modifying", "\".\"70p1 @", befunge.Befunge93, "", "1 "},
+		{"stack stack", "\"olleh\"122{..0}5k,@", befunge.Befunge98, "", "2 1 hello"},
+		{"far away", "f9999***0p9999***0g,@", befunge.Befunge98, "", "\x0f"},
+	}
+	for _, tt := range cases {
+		prog, err := befunge.Parse([]byte(tt.src), tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Save and restore the machine after every number of steps.
+		for n := uint64(1); ; n++ {
+			in := strings.NewReader(tt.input)
+			out := &bytes.Buffer{}
+			m := prog.NewMachine(in, out, nil)
+			_, err := interp.RunWithFuel(context.Background(), m, n)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, interp.ErrFuelExhausted) {
+				t.Fatalf("%s: %d: %v", tt.name, n, err)
+			}
+			err = m.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, pending, err := m.MarshalState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := prog.RestoreMachine(io.MultiReader(bytes.NewReader(pending), in), out, nil, state)
+			if err != nil {
+				t.Fatalf("%s: %d: %v", tt.name, n, err)
+			}
+			_, err = interp.Run(context.Background(), restored)
+			if err != nil {
+				t.Fatalf("%s: %d: %v", tt.name, n, err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("%s: %d: want %q but got %q", tt.name, n, tt.want, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package befunge
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+var _ interp.Checkpointer = (*Machine)(nil)
+
+var errStateMismatch = errors.New("befunge: the snapshot does not match the program")
+
+// machineState is the state of a Machine. Vectors are encoded as [x, y].
+type machineState struct {
+	Mode Mode `json:"mode"`
+	// Funge-Space in [x, y, value].
+	Cells      [][3]int64  `json:"cells"`
+	Min        [2]int64    `json:"min"`
+	Max        [2]int64    `json:"max"`
+	Empty      bool        `json:"empty"`
+	Pos        [2]int64    `json:"pos"`
+	Delta      [2]int64    `json:"delta"`
+	Offset     [2]int64    `json:"offset"`
+	Stacks     [][]int64   `json:"stacks"`
+	StringMode bool        `json:"string_mode"`
+	Semantics  [26][]int64 `json:"semantics"`
+	Args       []byte      `json:"args,omitempty"`
+	RNG        uint64      `json:"rng"`
+}
+
+func fromVector(v vector) [2]int64 {
+	return [2]int64{v.x, v.y}
+}
+
+func toVector(v [2]int64) vector {
+	return vector{v[0], v[1]}
+}
+
+func (m *Machine) Flush() error {
+	return m.out.Flush()
+}
+
+func (m *Machine) MarshalState() ([]byte, []byte, error) {
+	st := &machineState{
+		Mode:       m.mode,
+		Min:        fromVector(m.space.min),
+		Max:        fromVector(m.space.max),
+		Empty:      m.space.empty,
+		Pos:        fromVector(m.pos),
+		Delta:      fromVector(m.delta),
+		Offset:     fromVector(m.offset),
+		Stacks:     m.stacks,
+		StringMode: m.stringMode,
+		Semantics:  m.semantics,
+		Args:       m.args,
+		RNG:        m.rng,
+	}
+	for p, c := range m.space.cells {
+		st.Cells = append(st.Cells, [3]int64{p.x, p.y, c})
+	}
+	state, err := json.Marshal(st)
+	if err != nil {
+		return nil, nil, err
+	}
+	input, err := m.in.Peek(m.in.Buffered())
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, append([]byte{}, input...), nil
+}
+
+// RestoreMachine creates a Machine from a state returned by MarshalState.
+// Since Funge-Space is saved as a whole, p only has to be in the same mode as the saved one.
+func (p *Program) RestoreMachine(in io.Reader, out io.Writer, conf *Config, state []byte) (*Machine, error) {
+	var st machineState
+	err := json.Unmarshal(state, &st)
+	if err != nil {
+		return nil, err
+	}
+	if st.Mode != p.mode || len(st.Stacks) == 0 {
+		return nil, errStateMismatch
+	}
+	m := p.NewMachine(in, out, conf)
+	for _, sem := range st.Semantics {
+		for _, id := range sem {
+			if _, ok := m.findFingerprint(id); !ok {
+				return nil, errStateMismatch
+			}
+		}
+	}
+	m.space = newSpace(p.mode == Befunge93)
+	for _, c := range st.Cells {
+		m.space.put(vector{c[0], c[1]}, c[2])
+	}
+	m.space.min = toVector(st.Min)
+	m.space.max = toVector(st.Max)
+	m.space.empty = st.Empty
+	m.pos = toVector(st.Pos)
+	m.delta = toVector(st.Delta)
+	m.offset = toVector(st.Offset)
+	m.stacks = st.Stacks
+	m.stringMode = st.StringMode
+	m.semantics = st.Semantics
+	m.args = st.Args
+	m.rng = st.RNG
+	return m, nil
+}
+
+// NewGuestFromSnapshot creates a guest that resumes p from snap.
+func NewGuestFromSnapshot(p *Program, conf *Config, snap *interp.Snapshot, opts ...interp.Option) *interp.Guest {
+	return interp.NewGuestFromSnapshot(snap, func(s runtime.Stream, state []byte) (interp.Machine, error) {
+		return p.RestoreMachine(s, s, conf, state)
+	}, opts...)
+}
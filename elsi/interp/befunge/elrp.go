@@ -0,0 +1,217 @@
+package befunge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/genkami/elsi/elrpc/message"
+)
+
+// ELRPFingerprint is the ID of the ELRP fingerprint, which is loaded by `"PRLE"4(`.
+//
+// The fingerprint provides the following instructions:
+//
+//	A (v tag -- )   appends v as an argument of the type specified by tag (message.TagUint8 to message.TagInt64)
+//	S (0gnirts -- ) appends a string argument
+//	R ( -- )        discards the arguments
+//	C (mod method -- results... 1) or (mod method -- code 0)
+//	                calls the method with the arguments, and discards them
+//
+// On success, C pushes the values in the response in order, followed by 1:
+// numbers as they are, strings and bytes as 0gnirts, and arrays and variants as their lengths and tags
+// followed by their elements.
+// On failure, it pushes the error code, followed by 0.
+const ELRPFingerprint = 'E'<<24 | 'L'<<16 | 'R'<<8 | 'P'
+
+var elrpFingerprint = fingerprint{
+	id: ELRPFingerprint,
+	sems: map[byte]instruction{
+		'A': (*Machine).elrpArg,
+		'S': (*Machine).elrpString,
+		'R': (*Machine).elrpReset,
+		'C': (*Machine).elrpCall,
+	},
+}
+
+func (m *Machine) elrpArg() error {
+	tag := m.pop()
+	v := m.pop()
+	enc := message.NewEncoder()
+	var err error
+	switch tag {
+	case message.TagUint8:
+		err = enc.EncodeUint8(uint8(v))
+	case message.TagUint16:
+		err = enc.EncodeUint16(uint16(v))
+	case message.TagUint32:
+		err = enc.EncodeUint32(uint32(v))
+	case message.TagUint64:
+		err = enc.EncodeUint64(uint64(v))
+	case message.TagInt8:
+		err = enc.EncodeInt8(int8(v))
+	case message.TagInt16:
+		err = enc.EncodeInt16(int16(v))
+	case message.TagInt32:
+		err = enc.EncodeInt32(int32(v))
+	case message.TagInt64:
+		err = enc.EncodeInt64(v)
+	default:
+		m.reflect()
+	}
+	m.args = append(m.args, enc.Buffer()...)
+	return err
+}
+
+func (m *Machine) elrpString() error {
+	enc := message.NewEncoder()
+	err := enc.EncodeBytes(m.pop0gnirts())
+	m.args = append(m.args, enc.Buffer()...)
+	return err
+}
+
+func (m *Machine) elrpReset() error {
+	m.args = nil
+	return nil
+}
+
+func (m *Machine) elrpCall() error {
+	method := m.pop()
+	mod := m.pop()
+	enc := message.NewEncoder()
+	err := enc.EncodeUint32(uint32(mod))
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeUint32(uint32(method))
+	if err != nil {
+		return err
+	}
+	body := append(enc.Buffer(), m.args...)
+	m.args = nil
+
+	frame, err := message.AppendLength(nil, len(body))
+	if err != nil {
+		return err
+	}
+	_, err = m.out.Write(append(frame, body...))
+	if err != nil {
+		return err
+	}
+	err = m.out.Flush()
+	if err != nil {
+		return err
+	}
+
+	var lenBuf [message.LengthSize]byte
+	_, err = io.ReadFull(m.in, lenBuf[:])
+	if err != nil {
+		return err
+	}
+	length, err := message.DecodeLength(lenBuf[:])
+	if err != nil {
+		return err
+	}
+	resp := make([]byte, length)
+	_, err = io.ReadFull(m.in, resp)
+	if err != nil {
+		return err
+	}
+
+	dec := message.NewDecoder(resp)
+	tag, err := dec.DecodeVariantTag()
+	if err != nil {
+		return err
+	}
+	if tag != 0 {
+		var e message.Error
+		err := e.UnmarshalELRPC(dec)
+		if err != nil {
+			return err
+		}
+		m.push(int64(e.Code))
+		m.push(0)
+		return nil
+	}
+	err = m.pushValues(dec)
+	if err != nil {
+		return err
+	}
+	m.push(1)
+	return nil
+}
+
+// pushValues pushes all the values remaining in dec.
+func (m *Machine) pushValues(dec *message.Decoder) error {
+	for {
+		tag, err := dec.PeekTag()
+		if errors.Is(err, message.ErrInsufficientBuf) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = m.pushValue(tag, dec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Machine) pushValue(tag byte, dec *message.Decoder) error {
+	switch tag {
+	case message.TagUint8:
+		v, err := dec.DecodeUint8()
+		m.push(int64(v))
+		return err
+	case message.TagUint16:
+		v, err := dec.DecodeUint16()
+		m.push(int64(v))
+		return err
+	case message.TagUint32:
+		v, err := dec.DecodeUint32()
+		m.push(int64(v))
+		return err
+	case message.TagUint64:
+		v, err := dec.DecodeUint64()
+		m.push(int64(v))
+		return err
+	case message.TagInt8:
+		v, err := dec.DecodeInt8()
+		m.push(int64(v))
+		return err
+	case message.TagInt16:
+		v, err := dec.DecodeInt16()
+		m.push(int64(v))
+		return err
+	case message.TagInt32:
+		v, err := dec.DecodeInt32()
+		m.push(int64(v))
+		return err
+	case message.TagInt64:
+		v, err := dec.DecodeInt64()
+		m.push(v)
+		return err
+	case message.TagBytes:
+		v, err := dec.DecodeBytes()
+		m.push0gnirts(v)
+		return err
+	case message.TagArray:
+		v, err := dec.DecodeArrayLen()
+		m.push(int64(v))
+		return err
+	case message.TagVariant:
+		v, err := dec.DecodeVariantTag()
+		m.push(int64(v))
+		return err
+	case message.TagAny:
+		v, err := dec.DecodeAny()
+		if err != nil {
+			return err
+		}
+		m.push0gnirts(v.Raw)
+		return nil
+	default:
+		return fmt.Errorf("befunge: unknown type tag %#x in the response", tag)
+	}
+}
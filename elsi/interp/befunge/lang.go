@@ -0,0 +1,34 @@
+package befunge
+
+import (
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/lang"
+)
+
+func init() {
+	lang.Register(&lang.Language{
+		Name:         "befunge93",
+		Extensions:   []string{".bf93", ".befunge"},
+		Interpreters: []string{"befunge"},
+		// An empty line would move the program away from the origin.
+		RemoveHeader: true,
+		New:          newGuest(Befunge93),
+	})
+	lang.Register(&lang.Language{
+		Name:         "befunge98",
+		Extensions:   []string{".b98"},
+		Interpreters: []string{"b98"},
+		RemoveHeader: true,
+		New:          newGuest(Befunge98),
+	})
+}
+
+func newGuest(mode Mode) lang.Factory {
+	return func(_ string, src []byte, _ []string, _ []runtime.ProcessOption) (runtime.Guest, error) {
+		prog, err := Parse(src, mode)
+		if err != nil {
+			return nil, err
+		}
+		return NewGuest(prog, &Config{ELRPC: true}), nil
+	}
+}
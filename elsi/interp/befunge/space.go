@@ -0,0 +1,227 @@
+package befunge
+
+import "math"
+
+// The size of the Befunge-93 playfield.
+const (
+	width93  = 80
+	height93 = 25
+)
+
+type vector struct {
+	x, y int64
+}
+
+func (v vector) add(w vector) vector {
+	return vector{v.x + w.x, v.y + w.y}
+}
+
+func (v vector) sub(w vector) vector {
+	return vector{v.x - w.x, v.y - w.y}
+}
+
+func (v vector) mul(n int64) vector {
+	return vector{v.x * n, v.y * n}
+}
+
+var (
+	east  = vector{1, 0}
+	west  = vector{-1, 0}
+	north = vector{0, -1}
+	south = vector{0, 1}
+)
+
+// space is Funge-Space. In Befunge-93 it is a fixed 80x25 torus.
+// In Befunge-98 it is unbounded, and the IP wraps around the bounding box of the non-space cells.
+type space struct {
+	cells    map[vector]int64
+	min, max vector // the bounding box of the cells ever written
+	empty    bool
+	torus    bool
+}
+
+func newSpace(torus bool) *space {
+	return &space{
+		cells: make(map[vector]int64),
+		empty: true,
+		torus: torus,
+	}
+}
+
+func (s *space) get(p vector) int64 {
+	if s.torus && !inTorus(p) {
+		return 0
+	}
+	c, ok := s.cells[p]
+	if !ok {
+		return ' '
+	}
+	return c
+}
+
+func (s *space) put(p vector, c int64) {
+	if s.torus && !inTorus(p) {
+		return
+	}
+	if c == ' ' {
+		delete(s.cells, p)
+		return
+	}
+	s.cells[p] = c
+	if s.empty {
+		s.min, s.max = p, p
+		s.empty = false
+		return
+	}
+	s.min = vector{min64(s.min.x, p.x), min64(s.min.y, p.y)}
+	s.max = vector{max64(s.max.x, p.x), max64(s.max.y, p.y)}
+}
+
+func (s *space) inBounds(p vector) bool {
+	if s.torus {
+		return inTorus(p)
+	}
+	if s.empty {
+		return p == vector{}
+	}
+	return s.min.x <= p.x && p.x <= s.max.x && s.min.y <= p.y && p.y <= s.max.y
+}
+
+// next returns the cell that an IP at p with delta d moves to.
+func (s *space) next(p, d vector) vector {
+	n := p.add(d)
+	if s.torus {
+		return vector{mod(n.x, width93), mod(n.y, height93)}
+	}
+	if s.inBounds(n) {
+		return n
+	}
+	// Lahey-space wrapping: the IP reappears at the farthest cell behind it that is within the bounding box.
+	lo, ok := s.entry(p, d)
+	if !ok {
+		return n
+	}
+	return p.add(d.mul(lo))
+}
+
+// advance returns the cell that an IP at p with delta d reaches after moving n times.
+// It takes constant time however large n is, since the IP eventually cycles through the same cells.
+func (s *space) advance(p, d vector, n uint64) vector {
+	if n == 0 || d == (vector{}) {
+		return p
+	}
+	if s.torus {
+		n %= width93 * height93
+		return vector{
+			mod(p.x+int64(n)*mod(d.x, width93), width93),
+			mod(p.y+int64(n)*mod(d.y, height93), height93),
+		}
+	}
+	lo, ok := s.entry(p, d)
+	if !ok {
+		// The IP never enters the bounding box.
+		return p.add(d.mul(int64(n)))
+	}
+	hi := s.exit(p, d)
+	if lo > hi {
+		// The IP is stuck at the first cell it wraps around to.
+		return p.add(d.mul(lo))
+	}
+	// The IP moves along p+t*d, wrapping around from hi to lo.
+	t := lo
+	if lo <= 1 && 1 <= hi {
+		t = 1
+	}
+	period := uint64(hi-lo) + 1
+	if period == 0 {
+		// The line spans the whole int64 range.
+		return p.add(d.mul(t + int64(n-1)))
+	}
+	off := uint64(t - lo)
+	rest := (n - 1) % period
+	if rest >= period-off {
+		off = rest - (period - off)
+	} else {
+		off += rest
+	}
+	return p.add(d.mul(lo + int64(off)))
+}
+
+// entry returns the least t such that p+t*d is within the bounding box.
+func (s *space) entry(p, d vector) (int64, bool) {
+	lox, okx := enterRange(p.x, d.x, s.min.x, s.max.x)
+	loy, oky := enterRange(p.y, d.y, s.min.y, s.max.y)
+	if !okx || !oky {
+		return 0, false
+	}
+	return max64(lox, loy), true
+}
+
+// enterRange returns the least t such that lo <= p+t*d <= hi on a single axis.
+// It returns math.MinInt64 if any t satisfies the condition.
+func enterRange(p, d, lo, hi int64) (int64, bool) {
+	switch {
+	case d == 0:
+		return math.MinInt64, lo <= p && p <= hi
+	case d > 0:
+		return ceilDiv(lo-p, d), true
+	default:
+		return ceilDiv(hi-p, d), true
+	}
+}
+
+// exit returns the greatest t such that p+t*d is within the bounding box.
+func (s *space) exit(p, d vector) int64 {
+	return min64(exitRange(p.x, d.x, s.min.x, s.max.x), exitRange(p.y, d.y, s.min.y, s.max.y))
+}
+
+// exitRange returns the greatest t such that lo <= p+t*d <= hi on a single axis, assuming that such t exists.
+// It returns math.MaxInt64 if d is zero.
+func exitRange(p, d, lo, hi int64) int64 {
+	switch {
+	case d == 0:
+		return math.MaxInt64
+	case d > 0:
+		return floorDiv(hi-p, d)
+	default:
+		return floorDiv(lo-p, d)
+	}
+}
+
+func inTorus(p vector) bool {
+	return 0 <= p.x && p.x < width93 && 0 <= p.y && p.y < height93
+}
+
+func mod(a, n int64) int64 {
+	r := a % n
+	if r < 0 {
+		r += n
+	}
+	return r
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func ceilDiv(a, b int64) int64 {
+	return -floorDiv(-a, b)
+}
@@ -0,0 +1,28 @@
+package befunge
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSpace_advance(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		s := newSpace(i%4 == 0)
+		for j := rng.Intn(4); j > 0; j-- {
+			s.put(vector{rng.Int63n(20) - 5, rng.Int63n(20) - 5}, 'x')
+		}
+		p := vector{rng.Int63n(30) - 10, rng.Int63n(30) - 10}
+		if s.torus {
+			p = vector{mod(p.x, width93), mod(p.y, height93)}
+		}
+		d := vector{rng.Int63n(7) - 3, rng.Int63n(7) - 3}
+		q := p
+		for n := uint64(0); n < 2*width93*height93; n++ {
+			if got := s.advance(p, d, n); got != q {
+				t.Fatalf("%+v: advance(%v, %v, %d): want %v but got %v", s, p, d, n, q, got)
+			}
+			q = s.next(q, d)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package brainfuck implements a Brainfuck interpreter guest.
+//
+// `.` writes the lower 8 bits of the current cell to the ELRPC stream, and `,` reads a byte from it.
+package brainfuck
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+const DefaultTapeSize = 30000
+
+type EOFBehavior int
+
+const (
+	// `,` leaves the current cell unchanged at EOF.
+	EOFUnchanged EOFBehavior = iota
+	// `,` sets the current cell to 0 at EOF.
+	EOFZero
+	// `,` sets the current cell to -1 (all bits set) at EOF.
+	EOFMinusOne
+)
+
+type Config struct {
+	// The number of cells. DefaultTapeSize if zero. It must not be negative.
+	TapeSize int
+	// The number of bits of each cell: 8, 16 or 32. 8 if zero. Other values are rejected.
+	CellWidth int
+	EOF       EOFBehavior
+}
+
+func (c *Config) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.TapeSize < 0 {
+		return fmt.Errorf("brainfuck: tape size must not be negative: %d", c.TapeSize)
+	}
+	switch c.CellWidth {
+	case 0, 8, 16, 32:
+	default:
+		return fmt.Errorf("brainfuck: cell width must be 8, 16 or 32: %d", c.CellWidth)
+	}
+	return nil
+}
+
+func (c *Config) tapeSize() int {
+	if c == nil || c.TapeSize == 0 {
+		return DefaultTapeSize
+	}
+	return c.TapeSize
+}
+
+func (c *Config) cellMask() uint32 {
+	if c == nil {
+		return 0xff
+	}
+	switch c.CellWidth {
+	case 16:
+		return 0xffff
+	case 32:
+		return 0xffff_ffff
+	default:
+		return 0xff
+	}
+}
+
+func (c *Config) eof() EOFBehavior {
+	if c == nil {
+		return EOFUnchanged
+	}
+	return c.EOF
+}
+
+var ErrTapeOverflow = errors.New("brainfuck: pointer moved out of the tape")
+
+// SyntaxError reports an unmatched bracket.
+type SyntaxError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("brainfuck: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+type opcode uint8
+
+const (
+	opAdd  opcode = iota // add arg to the current cell
+	opMove               // move the pointer by arg
+	opOut
+	opIn
+	opJumpIfZero    // jump to arg if the current cell is zero
+	opJumpIfNonZero // jump to arg if the current cell is non-zero
+)
+
+type instr struct {
+	op  opcode
+	arg int
+}
+
+// Program is a parsed Brainfuck program. Consecutive `+-` and `<>` are merged into single instructions.
+type Program struct {
+	code []instr
+}
+
+func Parse(src []byte) (*Program, error) {
+	type pos struct{ index, line, column int }
+	var code []instr
+	var stack []pos
+	line, column := 1, 0
+	for _, c := range src {
+		column++
+		if c == '\n' {
+			line++
+			column = 0
+		}
+		switch c {
+		case '+', '-':
+			delta := 1
+			if c == '-' {
+				delta = -1
+			}
+			if n := len(code); n > 0 && code[n-1].op == opAdd {
+				code[n-1].arg += delta
+			} else {
+				code = append(code, instr{op: opAdd, arg: delta})
+			}
+		case '>', '<':
+			delta := 1
+			if c == '<' {
+				delta = -1
+			}
+			if n := len(code); n > 0 && code[n-1].op == opMove {
+				code[n-1].arg += delta
+			} else {
+				code = append(code, instr{op: opMove, arg: delta})
+			}
+		case '.':
+			code = append(code, instr{op: opOut})
+		case ',':
+			code = append(code, instr{op: opIn})
+		case '[':
+			stack = append(stack, pos{index: len(code), line: line, column: column})
+			code = append(code, instr{op: opJumpIfZero})
+		case ']':
+			if len(stack) == 0 {
+				return nil, &SyntaxError{Line: line, Column: column, Msg: "unmatched ]"}
+			}
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			code[open.index].arg = len(code) + 1
+			code = append(code, instr{op: opJumpIfNonZero, arg: open.index + 1})
+		}
+	}
+	if len(stack) > 0 {
+		open := stack[len(stack)-1]
+		return nil, &SyntaxError{Line: open.line, Column: open.column, Msg: "unmatched ["}
+	}
+	return &Program{code: code}, nil
+}
+
+type Machine struct {
+	prog *Program
+	conf *Config
+	mask uint32
+
+	in  io.Reader
+	out *bufio.Writer
+
+	tape []uint32
+	ptr  int
+	pc   int
+}
+
+var _ interp.Machine = (*Machine)(nil)
+
+// NewMachine creates a Machine that runs p. It fails if conf is invalid.
+func (p *Program) NewMachine(in io.Reader, out io.Writer, conf *Config) (*Machine, error) {
+	err := conf.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Machine{
+		prog: p,
+		conf: conf,
+		mask: conf.cellMask(),
+		in:   in,
+		out:  bufio.NewWriter(out),
+		tape: make([]uint32, conf.tapeSize()),
+	}, nil
+}
+
+// NewGuest creates a guest that runs p. The program talks ELRPC through `.` and `,`. It fails if conf is invalid.
+func NewGuest(p *Program, conf *Config, opts ...interp.Option) (*interp.Guest, error) {
+	err := conf.validate()
+	if err != nil {
+		return nil, err
+	}
+	return interp.NewGuest(func(s runtime.Stream) interp.Machine {
+		// conf is already validated.
+		m, _ := p.NewMachine(s, s, conf)
+		return m
+	}, opts...), nil
+}
+
+func (m *Machine) Step() (bool, error) {
+	if m.pc >= len(m.prog.code) {
+		return true, m.out.Flush()
+	}
+	in := m.prog.code[m.pc]
+	m.pc++
+	switch in.op {
+	case opAdd:
+		m.tape[m.ptr] = (m.tape[m.ptr] + uint32(in.arg)) & m.mask
+	case opMove:
+		m.ptr += in.arg
+		if m.ptr < 0 || len(m.tape) <= m.ptr {
+			return false, ErrTapeOverflow
+		}
+	case opOut:
+		err := m.out.WriteByte(byte(m.tape[m.ptr]))
+		if err != nil {
+			return false, err
+		}
+	case opIn:
+		// The host may be waiting for what the program has written so far.
+		err := m.out.Flush()
+		if err != nil {
+			return false, err
+		}
+		var buf [1]byte
+		_, err = io.ReadFull(m.in, buf[:])
+		if errors.Is(err, io.EOF) {
+			switch m.conf.eof() {
+			case EOFZero:
+				m.tape[m.ptr] = 0
+			case EOFMinusOne:
+				m.tape[m.ptr] = m.mask
+			}
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		m.tape[m.ptr] = uint32(buf[0])
+	case opJumpIfZero:
+		if m.tape[m.ptr] == 0 {
+			m.pc = in.arg
+		}
+	case opJumpIfNonZero:
+		if m.tape[m.ptr] != 0 {
+			m.pc = in.arg
+		}
+	}
+	return false, nil
+}
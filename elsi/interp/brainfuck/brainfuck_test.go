@@ -0,0 +1,338 @@
+package brainfuck_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+	"github.com/genkami/elsi/elsi/interp/brainfuck"
+	"golang.org/x/exp/slog"
+)
+
+const helloWorld = `
+++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++.
+`
+
+func run(t *testing.T, src string, input []byte, conf *brainfuck.Config) ([]byte, error) {
+	t.Helper()
+	prog, err := brainfuck.Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	m, err := prog.NewMachine(bytes.NewReader(input), out, conf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = interp.Run(context.Background(), m)
+	return out.Bytes(), err
+}
+
+func TestMachine_helloWorld(t *testing.T) {
+	got, err := run(t, helloWorld, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello World!\n" {
+		t.Errorf("want %q but got %q", "Hello World!\n", got)
+	}
+}
+
+func TestMachine_echo(t *testing.T) {
+	got, err := run(t, ",[.,]", []byte("abc"), &brainfuck.Config{EOF: brainfuck.EOFZero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abc" {
+		t.Errorf("want %q but got %q", "abc", got)
+	}
+}
+
+func TestMachine_cellWidth(t *testing.T) {
+	// Prints \x01 only if 256 does not wrap around.
+	src := strings.Repeat("+", 256) + ">+<[>.<[-]]"
+	cases := []struct {
+		width int
+		want  string
+	}{
+		{8, ""},
+		{16, "\x01"},
+		{32, "\x01"},
+	}
+	for _, tt := range cases {
+		got, err := run(t, src, nil, &brainfuck.Config{CellWidth: tt.width})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("width %d: want %q but got %q", tt.width, tt.want, got)
+		}
+	}
+}
+
+func TestMachine_eof(t *testing.T) {
+	cases := []struct {
+		eof  brainfuck.EOFBehavior
+		want string
+	}{
+		{brainfuck.EOFUnchanged, "\x01"},
+		{brainfuck.EOFZero, "\x00"},
+		{brainfuck.EOFMinusOne, "\xff"},
+	}
+	for _, tt := range cases {
+		got, err := run(t, "+,.", nil, &brainfuck.Config{EOF: tt.eof})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("eof %d: want %q but got %q", tt.eof, tt.want, got)
+		}
+	}
+}
+
+func TestMachine_tapeOverflow(t *testing.T) {
+	_, err := run(t, "+[>+]", nil, &brainfuck.Config{TapeSize: 16})
+	if !errors.Is(err, brainfuck.ErrTapeOverflow) {
+		t.Errorf("want ErrTapeOverflow but got %v", err)
+	}
+	_, err = run(t, "<", nil, nil)
+	if !errors.Is(err, brainfuck.ErrTapeOverflow) {
+		t.Errorf("want ErrTapeOverflow but got %v", err)
+	}
+}
+
+func TestMachine_negativeTapeSize(t *testing.T) {
+	prog, err := brainfuck.Parse([]byte("+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &brainfuck.Config{TapeSize: -1}
+	_, err = prog.NewMachine(nil, io.Discard, conf)
+	if err == nil {
+		t.Error("want error for a negative tape size")
+	}
+	_, err = brainfuck.NewGuest(prog, conf)
+	if err == nil {
+		t.Error("want error for a negative tape size")
+	}
+}
+
+func TestMachine_invalidCellWidth(t *testing.T) {
+	prog, err := brainfuck.Parse([]byte("+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, width := range []int{-8, 1, 7, 12, 64} {
+		conf := &brainfuck.Config{CellWidth: width}
+		_, err = prog.NewMachine(nil, io.Discard, conf)
+		if err == nil {
+			t.Errorf("want error for cell width %d", width)
+		}
+		_, err = brainfuck.NewGuest(prog, conf)
+		if err == nil {
+			t.Errorf("want error for cell width %d", width)
+		}
+	}
+}
+
+func TestParse_syntaxError(t *testing.T) {
+	cases := []struct {
+		src          string
+		line, column int
+	}{
+		{"+]", 1, 2},
+		{"+\n[[]", 2, 1},
+		{"[\n+\n]]", 3, 2},
+	}
+	for _, tt := range cases {
+		_, err := brainfuck.Parse([]byte(tt.src))
+		var syntaxErr *brainfuck.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: want SyntaxError but got %v", tt.src, err)
+			continue
+		}
+		if syntaxErr.Line != tt.line || syntaxErr.Column != tt.column {
+			t.Errorf("%q: want %d:%d but got %d:%d", tt.src, tt.line, tt.column, syntaxErr.Line, syntaxErr.Column)
+		}
+	}
+}
+
+// emit returns a program that writes b and leaves the current cell zero.
+func emit(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteString(strings.Repeat("+", int(c)))
+		sb.WriteString(".[-]")
+	}
+	return sb.String()
+}
+
+func frame(t *testing.T, m ...message.Message) []byte {
+	t.Helper()
+	enc := message.NewEncoder()
+	for _, x := range m {
+		err := x.MarshalELRPC(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := message.AppendLength(nil, len(enc.Buffer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(buf, enc.Buffer()...)
+}
+
+func TestGuest(t *testing.T) {
+	const modID, methodID = 0x0000_ffff, 0x0000_0001
+	req := frame(t, &message.Uint32{Value: modID}, &message.Uint32{Value: methodID}, &message.String{Value: "hi"})
+	resp := frame(t, &message.Result[*message.String, *message.Error]{IsOk: true, Ok: &message.String{Value: "HI"}})
+	// Send the request, and then consume the response.
+	src := emit(req) + strings.Repeat(",", len(resp))
+	prog, err := brainfuck.Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest, err := brainfuck.NewGuest(prog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := runtime.NewRuntime(logger, guest)
+	var got string
+	rt.Use(modID, methodID, apibuilder.HostHandler1[*message.String, *message.String](func(s *message.String) (*message.String, error) {
+		got = s.Value
+		return &message.String{Value: strings.ToUpper(s.Value)}, nil
+	}))
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hi" {
+		t.Errorf("want hi but got %q", got)
+	}
+	if guest.Steps() == 0 {
+		t.Error("want non-zero steps")
+	}
+}
+
+func TestGuest_checkpoint(t *testing.T) {
+	const modID = 0x0000_ffff
+	req1 := frame(t, &message.Uint32{Value: modID}, &message.Uint32{Value: 1})
+	req2 := frame(t, &message.Uint32{Value: modID}, &message.Uint32{Value: 2})
+	resp := frame(t, &message.Result[*message.Uint8, *message.Error]{IsOk: true, Ok: &message.Uint8{Value: 0}})
+	src := emit(req1) + strings.Repeat(",", len(resp)) + "+++>" + emit(req2) + strings.Repeat(",", len(resp))
+	prog, err := brainfuck.Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	guest, err := brainfuck.NewGuest(prog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := runtime.NewRuntime(logger, guest)
+	requested := make(chan struct{})
+	rt.Use(modID, 1, apibuilder.HostHandler0[*message.Uint8](func() (*message.Uint8, error) {
+		// The guest pauses as soon as it consumes this response.
+		guest.RequestPause()
+		close(requested)
+		return &message.Uint8{Value: 0}, nil
+	}))
+	rt.Use(modID, 2, apibuilder.HostHandler0[*message.Uint8](func() (*message.Uint8, error) {
+		t.Error("method 2 is called before the checkpoint")
+		return &message.Uint8{Value: 0}, nil
+	}))
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-requested
+	err = guest.Pause(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := guest.Checkpoint(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest.Kill()
+	_ = rt.Wait()
+
+	var buf bytes.Buffer
+	err = snap.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err = interp.LoadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := brainfuck.NewGuestFromSnapshot(prog, nil, snap)
+	rt = runtime.NewRuntime(logger, restored)
+	called := false
+	rt.Use(modID, 2, apibuilder.HostHandler0[*message.Uint8](func() (*message.Uint8, error) {
+		called = true
+		return &message.Uint8{Value: 0}, nil
+	}))
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("method 2 is not called after the restore")
+	}
+	if restored.Steps() <= snap.Steps {
+		t.Errorf("want more than %d steps but got %d", snap.Steps, restored.Steps())
+	}
+}
+
+func TestProgram_RestoreMachine_mismatch(t *testing.T) {
+	prog, err := brainfuck.Parse([]byte("+>+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := prog.NewMachine(nil, io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = interp.RunWithFuel(context.Background(), m, 2)
+	if !errors.Is(err, interp.ErrFuelExhausted) {
+		t.Fatal(err)
+	}
+	state, _, err := m.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := brainfuck.Parse([]byte("+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.RestoreMachine(nil, io.Discard, nil, state)
+	if err == nil {
+		t.Error("want error for a different program")
+	}
+	_, err = prog.RestoreMachine(nil, io.Discard, &brainfuck.Config{TapeSize: 16}, state)
+	if err == nil {
+		t.Error("want error for a different config")
+	}
+}
@@ -0,0 +1,71 @@
+package brainfuck
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+var _ interp.Checkpointer = (*Machine)(nil)
+
+var errStateMismatch = errors.New("brainfuck: the snapshot does not match the program or the config")
+
+type machineState struct {
+	CodeLen  int `json:"code_len"`
+	TapeSize int `json:"tape_size"`
+	PC       int `json:"pc"`
+	Ptr      int `json:"ptr"`
+	// The tape without the trailing zeros.
+	Tape []uint32 `json:"tape"`
+}
+
+func (m *Machine) Flush() error {
+	return m.out.Flush()
+}
+
+func (m *Machine) MarshalState() ([]byte, []byte, error) {
+	n := len(m.tape)
+	for n > 0 && m.tape[n-1] == 0 {
+		n--
+	}
+	state, err := json.Marshal(&machineState{
+		CodeLen:  len(m.prog.code),
+		TapeSize: len(m.tape),
+		PC:       m.pc,
+		Ptr:      m.ptr,
+		Tape:     m.tape[:n],
+	})
+	// `,` reads directly from the stream, so there is no buffered input.
+	return state, nil, err
+}
+
+// RestoreMachine creates a Machine from a state returned by MarshalState. p and conf must be the same as the saved ones.
+func (p *Program) RestoreMachine(in io.Reader, out io.Writer, conf *Config, state []byte) (*Machine, error) {
+	var st machineState
+	err := json.Unmarshal(state, &st)
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.NewMachine(in, out, conf)
+	if err != nil {
+		return nil, err
+	}
+	if st.CodeLen != len(p.code) || st.TapeSize != len(m.tape) || len(st.Tape) > len(m.tape) ||
+		st.PC < 0 || len(p.code) < st.PC || st.Ptr < 0 || len(m.tape) <= st.Ptr {
+		return nil, errStateMismatch
+	}
+	m.pc = st.PC
+	m.ptr = st.Ptr
+	copy(m.tape, st.Tape)
+	return m, nil
+}
+
+// NewGuestFromSnapshot creates a guest that resumes p from snap.
+func NewGuestFromSnapshot(p *Program, conf *Config, snap *interp.Snapshot, opts ...interp.Option) *interp.Guest {
+	return interp.NewGuestFromSnapshot(snap, func(s runtime.Stream, state []byte) (interp.Machine, error) {
+		return p.RestoreMachine(s, s, conf, state)
+	}, opts...)
+}
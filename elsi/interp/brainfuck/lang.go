@@ -0,0 +1,21 @@
+package brainfuck
+
+import (
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/lang"
+)
+
+func init() {
+	lang.Register(&lang.Language{
+		Name:         "brainfuck",
+		Extensions:   []string{".b", ".bf"},
+		Interpreters: []string{"bf"},
+		New: func(_ string, src []byte, _ []string, _ []runtime.ProcessOption) (runtime.Guest, error) {
+			prog, err := Parse(src)
+			if err != nil {
+				return nil, err
+			}
+			return NewGuest(prog, nil)
+		},
+	})
+}
@@ -0,0 +1,276 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sync/atomic"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+)
+
+// Checkpointer is implemented by Machines that can be paused and saved.
+type Checkpointer interface {
+	Machine
+	// Flush writes the output buffered by the Machine to the stream.
+	Flush() error
+	// MarshalState encodes the state of the Machine except for its program.
+	// input is what the Machine has read from the stream but not consumed yet, which is fed to the restored Machine.
+	MarshalState() (state, input []byte, err error)
+}
+
+// RestoreFactory creates a Machine that talks ELRPC over s from a state returned by Checkpointer.MarshalState.
+type RestoreFactory func(s runtime.Stream, state []byte) (Machine, error)
+
+var (
+	ErrNotCheckpointable = errors.New("interp: the machine cannot be checkpointed")
+	ErrNotPaused         = errors.New("interp: the guest is not paused")
+	ErrResumed           = errors.New("interp: the guest was resumed before it paused")
+	ErrExited            = errors.New("interp: the guest has exited")
+)
+
+// Snapshot is a saved state of a paused guest.
+type Snapshot struct {
+	// The number of instructions executed so far.
+	Steps   uint64 `json:"steps"`
+	Machine []byte `json:"machine"`
+	Input   []byte `json:"input,omitempty"`
+	// The handles that the guest opened, if saved.
+	Handles *expimpl.HandleSetState `json:"handles,omitempty"`
+}
+
+// Save writes s in JSON.
+func (s *Snapshot) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// NewGuestFromSnapshot creates a guest that resumes the Machine saved in snap.
+// The host should restore the handles by expimpl.RestoreHandleSet, so that the guest can keep using them.
+// Budgets given by opts apply to the resumed execution only.
+func NewGuestFromSnapshot(snap *Snapshot, restore RestoreFactory, opts ...Option) *Guest {
+	return newGuest(func(s runtime.Stream) (Machine, error) {
+		in := io.MultiReader(bytes.NewReader(snap.Input), s)
+		return restore(runtime.NewPipeStream(in, s), snap.Machine)
+	}, snap.Steps, opts)
+}
+
+type pauseState struct {
+	requested atomic.Bool
+	// Closed when the guest pauses or fails to pause.
+	pausedCh chan struct{}
+	settled  bool
+	err      error
+	resumeCh chan struct{}
+
+	// valid while paused
+	paused  bool
+	machine Checkpointer
+	steps   uint64
+}
+
+// settle wakes up Pause. The caller must hold g.mu.
+func (g *Guest) settlePause(err error) {
+	if g.pause.settled {
+		return
+	}
+	g.pause.settled = true
+	g.pause.err = err
+	close(g.pause.pausedCh)
+}
+
+// RequestPause asks the guest to pause between instructions, and returns immediately. See Pause.
+func (g *Guest) RequestPause() {
+	g.requestPause()
+}
+
+func (g *Guest) requestPause() chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.pause.requested.Load() {
+		g.pause.pausedCh = make(chan struct{})
+		g.pause.settled = false
+		g.pause.err = nil
+		g.pause.resumeCh = make(chan struct{})
+		g.pause.requested.Store(true)
+	}
+	return g.pause.pausedCh
+}
+
+// Pause stops the guest between instructions, and waits for it to stop.
+// The guest pauses only when it has no outstanding ELRPC request, so that it can be resumed by another host.
+// The Machine must implement Checkpointer.
+func (g *Guest) Pause(ctx context.Context) error {
+	pausedCh := g.requestPause()
+	select {
+	case <-pausedCh:
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.pause.err
+	case <-g.exited:
+		g.Resume()
+		return ErrExited
+	case <-ctx.Done():
+		g.Resume()
+		return ctx.Err()
+	}
+}
+
+// Resume resumes the paused guest.
+func (g *Guest) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.pause.requested.Load() {
+		return
+	}
+	g.pause.requested.Store(false)
+	g.settlePause(ErrResumed)
+	g.pause.paused = false
+	g.pause.machine = nil
+	close(g.pause.resumeCh)
+}
+
+// Checkpoint saves the state of the paused guest. It also saves the handles in hs unless hs is nil.
+func (g *Guest) Checkpoint(hs *expimpl.HandleSet) (*Snapshot, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.pause.paused {
+		return nil, ErrNotPaused
+	}
+	state, input, err := g.pause.machine.MarshalState()
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{Steps: g.pause.steps, Machine: state, Input: input}
+	if hs != nil {
+		snap.Handles = hs.Snapshot()
+	}
+	return snap, nil
+}
+
+func (g *Guest) pauseIfRequested(ctx context.Context, m Machine, tracker *frameTracker, steps uint64) error {
+	if !g.pause.requested.Load() {
+		return nil
+	}
+	cp, ok := m.(Checkpointer)
+	if !ok {
+		g.mu.Lock()
+		g.pause.requested.Store(false)
+		g.settlePause(ErrNotCheckpointable)
+		g.mu.Unlock()
+		return nil
+	}
+	err := cp.Flush()
+	if err != nil {
+		return err
+	}
+	if !tracker.idle() {
+		// Try again after the next instruction.
+		return nil
+	}
+
+	g.mu.Lock()
+	if !g.pause.requested.Load() {
+		g.mu.Unlock()
+		return nil
+	}
+	g.pause.paused = true
+	g.pause.machine = cp
+	g.pause.steps = g.baseSteps + steps
+	g.settlePause(nil)
+	resumeCh := g.pause.resumeCh
+	g.mu.Unlock()
+
+	select {
+	case <-resumeCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// frameTracker counts the ELRPC frames that a Machine sends and receives.
+type frameTracker struct {
+	s        runtime.Stream
+	sent     frameCounter
+	received frameCounter
+}
+
+func newFrameTracker(s runtime.Stream) *frameTracker {
+	return &frameTracker{s: s}
+}
+
+func (t *frameTracker) Read(p []byte) (int, error) {
+	n, err := t.s.Read(p)
+	t.received.feed(p[:n])
+	return n, err
+}
+
+func (t *frameTracker) Write(p []byte) (int, error) {
+	n, err := t.s.Write(p)
+	t.sent.feed(p[:n])
+	return n, err
+}
+
+// idle reports whether every request has been responded to.
+func (t *frameTracker) idle() bool {
+	return !t.sent.partial() && !t.received.partial() && t.sent.frames == t.received.frames
+}
+
+type frameCounter struct {
+	header    [message.LengthSize]byte
+	headerLen int
+	remaining int
+	frames    uint64
+}
+
+func (c *frameCounter) feed(p []byte) {
+	for len(p) > 0 {
+		if c.remaining > 0 {
+			n := c.remaining
+			if len(p) < n {
+				n = len(p)
+			}
+			p = p[n:]
+			c.remaining -= n
+			if c.remaining == 0 {
+				c.frames++
+			}
+			continue
+		}
+		n := copy(c.header[c.headerLen:], p)
+		p = p[n:]
+		c.headerLen += n
+		if c.headerLen < len(c.header) {
+			continue
+		}
+		c.headerLen = 0
+		length, err := message.DecodeLength(c.header[:])
+		if err != nil {
+			// Never idle again; the runtime rejects such a frame anyway.
+			c.remaining = math.MaxInt
+			continue
+		}
+		if length == 0 {
+			c.frames++
+		}
+		c.remaining = length
+	}
+}
+
+func (c *frameCounter) partial() bool {
+	return c.headerLen > 0 || c.remaining > 0
+}
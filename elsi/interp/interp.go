@@ -0,0 +1,285 @@
+// Package interp runs esolang interpreters as in-process guests.
+//
+// An interpreter is a Machine that executes one instruction at a time, and whose I/O instructions
+// read from and write to the ELRPC stream. That is, the program itself speaks ELRPC.
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+)
+
+// Machine is an interpreter loaded with a program.
+type Machine interface {
+	// Step executes a single instruction. It returns true once the program halts.
+	Step() (halted bool, err error)
+}
+
+// Metered is implemented by Machines whose single instructions can do an unbounded amount of work.
+type Metered interface {
+	Machine
+	// SetBudget is called before the Machine runs. Step charges b for the work that it does besides the instruction itself.
+	SetBudget(b *Budget)
+}
+
+// ExitCoder is implemented by Machines whose programs can specify their exit codes.
+type ExitCoder interface {
+	// ExitCode returns the exit code of the halted program.
+	ExitCode() int
+}
+
+// MachineFactory creates a Machine that talks ELRPC over s.
+type MachineFactory func(s runtime.Stream) Machine
+
+// The exit codes of interpreter guests.
+const (
+	ExitCodeOK    = 0
+	ExitCodeError = 1
+)
+
+// The number of steps between checks of the context.
+const checkInterval = 1024
+
+var (
+	ErrFuelExhausted    = errors.New("interp: fuel exhausted")
+	ErrDeadlineExceeded = errors.New("interp: deadline exceeded")
+)
+
+// BudgetError is returned when a Machine runs out of its budget. Err is ErrFuelExhausted or ErrDeadlineExceeded.
+type BudgetError struct {
+	Err   error
+	Steps uint64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%v after %d steps", e.Err, e.Steps)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return e.Err
+}
+
+// Budget is the fuel and the context that a Machine runs with.
+type Budget struct {
+	ctx   context.Context
+	fuel  uint64
+	used  uint64
+	steps uint64
+}
+
+// Charge uses n units of fuel for the work that the current step is about to do.
+// It fails with ErrFuelExhausted without using any fuel if not enough is left, or with Err.
+func (b *Budget) Charge(n uint64) error {
+	if b.fuel > 0 && n > b.fuel-b.used {
+		return &BudgetError{Err: ErrFuelExhausted, Steps: b.steps}
+	}
+	b.used += n
+	return b.Err()
+}
+
+// Err returns the error of the context once it is done. A step that loops for long should check it now and then.
+func (b *Budget) Err() error {
+	return b.ctx.Err()
+}
+
+// Guest runs a Machine in a goroutine.
+type Guest struct {
+	*runtime.GoroutineGuest
+	conf guestConfig
+	// The number of steps executed before the guest was restored from a Snapshot.
+	baseSteps uint64
+	exited    chan struct{}
+
+	mu    sync.Mutex
+	err   error
+	steps uint64
+	pause pauseState
+}
+
+var _ runtime.Guest = (*Guest)(nil)
+
+type guestConfig struct {
+	fuel     uint64
+	timeout  time.Duration
+	deadline time.Time
+}
+
+type Option func(*guestConfig)
+
+// WithFuel stops the Machine with ErrFuelExhausted once it uses n units of fuel.
+// Each instruction uses one unit however much work it does, unless the Machine implements Metered.
+func WithFuel(n uint64) Option {
+	return func(c *guestConfig) {
+		c.fuel = n
+	}
+}
+
+// WithTimeout stops the Machine with ErrDeadlineExceeded once d elapses after the guest starts.
+func WithTimeout(d time.Duration) Option {
+	return func(c *guestConfig) {
+		c.timeout = d
+	}
+}
+
+// WithDeadline stops the Machine with ErrDeadlineExceeded at t.
+func WithDeadline(t time.Time) Option {
+	return func(c *guestConfig) {
+		c.deadline = t
+	}
+}
+
+// deadlineFrom returns the earlier one of the deadlines given by WithTimeout and WithDeadline.
+func (c *guestConfig) deadlineFrom(start time.Time) (time.Time, bool) {
+	deadline := c.deadline
+	if c.timeout > 0 {
+		t := start.Add(c.timeout)
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+func NewGuest(newMachine MachineFactory, opts ...Option) *Guest {
+	return newGuest(func(s runtime.Stream) (Machine, error) {
+		return newMachine(s), nil
+	}, 0, opts)
+}
+
+func newGuest(newMachine func(s runtime.Stream) (Machine, error), baseSteps uint64, opts []Option) *Guest {
+	g := &Guest{baseSteps: baseSteps, exited: make(chan struct{})}
+	for _, opt := range opts {
+		opt(&g.conf)
+	}
+	g.GoroutineGuest = runtime.NewGoroutineGuest(func(ctx context.Context, s runtime.Stream) int {
+		defer close(g.exited)
+		tracker := newFrameTracker(s)
+		m, err := newMachine(tracker)
+		var steps uint64
+		if err == nil {
+			steps, err = g.run(ctx, m, tracker)
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.steps = g.baseSteps + steps
+		g.err = err
+		if err != nil {
+			return ExitCodeError
+		}
+		if ec, ok := m.(ExitCoder); ok {
+			return ec.ExitCode()
+		}
+		return ExitCodeOK
+	})
+	return g
+}
+
+func (g *Guest) run(ctx context.Context, m Machine, tracker *frameTracker) (uint64, error) {
+	beforeStep := func(steps uint64) error {
+		return g.pauseIfRequested(ctx, m, tracker, steps)
+	}
+	deadline, ok := g.conf.deadlineFrom(time.Now())
+	if !ok {
+		return runLoop(ctx, m, g.conf.fuel, beforeStep)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	// Unblock the Machine if it is waiting for the host.
+	timer := time.AfterFunc(time.Until(deadline), g.GoroutineGuest.Kill)
+	defer timer.Stop()
+	steps, err := runLoop(ctx, m, g.conf.fuel, beforeStep)
+	// ctx may be canceled by Kill before its deadline is reported.
+	if err != nil && !time.Now().Before(deadline) {
+		return steps, &BudgetError{Err: ErrDeadlineExceeded, Steps: steps}
+	}
+	return steps, err
+}
+
+// Wait waits for the program to halt. It returns the error of the Machine, if any.
+func (g *Guest) Wait() error {
+	err := g.GoroutineGuest.Wait()
+	if machineErr := g.Err(); machineErr != nil {
+		return machineErr
+	}
+	return err
+}
+
+// Err returns the error that stopped the Machine, if any.
+func (g *Guest) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// Steps returns the number of instructions that the Machine executed, including the ones executed before
+// the guest was restored from a Snapshot. It is valid after the guest exits.
+func (g *Guest) Steps() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.steps
+}
+
+// ExitStatus returns how the guest exited, including the number of executed instructions.
+// It returns nil until the guest exits.
+func (g *Guest) ExitStatus() *runtime.ExitStatus {
+	st := g.GoroutineGuest.ExitStatus()
+	if st == nil {
+		return nil
+	}
+	copied := *st
+	copied.Steps = g.Steps()
+	err := g.Err()
+	if errors.Is(err, ErrFuelExhausted) {
+		copied.Reason = runtime.ExitReasonFuelExhausted
+	} else if errors.Is(err, ErrDeadlineExceeded) {
+		copied.Reason = runtime.ExitReasonDeadlineExceeded
+	}
+	return &copied
+}
+
+// Run runs m until it halts, fails or ctx is canceled. It returns the number of executed instructions.
+func Run(ctx context.Context, m Machine) (uint64, error) {
+	return RunWithFuel(ctx, m, 0)
+}
+
+// RunWithFuel is like Run, but fails with ErrFuelExhausted once m uses up fuel as described in WithFuel.
+// There is no limit if fuel is zero.
+func RunWithFuel(ctx context.Context, m Machine, fuel uint64) (uint64, error) {
+	return runLoop(ctx, m, fuel, nil)
+}
+
+// runLoop runs m, calling beforeStep, if any, before each step.
+func runLoop(ctx context.Context, m Machine, fuel uint64, beforeStep func(steps uint64) error) (uint64, error) {
+	b := &Budget{ctx: ctx, fuel: fuel}
+	if mm, ok := m.(Metered); ok {
+		mm.SetBudget(b)
+	}
+	for {
+		if b.steps%checkInterval == 0 {
+			err := ctx.Err()
+			if err != nil {
+				return b.steps, err
+			}
+		}
+		if fuel > 0 && b.used >= fuel {
+			return b.steps, &BudgetError{Err: ErrFuelExhausted, Steps: b.steps}
+		}
+		if beforeStep != nil {
+			err := beforeStep(b.steps)
+			if err != nil {
+				return b.steps, err
+			}
+		}
+		b.steps++
+		b.used++
+		halted, err := m.Step()
+		if halted || err != nil {
+			return b.steps, err
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package interp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+	"golang.org/x/exp/slog"
+)
+
+// loop never halts.
+type loop struct{}
+
+func (loop) Step() (bool, error) {
+	return false, nil
+}
+
+// countdown halts after n steps.
+type countdown struct {
+	n int
+}
+
+func (c *countdown) Step() (bool, error) {
+	c.n--
+	return c.n <= 0, nil
+}
+
+// reader blocks until it reads a byte from the host.
+type reader struct {
+	s runtime.Stream
+}
+
+func (r *reader) Step() (bool, error) {
+	var buf [1]byte
+	_, err := io.ReadFull(r.s, buf[:])
+	return true, err
+}
+
+// spinner never finishes its first step, which charges the budget as it goes.
+type spinner struct {
+	b *interp.Budget
+}
+
+func (s *spinner) SetBudget(b *interp.Budget) {
+	s.b = b
+}
+
+func (s *spinner) Step() (bool, error) {
+	for {
+		err := s.b.Charge(1)
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+func TestRunWithFuel(t *testing.T) {
+	steps, err := interp.RunWithFuel(context.Background(), loop{}, 100)
+	var budgetErr *interp.BudgetError
+	if !errors.As(err, &budgetErr) || !errors.Is(err, interp.ErrFuelExhausted) {
+		t.Fatalf("want ErrFuelExhausted but got %v", err)
+	}
+	if steps != 100 || budgetErr.Steps != 100 {
+		t.Errorf("want 100 steps but got %d and %d", steps, budgetErr.Steps)
+	}
+
+	// The program halts exactly when the fuel runs out.
+	_, err = interp.RunWithFuel(context.Background(), &countdown{n: 100}, 100)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunWithFuel_metered(t *testing.T) {
+	steps, err := interp.RunWithFuel(context.Background(), &spinner{}, 100)
+	var budgetErr *interp.BudgetError
+	if !errors.As(err, &budgetErr) || !errors.Is(err, interp.ErrFuelExhausted) {
+		t.Fatalf("want ErrFuelExhausted but got %v", err)
+	}
+	if steps != 1 || budgetErr.Steps != 1 {
+		t.Errorf("want 1 step but got %d and %d", steps, budgetErr.Steps)
+	}
+}
+
+func runGuest(t *testing.T, newMachine interp.MachineFactory, opts ...interp.Option) (*runtime.Runtime, error) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	rt := runtime.NewRuntime(logger, interp.NewGuest(newMachine, opts...))
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt, rt.Wait()
+}
+
+func TestGuest_fuel(t *testing.T) {
+	rt, err := runGuest(t, func(runtime.Stream) interp.Machine { return loop{} }, interp.WithFuel(5000))
+	if !errors.Is(err, interp.ErrFuelExhausted) {
+		t.Fatalf("want ErrFuelExhausted but got %v", err)
+	}
+	st := rt.ExitStatus()
+	if st.Reason != runtime.ExitReasonFuelExhausted || st.Steps != 5000 {
+		t.Errorf("want fuel exhausted after 5000 steps but got %s after %d steps", st.Reason, st.Steps)
+	}
+}
+
+func TestGuest_timeout(t *testing.T) {
+	cases := []struct {
+		name       string
+		newMachine interp.MachineFactory
+	}{
+		{"infinite loop", func(runtime.Stream) interp.Machine { return loop{} }},
+		{"blocked on I/O", func(s runtime.Stream) interp.Machine { return &reader{s: s} }},
+		{"inside a step", func(runtime.Stream) interp.Machine { return &spinner{} }},
+	}
+	for _, tt := range cases {
+		start := time.Now()
+		rt, err := runGuest(t, tt.newMachine, interp.WithTimeout(50*time.Millisecond))
+		if !errors.Is(err, interp.ErrDeadlineExceeded) {
+			t.Errorf("%s: want ErrDeadlineExceeded but got %v", tt.name, err)
+			continue
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: took %s", tt.name, elapsed)
+		}
+		if st := rt.ExitStatus(); st.Reason != runtime.ExitReasonDeadlineExceeded {
+			t.Errorf("%s: want deadline exceeded but got %s", tt.name, st.Reason)
+		}
+	}
+}
+
+func TestGuest_exitStatus(t *testing.T) {
+	rt, err := runGuest(t, func(runtime.Stream) interp.Machine { return &countdown{n: 10} },
+		interp.WithFuel(100), interp.WithDeadline(time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := rt.ExitStatus()
+	if st.Reason != runtime.ExitReasonExited || st.ExitCode != 0 || st.Steps != 10 {
+		t.Errorf("want exit 0 after 10 steps but got %+v", st)
+	}
+}
+
+func TestGuest_Pause_notCheckpointable(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := interp.NewGuest(func(runtime.Stream) interp.Machine { return loop{} })
+	rt := runtime.NewRuntime(logger, guest)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Wait()
+	defer guest.Kill()
+
+	err = guest.Pause(context.Background())
+	if !errors.Is(err, interp.ErrNotCheckpointable) {
+		t.Errorf("want ErrNotCheckpointable but got %v", err)
+	}
+	_, err = guest.Checkpoint(nil)
+	if !errors.Is(err, interp.ErrNotPaused) {
+		t.Errorf("want ErrNotPaused but got %v", err)
+	}
+}
+
+func TestGuest_Pause_exited(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := interp.NewGuest(func(runtime.Stream) interp.Machine { return &countdown{n: 10} })
+	rt := runtime.NewRuntime(logger, guest)
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Pause(context.Background())
+	if !errors.Is(err, interp.ErrExited) {
+		t.Errorf("want ErrExited but got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package whitespace
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+var _ interp.Checkpointer = (*Machine)(nil)
+
+var errStateMismatch = errors.New("whitespace: the snapshot does not match the program")
+
+type machineState struct {
+	CodeLen int        `json:"code_len"`
+	PC      int        `json:"pc"`
+	Stack   []int64    `json:"stack"`
+	Heap    [][2]int64 `json:"heap"`
+	Calls   []int      `json:"calls"`
+}
+
+func (m *Machine) Flush() error {
+	return m.out.Flush()
+}
+
+func (m *Machine) MarshalState() ([]byte, []byte, error) {
+	st := &machineState{
+		CodeLen: len(m.prog.code),
+		PC:      m.pc,
+		Stack:   m.stack,
+		Calls:   m.calls,
+	}
+	for addr, v := range m.heap {
+		st.Heap = append(st.Heap, [2]int64{addr, v})
+	}
+	state, err := json.Marshal(st)
+	if err != nil {
+		return nil, nil, err
+	}
+	input, err := m.in.Peek(m.in.Buffered())
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, append([]byte{}, input...), nil
+}
+
+// RestoreMachine creates a Machine from a state returned by MarshalState. p must be the same as the saved one.
+func (p *Program) RestoreMachine(in io.Reader, out io.Writer, state []byte) (*Machine, error) {
+	var st machineState
+	err := json.Unmarshal(state, &st)
+	if err != nil {
+		return nil, err
+	}
+	if st.CodeLen != len(p.code) || st.PC < 0 || len(p.code) < st.PC {
+		return nil, errStateMismatch
+	}
+	for _, ret := range st.Calls {
+		if ret < 0 || len(p.code) < ret {
+			return nil, errStateMismatch
+		}
+	}
+	m := p.NewMachine(in, out)
+	m.pc = st.PC
+	m.stack = st.Stack
+	m.calls = st.Calls
+	for _, kv := range st.Heap {
+		m.heap[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// NewGuestFromSnapshot creates a guest that resumes p from snap.
+func NewGuestFromSnapshot(p *Program, snap *interp.Snapshot, opts ...interp.Option) *interp.Guest {
+	return interp.NewGuestFromSnapshot(snap, func(s runtime.Stream, state []byte) (interp.Machine, error) {
+		return p.RestoreMachine(s, s, state)
+	}, opts...)
+}
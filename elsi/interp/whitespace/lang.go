@@ -0,0 +1,22 @@
+package whitespace
+
+import (
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/lang"
+)
+
+func init() {
+	lang.Register(&lang.Language{
+		Name:       "whitespace",
+		Extensions: []string{".ws"},
+		// Line breaks are instructions.
+		RemoveHeader: true,
+		New: func(_ string, src []byte, _ []string, _ []runtime.ProcessOption) (runtime.Guest, error) {
+			prog, err := Parse(src)
+			if err != nil {
+				return nil, err
+			}
+			return NewGuest(prog), nil
+		},
+	})
+}
@@ -0,0 +1,513 @@
+// Package whitespace implements a Whitespace interpreter guest.
+//
+// The I/O instructions read from and write to the ELRPC stream. Output characters are written as single bytes,
+// and numbers are written in decimal. Numbers are 64-bit signed integers, unlike the reference implementation.
+package whitespace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+)
+
+// SyntaxError reports an invalid program. Line and Column are the position of the offending token,
+// counting all characters including comments.
+type SyntaxError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("whitespace: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// RuntimeError reports an instruction that failed. Line and Column are the position of the instruction.
+type RuntimeError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("whitespace: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+type opcode uint8
+
+const (
+	opPush opcode = iota
+	opDup
+	opCopy
+	opSwap
+	opDiscard
+	opSlide
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opMod
+	opStore
+	opRetrieve
+	opMark
+	opCall
+	opJump
+	opJumpIfZero
+	opJumpIfNegative
+	opReturn
+	opEnd
+	opOutChar
+	opOutNum
+	opReadChar
+	opReadNum
+)
+
+type argKind uint8
+
+const (
+	argNone argKind = iota
+	argNumber
+	argLabel
+)
+
+type instrDef struct {
+	op   opcode
+	name string
+	arg  argKind
+}
+
+// The instructions keyed by their IMP and command, where S, T and L stand for space, tab and line feed.
+var instrDefs = map[string]instrDef{
+	"SS":   {opPush, "push", argNumber},
+	"SLS":  {opDup, "dup", argNone},
+	"STS":  {opCopy, "copy", argNumber},
+	"SLT":  {opSwap, "swap", argNone},
+	"SLL":  {opDiscard, "discard", argNone},
+	"STL":  {opSlide, "slide", argNumber},
+	"TSSS": {opAdd, "add", argNone},
+	"TSST": {opSub, "sub", argNone},
+	"TSSL": {opMul, "mul", argNone},
+	"TSTS": {opDiv, "div", argNone},
+	"TSTT": {opMod, "mod", argNone},
+	"TTS":  {opStore, "store", argNone},
+	"TTT":  {opRetrieve, "retrieve", argNone},
+	"LSS":  {opMark, "mark", argLabel},
+	"LST":  {opCall, "call", argLabel},
+	"LSL":  {opJump, "jump", argLabel},
+	"LTS":  {opJumpIfZero, "jz", argLabel},
+	"LTT":  {opJumpIfNegative, "jn", argLabel},
+	"LTL":  {opReturn, "ret", argNone},
+	"LLL":  {opEnd, "end", argNone},
+	"TLSS": {opOutChar, "outchar", argNone},
+	"TLST": {opOutNum, "outnum", argNone},
+	"TLTS": {opReadChar, "readchar", argNone},
+	"TLTT": {opReadNum, "readnum", argNone},
+}
+
+type instr struct {
+	op  opcode
+	arg int64
+	// The position of the first character of the instruction.
+	line, column int
+}
+
+// Program is a parsed Whitespace program. Labels are resolved to instruction indices.
+type Program struct {
+	code []instr
+}
+
+type token struct {
+	c            byte // 'S', 'T' or 'L'
+	line, column int
+}
+
+func tokenize(src []byte) []token {
+	var tokens []token
+	line, column := 1, 0
+	for _, c := range src {
+		column++
+		switch c {
+		case ' ':
+			tokens = append(tokens, token{'S', line, column})
+		case '\t':
+			tokens = append(tokens, token{'T', line, column})
+		case '\n':
+			tokens = append(tokens, token{'L', line, column})
+			line++
+			column = 0
+		}
+	}
+	return tokens
+}
+
+func Parse(src []byte) (*Program, error) {
+	tokens := tokenize(src)
+	var code []instr
+	labels := make(map[string]int)
+	type labelRef struct {
+		index int
+		label string
+		tok   token
+	}
+	var refs []labelRef
+
+	for i := 0; i < len(tokens); {
+		start := tokens[i]
+		var def instrDef
+		found := false
+		var cmd strings.Builder
+		for i < len(tokens) {
+			cmd.WriteByte(tokens[i].c)
+			i++
+			def, found = instrDefs[cmd.String()]
+			if found || !isPrefix(cmd.String()) {
+				break
+			}
+		}
+		if !found {
+			if isPrefix(cmd.String()) {
+				return nil, &SyntaxError{Line: start.line, Column: start.column, Msg: "unexpected end of program"}
+			}
+			return nil, &SyntaxError{Line: start.line, Column: start.column, Msg: fmt.Sprintf("unknown instruction %s", cmd.String())}
+		}
+
+		in := instr{op: def.op, line: start.line, column: start.column}
+		switch def.arg {
+		case argNumber:
+			argStart := start
+			if i < len(tokens) {
+				argStart = tokens[i]
+			}
+			n, next, err := parseNumber(tokens, i)
+			if err != nil {
+				return nil, &SyntaxError{Line: argStart.line, Column: argStart.column, Msg: fmt.Sprintf("%s: %s", def.name, err)}
+			}
+			in.arg = n
+			i = next
+		case argLabel:
+			argStart := start
+			if i < len(tokens) {
+				argStart = tokens[i]
+			}
+			label, next, ok := parseLabel(tokens, i)
+			if !ok {
+				return nil, &SyntaxError{Line: argStart.line, Column: argStart.column, Msg: fmt.Sprintf("%s: unterminated label", def.name)}
+			}
+			i = next
+			if def.op == opMark {
+				if _, dup := labels[label]; dup {
+					return nil, &SyntaxError{Line: argStart.line, Column: argStart.column, Msg: fmt.Sprintf("duplicate label %q", label)}
+				}
+				labels[label] = len(code)
+			} else {
+				refs = append(refs, labelRef{index: len(code), label: label, tok: argStart})
+			}
+		}
+		code = append(code, in)
+	}
+
+	for _, ref := range refs {
+		target, ok := labels[ref.label]
+		if !ok {
+			return nil, &SyntaxError{Line: ref.tok.line, Column: ref.tok.column, Msg: fmt.Sprintf("undefined label %q", ref.label)}
+		}
+		code[ref.index].arg = int64(target)
+	}
+	return &Program{code: code}, nil
+}
+
+func isPrefix(cmd string) bool {
+	for k := range instrDefs {
+		if strings.HasPrefix(k, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errUnterminatedNumber = errors.New("unterminated number")
+	errNumberTooLarge     = errors.New("number too large")
+)
+
+// parseNumber parses a sign followed by binary digits and a line feed.
+func parseNumber(tokens []token, i int) (int64, int, error) {
+	if i >= len(tokens) {
+		return 0, i, errUnterminatedNumber
+	}
+	negative := false
+	switch tokens[i].c {
+	case 'T':
+		negative = true
+	case 'L':
+		// No sign and no digits; the reference implementation reads it as zero.
+		return 0, i + 1, nil
+	}
+	i++
+	var n uint64
+	for ; i < len(tokens); i++ {
+		switch tokens[i].c {
+		case 'L':
+			if negative {
+				return -int64(n), i + 1, nil
+			}
+			return int64(n), i + 1, nil
+		case 'S', 'T':
+			if n > (1<<63-1)>>1 {
+				return 0, i, errNumberTooLarge
+			}
+			n <<= 1
+			if tokens[i].c == 'T' {
+				n |= 1
+			}
+		}
+	}
+	return 0, i, errUnterminatedNumber
+}
+
+// parseLabel parses a sequence of spaces and tabs followed by a line feed.
+func parseLabel(tokens []token, i int) (string, int, bool) {
+	var sb strings.Builder
+	for ; i < len(tokens); i++ {
+		if tokens[i].c == 'L' {
+			return sb.String(), i + 1, true
+		}
+		sb.WriteByte(tokens[i].c)
+	}
+	return "", i, false
+}
+
+type Machine struct {
+	prog *Program
+
+	in  *bufio.Reader
+	out *bufio.Writer
+
+	stack []int64
+	heap  map[int64]int64
+	calls []int
+	pc    int
+}
+
+var _ interp.Machine = (*Machine)(nil)
+
+func (p *Program) NewMachine(in io.Reader, out io.Writer) *Machine {
+	return &Machine{
+		prog: p,
+		in:   bufio.NewReader(in),
+		out:  bufio.NewWriter(out),
+		heap: make(map[int64]int64),
+	}
+}
+
+// NewGuest creates a guest that runs p. The program talks ELRPC through its I/O instructions.
+func NewGuest(p *Program, opts ...interp.Option) *interp.Guest {
+	return interp.NewGuest(func(s runtime.Stream) interp.Machine {
+		return p.NewMachine(s, s)
+	}, opts...)
+}
+
+func (m *Machine) errorf(in instr, format string, args ...any) error {
+	return &RuntimeError{Line: in.line, Column: in.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// pop pops n values. The last one is the top of the stack.
+func (m *Machine) pop(in instr, n int) ([]int64, error) {
+	if len(m.stack) < n {
+		return nil, m.errorf(in, "stack underflow")
+	}
+	vs := m.stack[len(m.stack)-n:]
+	m.stack = m.stack[:len(m.stack)-n]
+	return vs, nil
+}
+
+func (m *Machine) push(v int64) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *Machine) Step() (bool, error) {
+	if m.pc >= len(m.prog.code) {
+		err := m.out.Flush()
+		if err != nil {
+			return false, err
+		}
+		return false, errors.New("whitespace: program ended without the end instruction")
+	}
+	in := m.prog.code[m.pc]
+	m.pc++
+	switch in.op {
+	case opPush:
+		m.push(in.arg)
+	case opDup:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		m.push(vs[0])
+		m.push(vs[0])
+	case opCopy:
+		i := int64(len(m.stack)) - 1 - in.arg
+		if in.arg < 0 || i < 0 {
+			return false, m.errorf(in, "copy: index %d out of range", in.arg)
+		}
+		m.push(m.stack[i])
+	case opSwap:
+		vs, err := m.pop(in, 2)
+		if err != nil {
+			return false, err
+		}
+		a, b := vs[0], vs[1]
+		m.push(b)
+		m.push(a)
+	case opDiscard:
+		_, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+	case opSlide:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		top := vs[0]
+		if in.arg < 0 || int64(len(m.stack)) < in.arg {
+			return false, m.errorf(in, "slide: count %d out of range", in.arg)
+		}
+		m.stack = m.stack[:int64(len(m.stack))-in.arg]
+		m.push(top)
+	case opAdd, opSub, opMul, opDiv, opMod:
+		vs, err := m.pop(in, 2)
+		if err != nil {
+			return false, err
+		}
+		v, err := m.arith(in, vs[0], vs[1])
+		if err != nil {
+			return false, err
+		}
+		m.push(v)
+	case opStore:
+		vs, err := m.pop(in, 2)
+		if err != nil {
+			return false, err
+		}
+		m.heap[vs[0]] = vs[1]
+	case opRetrieve:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		m.push(m.heap[vs[0]])
+	case opMark:
+	case opCall:
+		m.calls = append(m.calls, m.pc)
+		m.pc = int(in.arg)
+	case opJump:
+		m.pc = int(in.arg)
+	case opJumpIfZero, opJumpIfNegative:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		if (in.op == opJumpIfZero && vs[0] == 0) || (in.op == opJumpIfNegative && vs[0] < 0) {
+			m.pc = int(in.arg)
+		}
+	case opReturn:
+		if len(m.calls) == 0 {
+			return false, m.errorf(in, "ret: not in a subroutine")
+		}
+		m.pc = m.calls[len(m.calls)-1]
+		m.calls = m.calls[:len(m.calls)-1]
+	case opEnd:
+		return true, m.out.Flush()
+	case opOutChar:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		err = m.out.WriteByte(byte(vs[0]))
+		if err != nil {
+			return false, err
+		}
+	case opOutNum:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		_, err = m.out.WriteString(strconv.FormatInt(vs[0], 10))
+		if err != nil {
+			return false, err
+		}
+	case opReadChar, opReadNum:
+		vs, err := m.pop(in, 1)
+		if err != nil {
+			return false, err
+		}
+		v, err := m.read(in)
+		if err != nil {
+			return false, err
+		}
+		m.heap[vs[0]] = v
+	}
+	return false, nil
+}
+
+func (m *Machine) arith(in instr, a, b int64) (int64, error) {
+	switch in.op {
+	case opAdd:
+		return a + b, nil
+	case opSub:
+		return a - b, nil
+	case opMul:
+		return a * b, nil
+	}
+	if b == 0 {
+		return 0, m.errorf(in, "division by zero")
+	}
+	// Whitespace rounds toward negative infinity like Haskell's div and mod.
+	q, r := a/b, a%b
+	if r != 0 && (r < 0) != (b < 0) {
+		q--
+		r += b
+	}
+	if in.op == opDiv {
+		return q, nil
+	}
+	return r, nil
+}
+
+// read reads a byte for readchar, or a line containing a decimal number for readnum.
+// readchar reads -1 at EOF.
+func (m *Machine) read(in instr) (int64, error) {
+	// The host may be waiting for what the program has written so far.
+	err := m.out.Flush()
+	if err != nil {
+		return 0, err
+	}
+	if in.op == opReadChar {
+		b, err := m.in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return -1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return int64(b), nil
+	}
+	line, err := m.in.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		if line == "" {
+			return 0, m.errorf(in, "readnum: unexpected EOF")
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil {
+		return 0, m.errorf(in, "readnum: %v", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,289 @@
+package whitespace_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/interp"
+	"github.com/genkami/elsi/elsi/interp/whitespace"
+	"golang.org/x/exp/slog"
+)
+
+// ws converts S, T and L into space, tab and line feed. Other characters are left as comments.
+func ws(src string) []byte {
+	return []byte(strings.NewReplacer("S", " ", "T", "\t", "L", "\n").Replace(src))
+}
+
+// num encodes n as a Whitespace number.
+func num(n int64) string {
+	var sb strings.Builder
+	if n < 0 {
+		sb.WriteString("T")
+		n = -n
+	} else {
+		sb.WriteString("S")
+	}
+	var bits []byte
+	for ; n > 0; n >>= 1 {
+		bits = append(bits, "ST"[n&1])
+	}
+	for i := len(bits) - 1; i >= 0; i-- {
+		sb.WriteByte(bits[i])
+	}
+	sb.WriteString("L")
+	return sb.String()
+}
+
+func push(n int64) string {
+	return "SS" + num(n)
+}
+
+const (
+	dup      = "SLS"
+	add      = "TSSS"
+	sub      = "TSST"
+	div      = "TSTS"
+	mod      = "TSTT"
+	store    = "TTS"
+	retrieve = "TTT"
+	ret      = "LTL"
+	end      = "LLL"
+	outChar  = "TLSS"
+	outNum   = "TLST"
+	readChar = "TLTS"
+	readNum  = "TLTT"
+)
+
+func mark(label string) string { return "LSS" + label + "L" }
+func call(label string) string { return "LST" + label + "L" }
+func jump(label string) string { return "LSL" + label + "L" }
+func jz(label string) string   { return "LTS" + label + "L" }
+
+func run(t *testing.T, src string, input string) (string, error) {
+	t.Helper()
+	prog, err := whitespace.Parse(ws(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	_, err = interp.Run(context.Background(), prog.NewMachine(strings.NewReader(input), out))
+	return out.String(), err
+}
+
+func TestMachine(t *testing.T) {
+	cases := []struct {
+		name  string
+		src   string
+		input string
+		want  string
+	}{
+		{
+			name: "output",
+			src:  push('H') + outChar + push('i') + outChar + push(-12) + outNum + end,
+			want: "Hi-12",
+		},
+		{
+			name: "arithmetic",
+			src:  push(7) + push(-2) + div + outNum + push(7) + push(-2) + mod + outNum + push(3) + push(4) + sub + outNum + end,
+			want: "-4-1-1",
+		},
+		{
+			name: "loop",
+			src: push(1) + mark("S") + dup + outNum + push(1) + add + dup + push(4) + sub + jz("T") + jump("S") +
+				mark("T") + end,
+			want: "123",
+		},
+		{
+			name: "subroutine and heap",
+			src:  push(0) + push(42) + store + call("TS") + call("TS") + end + mark("TS") + push(0) + retrieve + outNum + ret,
+			want: "4242",
+		},
+		{
+			name:  "input",
+			src:   push(0) + readChar + push(1) + readNum + push(0) + retrieve + outChar + push(1) + retrieve + outNum + end,
+			input: "x-123\n",
+			want:  "x-123",
+		},
+		{
+			name: "EOF",
+			src:  push(0) + readChar + push(0) + retrieve + outNum + end,
+			want: "-1",
+		},
+		{
+			name: "comments",
+			src:  "push" + push(1) + "print" + outNum + "end" + end,
+			want: "1",
+		},
+	}
+	for _, tt := range cases {
+		got, err := run(t, tt.src, tt.input)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: want %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMachine_runtimeError(t *testing.T) {
+	cases := []struct {
+		name         string
+		src          string
+		line, column int
+	}{
+		{"stack underflow", push(1) + add + end, 2, 1},
+		{"division by zero", push(1) + push(0) + "xx" + div + end, 3, 3},
+		{"return outside subroutine", ret, 1, 1},
+		{"readnum at EOF", push(0) + readNum + end, 2, 1},
+	}
+	for _, tt := range cases {
+		_, err := run(t, tt.src, "")
+		var runtimeErr *whitespace.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("%s: want RuntimeError but got %v", tt.name, err)
+			continue
+		}
+		if runtimeErr.Line != tt.line || runtimeErr.Column != tt.column {
+			t.Errorf("%s: want %d:%d but got %d:%d", tt.name, tt.line, tt.column, runtimeErr.Line, runtimeErr.Column)
+		}
+	}
+
+	_, err := run(t, push(1), "")
+	if err == nil {
+		t.Error("want error for a program without end")
+	}
+}
+
+func TestParse_syntaxError(t *testing.T) {
+	cases := []struct {
+		name         string
+		src          string
+		line, column int
+	}{
+		// "TLL" is not an instruction.
+		{"unknown instruction", push(1) + "ab" + "TLL", 2, 3},
+		{"unterminated number", "SSST", 1, 3},
+		{"unterminated label", "LSSST", 2, 3},
+		{"incomplete instruction", push(1) + "TS", 2, 1},
+		{"undefined label", end + call("TT"), 5, 3},
+		{"duplicate label", mark("T") + mark("T"), 4, 3},
+	}
+	for _, tt := range cases {
+		_, err := whitespace.Parse(ws(tt.src))
+		var syntaxErr *whitespace.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: want SyntaxError but got %v", tt.name, err)
+			continue
+		}
+		if syntaxErr.Line != tt.line || syntaxErr.Column != tt.column {
+			t.Errorf("%s: want %d:%d but got %d:%d (%v)", tt.name, tt.line, tt.column, syntaxErr.Line, syntaxErr.Column, err)
+		}
+	}
+}
+
+func frame(t *testing.T, m ...message.Message) []byte {
+	t.Helper()
+	enc := message.NewEncoder()
+	for _, x := range m {
+		err := x.MarshalELRPC(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := message.AppendLength(nil, len(enc.Buffer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(buf, enc.Buffer()...)
+}
+
+func TestGuest(t *testing.T) {
+	const modID, methodID = 0x0000_ffff, 0x0000_0001
+	req := frame(t, &message.Uint32{Value: modID}, &message.Uint32{Value: methodID}, &message.String{Value: "hi"})
+	resp := frame(t, &message.Result[*message.String, *message.Error]{IsOk: true, Ok: &message.String{Value: "HI"}})
+	// Send the request, and then store the response in the heap.
+	var sb strings.Builder
+	for _, b := range req {
+		sb.WriteString(push(int64(b)) + outChar)
+	}
+	for i := range resp {
+		sb.WriteString(push(int64(i)) + readChar)
+	}
+	sb.WriteString(end)
+	prog, err := whitespace.Parse(ws(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	guest := whitespace.NewGuest(prog)
+	rt := runtime.NewRuntime(logger, guest)
+	var got string
+	rt.Use(modID, methodID, apibuilder.HostHandler1[*message.String, *message.String](func(s *message.String) (*message.String, error) {
+		got = s.Value
+		return &message.String{Value: strings.ToUpper(s.Value)}, nil
+	}))
+	err = rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hi" {
+		t.Errorf("want hi but got %q", got)
+	}
+}
+
+func TestMachine_MarshalState(t *testing.T) {
+	src := push(0) + readChar + push(1) + readNum + push(0) + retrieve + call("S") + end +
+		mark("S") + outChar + push(1) + retrieve + outNum + ret
+	const input, want = "x-123\nrest", "x-123"
+	prog, err := whitespace.Parse(ws(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Save and restore the machine after every number of steps.
+	for n := uint64(1); ; n++ {
+		in := strings.NewReader(input)
+		out := &bytes.Buffer{}
+		m := prog.NewMachine(in, out)
+		_, err := interp.RunWithFuel(context.Background(), m, n)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, interp.ErrFuelExhausted) {
+			t.Fatalf("%d: %v", n, err)
+		}
+		err = m.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, pending, err := m.MarshalState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := prog.RestoreMachine(io.MultiReader(bytes.NewReader(pending), in), out, state)
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		_, err = interp.Run(context.Background(), restored)
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		if out.String() != want {
+			t.Errorf("%d: want %q but got %q", n, want, out.String())
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package lang maps source files to guests that run them.
+//
+// A language is chosen by the shebang-style header of a file (e.g. `#!/usr/bin/env esotime brainfuck`),
+// or by its extension if it has no known header. Packages register languages in their init functions,
+// so that importing them for side effects makes the languages available:
+//
+//	import _ "github.com/genkami/elsi/elsi/interp/brainfuck"
+package lang
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+)
+
+var ErrUnknownLanguage = errors.New("lang: unknown language")
+
+// Factory creates a guest that runs the source file at path with args.
+// src is the content of the file, in which the shebang-style header naming esotime or the language is replaced with
+// an empty line so that line numbers do not change, or removed if the language sets RemoveHeader.
+// opts apply to the guest if it runs as a process; languages that run in the host ignore them.
+type Factory func(path string, src []byte, args []string, opts []runtime.ProcessOption) (runtime.Guest, error)
+
+type Language struct {
+	Name string
+	// File extensions including the leading dot, such as ".bf".
+	Extensions []string
+	// Names that select the language in shebang-style headers, in addition to Name.
+	Interpreters []string
+	// Removes the shebang-style header including its line break, for languages in which line breaks are significant.
+	RemoveHeader bool
+	New          Factory
+}
+
+func (l *Language) isNamed(name string) bool {
+	if name == l.Name {
+		return true
+	}
+	for _, n := range l.Interpreters {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry is a set of languages. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	byName   map[string]*Language
+	byExt    map[string]*Language
+	byInterp map[string]*Language
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byName:   make(map[string]*Language),
+		byExt:    make(map[string]*Language),
+		byInterp: make(map[string]*Language),
+	}
+}
+
+// Register adds l to the registry. It fails if the name, any of the extensions or any of the interpreters is already registered.
+func (r *Registry) Register(l *Language) error {
+	if l.Name == "" || l.New == nil {
+		return errors.New("lang: language must have a name and a factory")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.byName[l.Name]; dup {
+		return fmt.Errorf("lang: language %q is already registered", l.Name)
+	}
+	for _, ext := range l.Extensions {
+		if other, dup := r.byExt[ext]; dup {
+			return fmt.Errorf("lang: extension %q is already registered by %q", ext, other.Name)
+		}
+	}
+	interps := append([]string{l.Name}, l.Interpreters...)
+	for _, name := range interps {
+		if other, dup := r.byInterp[name]; dup {
+			return fmt.Errorf("lang: interpreter %q is already registered by %q", name, other.Name)
+		}
+	}
+
+	r.byName[l.Name] = l
+	for _, ext := range l.Extensions {
+		r.byExt[ext] = l
+	}
+	for _, name := range interps {
+		r.byInterp[name] = l
+	}
+	return nil
+}
+
+// Languages returns the registered languages sorted by name.
+func (r *Registry) Languages() []*Language {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	langs := make([]*Language, 0, len(r.byName))
+	for _, l := range r.byName {
+		langs = append(langs, l)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		return langs[i].Name < langs[j].Name
+	})
+	return langs
+}
+
+// Lookup returns the language for path. interpreter is the name in the shebang-style header, or "" if none.
+func (r *Registry) Lookup(path, interpreter string) (*Language, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if l, ok := r.byInterp[interpreter]; ok && interpreter != "" {
+		return l, true
+	}
+	l, ok := r.byExt[filepath.Ext(path)]
+	return l, ok
+}
+
+// Open creates a guest that runs the source file at path. opts are passed to the language's Factory.
+// It returns an error wrapping ErrUnknownLanguage if the file does not exist or no language is registered for it.
+func (r *Registry) Open(path string, args []string, opts ...runtime.ProcessOption) (runtime.Guest, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLanguage, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header, interpreter, esotime := readHeader(br)
+	l, ok := r.Lookup(path, interpreter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLanguage, path)
+	}
+	src, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if esotime || l.isNamed(interpreter) {
+		if l.RemoveHeader {
+			header = ""
+		} else {
+			header = header[len(strings.TrimRight(header, "\r\n")):]
+		}
+	}
+	return l.New(path, append([]byte(header), src...), args, opts)
+}
+
+// readHeader consumes the shebang-style header, if any. It returns the header, the name of the interpreter in it,
+// and whether the header runs esotime.
+// The name is the base name of the last word, so that both `#!/usr/bin/env esotime NAME` and `#!/path/to/NAME` work.
+func readHeader(br *bufio.Reader) (header, interpreter string, esotime bool) {
+	magic, err := br.Peek(2)
+	if err != nil || string(magic) != "#!" {
+		return "", "", false
+	}
+	header, err = br.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return header, "", false
+	}
+	fields := strings.Fields(header[2:])
+	for _, f := range fields {
+		if filepath.Base(f) == "esotime" {
+			esotime = true
+		}
+	}
+	if len(fields) > 0 {
+		interpreter = filepath.Base(fields[len(fields)-1])
+	}
+	return header, interpreter, esotime
+}
+
+// Command returns a Factory that runs the source file by an external command, as in `name args... PATH SCRIPT_ARGS...`.
+func Command(name string, args ...string) Factory {
+	return func(path string, _ []byte, scriptArgs []string, opts []runtime.ProcessOption) (runtime.Guest, error) {
+		cmdArgs := append(append(append([]string{}, args...), path), scriptArgs...)
+		return runtime.NewProcessGuestWithOptions(name, cmdArgs, opts...)
+	}
+}
+
+// CommandConfig is an external command that runs a language, which is loaded by LoadConfig.
+type CommandConfig struct {
+	Name         string   `json:"name"`
+	Extensions   []string `json:"extensions"`
+	Interpreters []string `json:"interpreters"`
+	// The command and its arguments, which are followed by the path to the source file.
+	Command []string `json:"command"`
+}
+
+// LoadConfig registers the languages in a JSON array of CommandConfig read from rd.
+func (r *Registry) LoadConfig(rd io.Reader) error {
+	var confs []CommandConfig
+	err := json.NewDecoder(rd).Decode(&confs)
+	if err != nil {
+		return err
+	}
+	for _, c := range confs {
+		if len(c.Command) == 0 {
+			return fmt.Errorf("lang: language %q has no command", c.Name)
+		}
+		err := r.Register(&Language{
+			Name:         c.Name,
+			Extensions:   c.Extensions,
+			Interpreters: c.Interpreters,
+			New:          Command(c.Command[0], c.Command[1:]...),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Default is the registry used by the package-level functions.
+var Default = NewRegistry()
+
+// Register adds l to Default. It panics if l conflicts with another language, like database/sql.Register.
+func Register(l *Language) {
+	err := Default.Register(l)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Open creates a guest that runs the source file at path using Default.
+func Open(path string, args []string, opts ...runtime.ProcessOption) (runtime.Guest, error) {
+	return Default.Open(path, args, opts...)
+}
@@ -0,0 +1,184 @@
+package lang_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	_ "github.com/genkami/elsi/elsi/interp/brainfuck"
+	"github.com/genkami/elsi/elsi/lang"
+)
+
+type opened struct {
+	lang string
+	src  string
+	args []string
+}
+
+func fakeLanguage(name string, exts []string, interps []string, got *opened) *lang.Language {
+	return &lang.Language{
+		Name:         name,
+		Extensions:   exts,
+		Interpreters: interps,
+		New: func(_ string, src []byte, args []string, _ []runtime.ProcessOption) (runtime.Guest, error) {
+			*got = opened{lang: name, src: string(src), args: args}
+			return runtime.NewGoroutineGuest(func(context.Context, runtime.Stream) int { return 0 }), nil
+		},
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRegistry_Open(t *testing.T) {
+	var got opened
+	r := lang.NewRegistry()
+	baz := fakeLanguage("baz", []string{".baz"}, nil, &got)
+	baz.RemoveHeader = true
+	for _, l := range []*lang.Language{
+		fakeLanguage("foo", []string{".foo"}, nil, &got),
+		fakeLanguage("bar", []string{".bar"}, []string{"barbar"}, &got),
+		baz,
+	} {
+		err := r.Register(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name, file, content string
+		want                opened
+	}{
+		{"extension", "a.foo", "code", opened{lang: "foo", src: "code"}},
+		{"header", "a", "#!/usr/bin/env esotime bar\ncode", opened{lang: "bar", src: "\ncode"}},
+		{"header by alias", "a", "#!/usr/local/bin/barbar\ncode", opened{lang: "bar", src: "\ncode"}},
+		{"header over extension", "a.foo", "#!/usr/bin/env esotime bar\ncode", opened{lang: "bar", src: "\ncode"}},
+		{"header with CRLF", "a", "#!/usr/bin/env esotime bar\r\ncode", opened{lang: "bar", src: "\r\ncode"}},
+		{"header without a line break", "a", "#!/usr/bin/env esotime bar", opened{lang: "bar", src: ""}},
+		{"esotime without a language", "a.foo", "#!/usr/bin/env esotime\ncode", opened{lang: "foo", src: "\ncode"}},
+		{"removed header", "a", "#!/usr/bin/env esotime baz\ncode", opened{lang: "baz", src: "code"}},
+		{"unknown header", "a.foo", "#!/bin/sh\ncode", opened{lang: "foo", src: "#!/bin/sh\ncode"}},
+		{"comment", "a.foo", "#!comment\ncode", opened{lang: "foo", src: "#!comment\ncode"}},
+	}
+	for _, tt := range cases {
+		got = opened{}
+		path := writeFile(t, tt.file, tt.content)
+		_, err := r.Open(path, []string{"x"})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.lang != tt.want.lang || got.src != tt.want.src || len(got.args) != 1 {
+			t.Errorf("%s: want %+v but got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRegistry_Open_unknown(t *testing.T) {
+	r := lang.NewRegistry()
+	for _, path := range []string{
+		writeFile(t, "a.txt", "hello"),
+		writeFile(t, "a.sh", "#!/bin/sh\n"),
+		filepath.Join(t.TempDir(), "nonexistent.bf"),
+	} {
+		_, err := r.Open(path, nil)
+		if !errors.Is(err, lang.ErrUnknownLanguage) {
+			t.Errorf("%s: want ErrUnknownLanguage but got %v", path, err)
+		}
+	}
+}
+
+func TestRegistry_Register_conflict(t *testing.T) {
+	var got opened
+	r := lang.NewRegistry()
+	err := r.Register(fakeLanguage("foo", []string{".foo"}, []string{"f"}, &got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*lang.Language{
+		fakeLanguage("foo", nil, nil, &got),
+		fakeLanguage("bar", []string{".foo"}, nil, &got),
+		fakeLanguage("bar", nil, []string{"f"}, &got),
+		fakeLanguage("f", nil, nil, &got),
+	} {
+		err := r.Register(l)
+		if err == nil {
+			t.Errorf("want error for %+v", l)
+		}
+	}
+	if n := len(r.Languages()); n != 1 {
+		t.Errorf("want 1 language but got %d", n)
+	}
+}
+
+func TestRegistry_LoadConfig(t *testing.T) {
+	r := lang.NewRegistry()
+	err := r.LoadConfig(strings.NewReader(`[{"name": "shell", "extensions": [".sh"], "command": ["sh", "-e"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "exit.sh", "exit \"$1\"\n")
+	guest, err := r.Open(path, []string{"3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Wait()
+	var exitErr interface{ ExitCode() int }
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("want exit code 3 but got %v", err)
+	}
+}
+
+func TestRegistry_Open_processOptions(t *testing.T) {
+	r := lang.NewRegistry()
+	err := r.LoadConfig(strings.NewReader(`[{"name": "shell", "extensions": [".sh"], "command": ["sh", "-e"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "exit.sh", "exit \"$ELSI_TEST_EXIT_CODE\"\n")
+	guest, err := r.Open(path, nil, runtime.WithEnv("ELSI_TEST_EXIT_CODE", "4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Wait()
+	var exitErr interface{ ExitCode() int }
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+		t.Errorf("want exit code 4 but got %v", err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	path := writeFile(t, "hello.bf", "+[-]")
+	guest, err := lang.Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = guest.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package link composes guests by forwarding one guest's imports to another guest's exports.
+//
+// The methods of a module that a guest (the exporter) serves through builtin.Exporter become callable
+// from another guest (the importer) as if the host implemented them:
+//
+//	exporter := runtime.NewRuntime(logger, jsonParser)
+//	importer := runtime.NewRuntime(logger, brainfuckProgram)
+//	err := link.Use(importer, exporter, &link.Link{ImportModuleID: 0x0001_0000, ExportModuleID: 0x0000_1000, MethodIDs: []uint32{1, 2}})
+//
+// Arguments and results are relayed without being decoded, so the host does not need to know the types of the methods.
+//
+// Links must not form a cycle, e.g. pools whose members call each other. A call waits for the callee,
+// so a cycle can deadlock once every member in it is waiting for another.
+package link
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/types"
+)
+
+// Callee calls the methods exported by a guest. *runtime.Runtime and *pool.Pool implement it.
+type Callee interface {
+	CallContext(ctx context.Context, moduleID, methodID uint32, args *message.Any) (*message.Any, error)
+}
+
+// Link connects a module imported by a guest to a module exported by another guest.
+type Link struct {
+	// The module that the importer calls.
+	ImportModuleID uint32 `json:"import_module_id"`
+	// The module that the exporter serves.
+	ExportModuleID uint32 `json:"export_module_id"`
+	// The methods to forward, which have the same IDs in both modules.
+	MethodIDs []uint32 `json:"method_ids"`
+}
+
+// Use registers the handlers of the linked methods in rt, which forward the calls to callee.
+// It fails if ImportModuleID is the builtin module, or if rt already has the module (see runtime.Runtime.HasModule),
+// so that a link never replaces the methods that the host or another link provides.
+func Use(rt types.Runtime, callee Callee, l *Link) error {
+	if l.ImportModuleID == builtin.ModuleID {
+		return fmt.Errorf("link: module %X is the builtin module", l.ImportModuleID)
+	}
+	if r, ok := rt.(interface{ HasModule(uint32) bool }); ok && r.HasModule(l.ImportModuleID) {
+		return fmt.Errorf("link: module %X is already registered", l.ImportModuleID)
+	}
+	for _, methodID := range l.MethodIDs {
+		rt.Use(l.ImportModuleID, methodID, Forward(callee, l.ExportModuleID, methodID))
+	}
+	return nil
+}
+
+// Forward returns a handler that forwards calls to the method of callee.
+// Calls are canceled when the importer's deadline is exceeded.
+func Forward(callee Callee, moduleID, methodID uint32) types.ContextHostHandler {
+	return &forwarder{
+		callee:   callee,
+		moduleID: moduleID,
+		methodID: methodID,
+	}
+}
+
+type forwarder struct {
+	callee   Callee
+	moduleID uint32
+	methodID uint32
+}
+
+func (f *forwarder) HandleRequest(dec *message.Decoder) (message.Message, error) {
+	return f.HandleRequestContext(context.Background(), dec)
+}
+
+func (f *forwarder) HandleRequestContext(ctx context.Context, dec *message.Decoder) (message.Message, error) {
+	args := &message.Any{Raw: dec.DecodeRaw()}
+	ret, err := f.callee.CallContext(ctx, f.moduleID, f.methodID, args)
+	if err != nil {
+		var elrpcErr *message.Error
+		if errors.As(err, &elrpcErr) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		// The exporter has exited, or it is not available for now.
+		return nil, &message.Error{
+			ModuleID: builtin.ModuleID,
+			Code:     builtin.CodeUnavailable,
+			Message:  fmt.Sprintf("method %X in module %X is unavailable: %v", f.methodID, f.moduleID, err),
+		}
+	}
+	return &message.Raw{Value: ret.Raw}, nil
+}
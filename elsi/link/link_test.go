@@ -0,0 +1,153 @@
+package link_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/genkami/elsi/elrpc/api/builtin"
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/guest"
+	"github.com/genkami/elsi/elsi/link"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	exportModuleID = 0x0000_1000
+	importModuleID = 0x0001_0000
+
+	methodID_Upper  = 0x0000_0001
+	methodID_Failed = 0x0000_0002
+	methodID_Quit   = 0x0000_0003
+	// Not linked.
+	methodID_Lower = 0x0000_0004
+)
+
+func newExporter(logger *slog.Logger) *runtime.Runtime {
+	g := runtime.NewGoroutineGuest(func(ctx context.Context, s runtime.Stream) int {
+		d := guest.NewDispatcher(guest.NewConn(s, s))
+		d.Handle(exportModuleID, methodID_Upper, apibuilder.HostHandler2[*message.String, *message.Uint8, *message.String](
+			func(s *message.String, n *message.Uint8) (*message.String, error) {
+				return &message.String{Value: strings.Repeat(strings.ToUpper(s.Value), int(n.Value))}, nil
+			}))
+		d.Handle(exportModuleID, methodID_Failed, apibuilder.HostHandler0[message.Void](
+			func() (message.Void, error) {
+				return message.Void{}, &message.Error{ModuleID: exportModuleID, Code: 42, Message: "failed"}
+			}))
+		ctx, cancel := context.WithCancel(ctx)
+		d.Handle(exportModuleID, methodID_Quit, apibuilder.HostHandler0[message.Void](
+			func() (message.Void, error) {
+				cancel()
+				return message.Void{}, nil
+			}))
+		_ = d.Serve(ctx)
+		return 0
+	})
+	return runtime.NewRuntime(logger, g)
+}
+
+func TestUse(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	exporter := newExporter(logger)
+	err := exporter.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var guestErr error
+	g := runtime.NewGoroutineGuest(func(_ context.Context, s runtime.Stream) int {
+		guestErr = func() error {
+			conn := guest.NewConn(s, s)
+			upper, err := guest.Call[*message.String](conn, importModuleID, methodID_Upper,
+				&message.String{Value: "yo"}, &message.Uint8{Value: 3})
+			if err != nil {
+				return err
+			}
+			if upper.Value != "YOYOYO" {
+				t.Errorf("want YOYOYO but got %q", upper.Value)
+			}
+
+			_, err = guest.Call[message.Void](conn, importModuleID, methodID_Failed)
+			var elrpcErr *message.Error
+			if !errors.As(err, &elrpcErr) || elrpcErr.ModuleID != exportModuleID || elrpcErr.Code != 42 {
+				t.Errorf("want the exporter's error but got %v", err)
+			}
+
+			_, err = guest.Call[*message.String](conn, importModuleID, methodID_Lower, &message.String{Value: "YO"})
+			if !errors.As(err, &elrpcErr) || elrpcErr.Code != builtin.CodeUnimplemented {
+				t.Errorf("want CodeUnimplemented but got %v", err)
+			}
+
+			_, err = guest.Call[message.Void](conn, importModuleID, methodID_Quit)
+			if err != nil {
+				return err
+			}
+			err = exporter.Wait()
+			if err != nil {
+				return err
+			}
+			_, err = guest.Call[*message.String](conn, importModuleID, methodID_Upper,
+				&message.String{Value: "yo"}, &message.Uint8{Value: 1})
+			if !errors.As(err, &elrpcErr) || elrpcErr.Code != builtin.CodeUnavailable {
+				t.Errorf("want CodeUnavailable but got %v", err)
+			}
+			return nil
+		}()
+		return 0
+	})
+	importer := runtime.NewRuntime(logger, g)
+	err = link.Use(importer, exporter, &link.Link{
+		ImportModuleID: importModuleID,
+		ExportModuleID: exportModuleID,
+		MethodIDs:      []uint32{methodID_Upper, methodID_Failed, methodID_Quit},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = importer.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = importer.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guestErr != nil {
+		t.Fatal(guestErr)
+	}
+}
+
+func TestUse_collision(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	exporter := newExporter(logger)
+	importer := runtime.NewRuntime(logger, nil)
+	importer.Use(importModuleID+1, methodID_Upper, apibuilder.HostHandler0[message.Void](
+		func() (message.Void, error) {
+			return message.Void{}, nil
+		}))
+	l := &link.Link{ImportModuleID: importModuleID, ExportModuleID: exportModuleID, MethodIDs: []uint32{methodID_Upper}}
+	err := link.Use(importer, exporter, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name           string
+		importModuleID uint32
+	}{
+		{name: "builtin", importModuleID: builtin.ModuleID},
+		{name: "host", importModuleID: importModuleID + 1},
+		{name: "link", importModuleID: importModuleID},
+	}
+	for _, tt := range cases {
+		l := &link.Link{ImportModuleID: tt.importModuleID, ExportModuleID: exportModuleID, MethodIDs: []uint32{methodID_Lower}}
+		err := link.Use(importer, exporter, l)
+		if err == nil {
+			t.Errorf("%s: want error but got nil", tt.name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// memListener is an in-memory listener of a member, to which the pool forwards HTTP requests.
+type memListener struct {
+	name      string
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+}
+
+var _ net.Listener = (*memListener)(nil)
+
+func newMemListener(logger *slog.Logger, name string) *memListener {
+	lis := &memListener{
+		name:   name,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	lis.transport = &http.Transport{DialContext: lis.dial}
+	lis.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&url.URL{Scheme: "http", Host: name})
+			r.Out.Host = r.In.Host
+		},
+		Transport: lis.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Error("failed to forward request", slog.String("listener_name", name), slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return lis
+}
+
+func (lis *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-lis.conns:
+		return conn, nil
+	case <-lis.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (lis *memListener) Close() error {
+	lis.closeOnce.Do(func() {
+		close(lis.closed)
+		lis.transport.CloseIdleConnections()
+	})
+	return nil
+}
+
+func (lis *memListener) Addr() net.Addr {
+	return memAddr(lis.name)
+}
+
+func (lis *memListener) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case lis.conns <- server:
+		return client, nil
+	case <-lis.closed:
+	case <-ctx.Done():
+	}
+	client.Close()
+	server.Close()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, net.ErrClosed
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "pool" }
+func (a memAddr) String() string  { return string(a) }
@@ -0,0 +1,480 @@
+// Package pool runs identical guests behind one logical service.
+//
+// Calls to the guests' methods and requests to their HTTP listeners are distributed across the members of the pool
+// by a Policy, warm spares take over the members that exit, and the members are restarted by a RestartPolicy:
+//
+//	p, err := pool.New(logger, &pool.Config{
+//		Size:          4,
+//		Spares:        1,
+//		Restart:       pool.RestartOnFailure,
+//		Backoff:       100 * time.Millisecond,
+//		HTTPListeners: map[string]expimpl.HttpListenerConfig{"default": {AddrAndPort: ":8080"}},
+//	}, func(m *pool.Member) (*runtime.Runtime, error) {
+//		rt := runtime.NewRuntime(logger, newGuest())
+//		exp.ImportHTTP(rt, expimpl.NewHTTP(logger, expimpl.NewHandleSet(), m.HTTPListeners("default")))
+//		return rt, nil
+//	})
+//	err = p.Start()
+//	resp, err := p.Call(moduleID, methodID, args)
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+)
+
+var (
+	// ErrNoMember is returned when no member can take a call.
+	ErrNoMember = errors.New("pool: no member is available")
+	ErrClosed   = errors.New("pool: closed")
+)
+
+type Policy int
+
+const (
+	// LeastOutstanding chooses the member with the fewest calls and requests in flight.
+	LeastOutstanding Policy = iota
+	// RoundRobin chooses the members in turn.
+	RoundRobin
+)
+
+type Config struct {
+	// The number of members that take calls and requests. It must be positive.
+	Size int
+	// The number of members that are started in advance, but take nothing until they replace members that exit.
+	Spares int
+	Policy Policy
+
+	// Defaults to RestartNever.
+	Restart RestartPolicy
+	// The delay before restarting a member, which doubles for each consecutive restart up to MaxBackoff.
+	// Restarts are no longer consecutive once a member runs for MaxBackoff. Zero means restarting immediately.
+	Backoff time.Duration
+	// Defaults to 32 times Backoff.
+	MaxBackoff time.Duration
+	// The pool gives up restarting members if it restarts them more than MaxRestarts times within RestartWindow,
+	// which means that they are crash-looping. Zero means no limit.
+	MaxRestarts   int
+	RestartWindow time.Duration
+
+	// How long calls and requests wait for a member while none is available, e.g. during restarts.
+	// Zero means that they fail immediately.
+	WaitTimeout time.Duration
+	// The HTTP listeners that the pool opens when a member starts listening on them, and keeps open across restarts.
+	// Requests to them are handled as Handler does.
+	HTTPListeners map[string]expimpl.HttpListenerConfig
+}
+
+// Factory creates the runtime of a member, which is started by the pool.
+// It is called for every member, including the ones that replace exited members.
+type Factory func(m *Member) (*runtime.Runtime, error)
+
+// Member is a guest in the pool.
+type Member struct {
+	id        int
+	pool      *Pool
+	rt        *runtime.Runtime
+	startedAt time.Time
+
+	// The following fields are guarded by pool.mu.
+	outstanding int
+	listeners   map[string]*memListener
+}
+
+// ID returns the number that identifies the member in the pool, which is unique during the pool's lifetime.
+func (m *Member) ID() int {
+	return m.id
+}
+
+// HTTPListeners returns the configuration of expimpl.HTTP that makes the member serve the pool's HTTP listeners with the given names.
+// Requests to the listeners are forwarded to the member after it calls exp.HTTP.Listen.
+func (m *Member) HTTPListeners(names ...string) map[string]expimpl.HttpListenerConfig {
+	confs := make(map[string]expimpl.HttpListenerConfig, len(names))
+	for _, name := range names {
+		name := name
+		confs[name] = expimpl.HttpListenerConfig{
+			Ctor: func(string) (net.Listener, error) {
+				return m.listen(name)
+			},
+			AddrAndPort: name,
+		}
+	}
+	return confs
+}
+
+func (m *Member) listen(name string) (net.Listener, error) {
+	p := m.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := m.listeners[name]; ok {
+		return nil, fmt.Errorf("pool: member %d is already listening on %q", m.id, name)
+	}
+	err := p.openHTTPListener(name)
+	if err != nil {
+		return nil, err
+	}
+	lis := newMemListener(p.logger, name)
+	m.listeners[name] = lis
+	p.notify()
+	return lis, nil
+}
+
+type Pool struct {
+	logger  *slog.Logger
+	conf    Config
+	factory Factory
+	wg      sync.WaitGroup
+	closing chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	active   []*Member
+	spares   []*Member
+	starting int
+	nextID   int
+	// The index in active from which members are scanned next.
+	cursor int
+	closed bool
+	// Closed and replaced whenever members become available.
+	changed chan struct{}
+	servers map[string]*http.Server
+	restarter
+	// The error that Wait returns.
+	err error
+}
+
+func New(logger *slog.Logger, conf *Config, factory Factory) (*Pool, error) {
+	if conf.Size <= 0 {
+		return nil, fmt.Errorf("pool: size must be positive: %d", conf.Size)
+	}
+	if conf.Spares < 0 {
+		return nil, fmt.Errorf("pool: spares must not be negative: %d", conf.Spares)
+	}
+	if conf.MaxRestarts > 0 && conf.RestartWindow <= 0 {
+		return nil, errors.New("pool: restart window must be positive to limit restarts")
+	}
+	p := &Pool{
+		logger:  logger,
+		conf:    *conf,
+		factory: factory,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+		servers: make(map[string]*http.Server),
+	}
+	if p.conf.MaxBackoff <= 0 {
+		p.conf.MaxBackoff = 32 * p.conf.Backoff
+	}
+	return p, nil
+}
+
+// Start starts all members and spares. If any of them fails to start, the others are stopped.
+func (p *Pool) Start() error {
+	for i := 0; i < p.conf.Size+p.conf.Spares; i++ {
+		p.mu.Lock()
+		p.addStarting(1)
+		p.mu.Unlock()
+		err := p.startMember()
+		if err != nil {
+			_ = p.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// addStarting counts members that are about to start. The caller must hold p.mu.
+func (p *Pool) addStarting(n int) {
+	p.starting += n
+	p.wg.Add(n)
+}
+
+// startMember starts a member, and adds it to the pool. The caller must have counted it by addStarting.
+func (p *Pool) startMember() error {
+	p.mu.Lock()
+	if p.closed {
+		p.starting--
+		p.wg.Done()
+		p.checkDone()
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	m := &Member{
+		id:        p.nextID,
+		pool:      p,
+		listeners: make(map[string]*memListener),
+	}
+	p.nextID++
+	p.mu.Unlock()
+
+	rt, err := p.factory(m)
+	if err == nil {
+		err = rt.Start()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starting--
+	if err != nil {
+		// The caller decides whether to retry before checking if the pool is done.
+		p.wg.Done()
+		return err
+	}
+	m.rt = rt
+	m.startedAt = time.Now()
+	if len(p.active) < p.conf.Size {
+		p.active = append(p.active, m)
+		p.notify()
+	} else {
+		p.spares = append(p.spares, m)
+	}
+	if p.closed {
+		_ = rt.Kill()
+	}
+	go p.watch(m)
+	return nil
+}
+
+// watch waits for the member to exit, and replaces it. It takes over the count of p.wg added for the member.
+func (p *Pool) watch(m *Member) {
+	defer p.wg.Done()
+	err := m.rt.Wait()
+	ranFor := time.Since(m.startedAt)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, lis := range m.listeners {
+		lis.Close()
+	}
+	p.active = remove(p.active, m)
+	p.spares = remove(p.spares, m)
+	defer p.checkDone()
+	if p.closed {
+		return
+	}
+	attrs := []any{slog.Int("member", m.id), slog.Duration("ran_for", ranFor)}
+	if err != nil {
+		p.logger.Warn("member failed", append(attrs, slog.String("error", err.Error()))...)
+	} else {
+		p.logger.Info("member exited", attrs...)
+	}
+	p.err = err
+	for len(p.active) < p.conf.Size && len(p.spares) > 0 {
+		p.active = append(p.active, p.spares[0])
+		p.spares = p.spares[1:]
+		p.notify()
+	}
+	p.scheduleRestarts(err, ranFor)
+}
+
+func remove(members []*Member, m *Member) []*Member {
+	for i, other := range members {
+		if other == m {
+			return append(members[:i:i], members[i+1:]...)
+		}
+	}
+	return members
+}
+
+// notify wakes up the calls and requests waiting for members. The caller must hold p.mu.
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// checkDone closes p.done if no member is running or going to start. The caller must hold p.mu.
+func (p *Pool) checkDone() {
+	if len(p.active)+len(p.spares)+p.starting > 0 {
+		return
+	}
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+		p.notify()
+	}
+}
+
+// pick chooses an active member by the policy. If listener is not empty, only the members listening on it are chosen.
+// If there is no such member, it waits for one for at most Config.WaitTimeout.
+// The caller must call release after the call or the request finishes.
+func (p *Pool) pick(ctx context.Context, listener string) (*Member, *memListener, error) {
+	var timeout <-chan time.Time
+	if p.conf.WaitTimeout > 0 {
+		timer := time.NewTimer(p.conf.WaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		p.mu.Lock()
+		m, lis, err := p.pickLocked(listener)
+		changed := p.changed
+		p.mu.Unlock()
+		if err != ErrNoMember || timeout == nil {
+			return m, lis, err
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return nil, nil, err
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+func (p *Pool) pickLocked(listener string) (*Member, *memListener, error) {
+	if p.closed {
+		return nil, nil, ErrClosed
+	}
+	var chosen *Member
+	next := 0
+	for i := range p.active {
+		j := (p.cursor + i) % len(p.active)
+		m := p.active[j]
+		if listener != "" && m.listeners[listener] == nil {
+			continue
+		}
+		// Ties go to the first member from the cursor, so that they are broken in turn.
+		if chosen == nil || (p.conf.Policy == LeastOutstanding && m.outstanding < chosen.outstanding) {
+			chosen = m
+			next = (j + 1) % len(p.active)
+		}
+		if p.conf.Policy == RoundRobin {
+			break
+		}
+	}
+	if chosen == nil {
+		return nil, nil, ErrNoMember
+	}
+	p.cursor = next
+	chosen.outstanding++
+	return chosen, chosen.listeners[listener], nil
+}
+
+func (p *Pool) release(m *Member) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.outstanding--
+}
+
+func (p *Pool) Call(moduleID, methodID uint32, args *message.Any) (*message.Any, error) {
+	return p.CallContext(context.Background(), moduleID, methodID, args)
+}
+
+// CallContext calls the method of a member chosen by the policy, and waits for its result.
+func (p *Pool) CallContext(ctx context.Context, moduleID, methodID uint32, args *message.Any) (*message.Any, error) {
+	m, _, err := p.pick(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	defer p.release(m)
+	return m.rt.CallContext(ctx, moduleID, methodID, args)
+}
+
+// Handler returns a handler that forwards requests to a member listening on the HTTP listener with the given name.
+func (p *Pool) Handler(listener string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m, lis, err := p.pick(r.Context(), listener)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer p.release(m)
+		lis.proxy.ServeHTTP(w, r)
+	})
+}
+
+// openHTTPListener starts serving the listener in Config.HTTPListeners, if any, unless it is already open.
+// The caller must hold p.mu.
+func (p *Pool) openHTTPListener(name string) error {
+	conf, ok := p.conf.HTTPListeners[name]
+	if !ok || p.servers[name] != nil {
+		return nil
+	}
+	ctor := conf.Ctor
+	if ctor == nil {
+		ctor = expimpl.TCPListenerCtor
+	}
+	lis, err := ctor(conf.AddrAndPort)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: p.Handler(name)}
+	p.servers[name] = srv
+	logger := p.logger.With(slog.String("listener_name", name), slog.String("addr", conf.AddrAndPort))
+	go func() {
+		err := srv.Serve(lis)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("server terminated unexpectedly", slog.String("error", err.Error()))
+		}
+	}()
+	return nil
+}
+
+// Stats returns the current state of the pool.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		Active:   len(p.active),
+		Spares:   len(p.spares),
+		Restarts: p.restarts,
+		Failures: p.failures,
+	}
+}
+
+type Stats struct {
+	Active int
+	Spares int
+	// The number of members started to replace the exited ones.
+	Restarts int
+	// The number of members that exited with errors or failed to start.
+	Failures int
+}
+
+// Wait waits until all members exit and the pool stops restarting them by the restart policy.
+// It returns an error wrapping ErrCrashLoop if the pool gave up restarting, or the error of the last member that exited otherwise.
+// It also returns after Close.
+func (p *Pool) Wait() error {
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close kills all members, stops serving HTTP listeners, and waits for the members to exit.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+		p.notify()
+	}
+	var errs []error
+	for _, members := range [][]*Member{p.active, p.spares} {
+		for _, m := range members {
+			err := m.rt.Kill()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("pool: member %d: %w", m.id, err))
+			}
+		}
+	}
+	for _, srv := range p.servers {
+		_ = srv.Close()
+	}
+	p.checkDone()
+	p.mu.Unlock()
+	p.wg.Wait()
+	return errors.Join(errs...)
+}
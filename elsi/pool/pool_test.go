@@ -0,0 +1,321 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/api/exp"
+	"github.com/genkami/elsi/elsi/guest"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+	"github.com/genkami/elsi/elsi/pool"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	testModuleID = 0x0000_ffff
+
+	methodID_Guest_ID    = 0x0000_0001
+	methodID_Guest_Block = 0x0000_0002
+	methodID_Guest_Exit  = 0x0000_0003
+	methodID_Guest_Fail  = 0x0000_0004
+)
+
+// newMember creates a member whose methods return its ID, and that responds to HTTP requests with its ID if listen is true.
+// block is called by methodID_Guest_Block.
+func newMember(t *testing.T, listen bool, block func()) pool.Factory {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	return func(m *pool.Member) (*runtime.Runtime, error) {
+		id := &message.Int64{Value: int64(m.ID())}
+		g := runtime.NewGoroutineGuest(func(ctx context.Context, s runtime.Stream) int {
+			conn := guest.NewConn(s, s)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			exitCode := 0
+			if listen {
+				go serveHTTP(ctx, conn, strconv.Itoa(m.ID()))
+			}
+			d := guest.NewDispatcher(conn)
+			d.Handle(testModuleID, methodID_Guest_ID, apibuilder.HostHandler0[*message.Int64](
+				func() (*message.Int64, error) {
+					return id, nil
+				}))
+			d.Handle(testModuleID, methodID_Guest_Block, apibuilder.HostHandler0[*message.Int64](
+				func() (*message.Int64, error) {
+					block()
+					return id, nil
+				}))
+			d.Handle(testModuleID, methodID_Guest_Exit, apibuilder.HostHandler0[message.Void](
+				func() (message.Void, error) {
+					cancel()
+					return message.Void{}, nil
+				}))
+			d.Handle(testModuleID, methodID_Guest_Fail, apibuilder.HostHandler0[message.Void](
+				func() (message.Void, error) {
+					exitCode = 1
+					cancel()
+					return message.Void{}, nil
+				}))
+			_ = d.Serve(ctx)
+			return exitCode
+		})
+		rt := runtime.NewRuntime(logger, g)
+		hs := expimpl.NewHandleSet()
+		exp.ImportStream(rt, expimpl.NewStream(hs))
+		exp.ImportHTTP(rt, expimpl.NewHTTP(logger, hs, m.HTTPListeners("default")))
+		return rt, nil
+	}
+}
+
+func serveHTTP(ctx context.Context, conn *guest.Conn, body string) {
+	h := guest.NewHTTP(conn)
+	stream := guest.NewStream(conn)
+	lis, err := h.Listen("default")
+	if err != nil {
+		panic(err)
+	}
+	for ctx.Err() == nil {
+		req, err := h.PollRequest(lis)
+		if err != nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		resp, err := h.SendResponseHeader(lis, req.RequestID, &exp.ServerResponseHeader{Status: http.StatusOK})
+		if err != nil {
+			panic(err)
+		}
+		_, _ = stream.Write(resp, []byte(body))
+		_ = stream.Close(resp)
+	}
+}
+
+func start(t *testing.T, conf *pool.Config, factory pool.Factory) *pool.Pool {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	p, err := pool.New(logger, conf, factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := p.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return p
+}
+
+func callID(t *testing.T, p *pool.Pool, methodID uint32) int64 {
+	t.Helper()
+	ret, err := p.Call(testModuleID, methodID, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := message.NewDecoder(ret.Raw).DecodeInt64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPool_Call_roundRobin(t *testing.T) {
+	p := start(t, &pool.Config{Size: 3, Spares: 1, Policy: pool.RoundRobin}, newMember(t, false, nil))
+	var got []int64
+	for i := 0; i < 6; i++ {
+		got = append(got, callID(t, p, methodID_Guest_ID))
+	}
+	want := []int64{0, 1, 2, 0, 1, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v but got %v", want, got)
+		}
+	}
+}
+
+func TestPool_Call_leastOutstanding(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	p := start(t, &pool.Config{Size: 2, Policy: pool.LeastOutstanding}, newMember(t, false, func() {
+		close(started)
+		<-unblock
+	}))
+	blocked := make(chan int64)
+	go func() {
+		ret, err := p.Call(testModuleID, methodID_Guest_Block, &message.Any{})
+		if err != nil {
+			t.Error(err)
+			blocked <- -1
+			return
+		}
+		id, _ := message.NewDecoder(ret.Raw).DecodeInt64()
+		blocked <- id
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		if id := callID(t, p, methodID_Guest_ID); id != 1 {
+			t.Errorf("want member 1 but got %d", id)
+		}
+	}
+	close(unblock)
+	if id := <-blocked; id != 0 {
+		t.Errorf("want member 0 but got %d", id)
+	}
+}
+
+func TestPool_Call_leastOutstandingTie(t *testing.T) {
+	p := start(t, &pool.Config{Size: 2, Policy: pool.LeastOutstanding}, newMember(t, false, nil))
+	for i := 0; i < 4; i++ {
+		if id := callID(t, p, methodID_Guest_ID); id != int64(i%2) {
+			t.Errorf("call %d: want member %d but got %d", i, i%2, id)
+		}
+	}
+}
+
+func TestPool_spares(t *testing.T) {
+	p := start(t, &pool.Config{Size: 1, Spares: 1, Restart: pool.RestartAlways}, newMember(t, false, nil))
+	if id := callID(t, p, methodID_Guest_ID); id != 0 {
+		t.Fatalf("want member 0 but got %d", id)
+	}
+	_, err := p.Call(testModuleID, methodID_Guest_Exit, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spare takes over, and a new spare is started.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, want := range []int64{1, 2} {
+		for {
+			ret, err := p.Call(testModuleID, methodID_Guest_ID, &message.Any{})
+			if err == nil {
+				id, _ := message.NewDecoder(ret.Raw).DecodeInt64()
+				if id == want {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("member %d did not take over", want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		_, err := p.Call(testModuleID, methodID_Guest_Exit, &message.Any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPool_Handler(t *testing.T) {
+	p := start(t, &pool.Config{Size: 2, Policy: pool.RoundRobin}, newMember(t, true, nil))
+	srv := httptest.NewServer(p.Handler("default"))
+	defer srv.Close()
+
+	get := func() (int, string) {
+		resp, err := http.Get(srv.URL + "/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+	// Wait until both members listen.
+	seen := map[string]bool{}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(seen) < 2 {
+		status, body := get()
+		if status == http.StatusOK {
+			seen[body] = true
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("members did not listen: %v", seen)
+		}
+	}
+
+	_, first := get()
+	for i := 0; i < 4; i++ {
+		_, body := get()
+		if body == first {
+			t.Fatalf("want requests to alternate but got %q twice", body)
+		}
+		first = body
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	_, err := pool.New(logger, &pool.Config{Size: 0}, newMember(t, false, nil))
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestPool_Close_socket(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	dir := t.TempDir()
+	conns := make(chan net.Conn, 1)
+	p, err := pool.New(logger, &pool.Config{Size: 1}, func(m *pool.Member) (*runtime.Runtime, error) {
+		g, err := runtime.ListenUnix(filepath.Join(dir, strconv.Itoa(m.ID())+".sock"))
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.Dial(g.Addr().Network(), g.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		conns <- conn
+		return runtime.NewRuntime(logger, g), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (<-conns).Close()
+
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats.Active != 0 {
+		t.Errorf("want no active member but got %+v", stats)
+	}
+}
+
+func TestPool_closed(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	p, err := pool.New(logger, &pool.Config{Size: 1}, newMember(t, false, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Call(testModuleID, methodID_Guest_ID, &message.Any{})
+	if !errors.Is(err, pool.ErrClosed) {
+		t.Errorf("want ErrClosed but got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// ErrCrashLoop is returned by Pool.Wait when the members exited too often to be restarted.
+var ErrCrashLoop = errors.New("pool: members are crash-looping")
+
+type RestartPolicy int
+
+const (
+	// RestartNever leaves exited members as they are. The pool shrinks until it runs out of spares.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts members that exit with errors or fail to start.
+	RestartOnFailure
+	// RestartAlways restarts members whenever they exit.
+	RestartAlways
+)
+
+func (r RestartPolicy) String() string {
+	switch r {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("RestartPolicy(%d)", int(r))
+	}
+}
+
+// ParseRestartPolicy parses the string representation of a RestartPolicy, such as "on-failure".
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	for _, r := range []RestartPolicy{RestartNever, RestartOnFailure, RestartAlways} {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("pool: unknown restart policy: %q", s)
+}
+
+// restarter is the state of restarts, which is guarded by Pool.mu.
+type restarter struct {
+	restarts int
+	failures int
+	// The number of consecutive restarts, which determines the backoff.
+	consecutive int
+	// The times of the recent restarts within Config.RestartWindow.
+	recent []time.Time
+	gaveUp bool
+}
+
+// scheduleRestarts starts members to replace the one that exited with err after running for ranFor, if the policy allows.
+// The caller must hold p.mu.
+func (p *Pool) scheduleRestarts(err error, ranFor time.Duration) {
+	if err != nil {
+		p.failures++
+	}
+	if p.gaveUp || p.conf.Restart == RestartNever || (p.conf.Restart == RestartOnFailure && err == nil) {
+		return
+	}
+	missing := p.conf.Size + p.conf.Spares - len(p.active) - len(p.spares) - p.starting
+	if missing <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if p.conf.MaxRestarts > 0 {
+		recent := p.recent[:0]
+		for _, t := range p.recent {
+			if now.Sub(t) < p.conf.RestartWindow {
+				recent = append(recent, t)
+			}
+		}
+		p.recent = recent
+		if len(p.recent)+missing > p.conf.MaxRestarts {
+			p.gaveUp = true
+			p.err = fmt.Errorf("%w: %d restarts within %v: %v", ErrCrashLoop, len(p.recent), p.conf.RestartWindow, err)
+			p.logger.Error("gave up restarting members", slog.String("error", p.err.Error()))
+			return
+		}
+		for i := 0; i < missing; i++ {
+			p.recent = append(p.recent, now)
+		}
+	}
+
+	if ranFor >= p.conf.MaxBackoff {
+		p.consecutive = 0
+	}
+	delay := p.conf.Backoff
+	for i := 0; i < p.consecutive && delay < p.conf.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.conf.MaxBackoff {
+		delay = p.conf.MaxBackoff
+	}
+	p.consecutive++
+
+	p.restarts += missing
+	p.addStarting(missing)
+	for i := 0; i < missing; i++ {
+		go p.restart(delay)
+	}
+}
+
+// restart starts a member after delay. The caller must have counted it by addStarting.
+func (p *Pool) restart(delay time.Duration) {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-p.closing:
+		}
+	}
+	err := p.startMember()
+	if err == nil || errors.Is(err, ErrClosed) {
+		return
+	}
+	p.logger.Error("failed to start member", slog.String("error", err.Error()))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+	p.scheduleRestarts(err, 0)
+	p.checkDone()
+}
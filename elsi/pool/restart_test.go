@@ -0,0 +1,194 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/impl/expimpl"
+	"github.com/genkami/elsi/elsi/pool"
+	"golang.org/x/exp/slog"
+)
+
+// waitForMember calls methodID_Guest_ID until member want takes the call.
+func waitForMember(t *testing.T, p *pool.Pool, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ret, err := p.Call(testModuleID, methodID_Guest_ID, &message.Any{})
+		if err == nil {
+			id, _ := message.NewDecoder(ret.Raw).DecodeInt64()
+			if id == want {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("member %d did not start", want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPool_restartOnFailure(t *testing.T) {
+	p := start(t, &pool.Config{Size: 1, Restart: pool.RestartOnFailure, Backoff: time.Millisecond}, newMember(t, false, nil))
+	_, err := p.Call(testModuleID, methodID_Guest_Fail, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForMember(t, p, 1)
+	stats := p.Stats()
+	if stats.Restarts != 1 || stats.Failures != 1 {
+		t.Errorf("want 1 restart and 1 failure but got %+v", stats)
+	}
+
+	// A member that exits successfully is not restarted.
+	_, err = p.Call(testModuleID, methodID_Guest_Exit, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Wait()
+	if err != nil {
+		t.Errorf("want nil but got %v", err)
+	}
+	if stats := p.Stats(); stats.Active != 0 || stats.Restarts != 1 {
+		t.Errorf("want no active member and 1 restart but got %+v", stats)
+	}
+}
+
+func TestPool_restartNever(t *testing.T) {
+	p := start(t, &pool.Config{Size: 1, Restart: pool.RestartNever}, newMember(t, false, nil))
+	_, err := p.Call(testModuleID, methodID_Guest_Fail, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Wait()
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Errorf("want exit code 1 but got %v", err)
+	}
+	_, err = p.Call(testModuleID, methodID_Guest_ID, &message.Any{})
+	if !errors.Is(err, pool.ErrNoMember) {
+		t.Errorf("want ErrNoMember but got %v", err)
+	}
+}
+
+func TestPool_restartDefault(t *testing.T) {
+	p := start(t, &pool.Config{Size: 1}, newMember(t, false, nil))
+	_, err := p.Call(testModuleID, methodID_Guest_Exit, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Wait()
+	if err != nil {
+		t.Errorf("want nil but got %v", err)
+	}
+	if stats := p.Stats(); stats.Active != 0 || stats.Restarts != 0 {
+		t.Errorf("want no active member and no restart but got %+v", stats)
+	}
+}
+
+func TestPool_crashLoop(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	p := start(t, &pool.Config{
+		Size:          1,
+		Restart:       pool.RestartAlways,
+		Backoff:       10 * time.Millisecond,
+		MaxRestarts:   3,
+		RestartWindow: time.Minute,
+	}, func(m *pool.Member) (*runtime.Runtime, error) {
+		g := runtime.NewGoroutineGuest(func(context.Context, runtime.Stream) int {
+			return 1
+		})
+		return runtime.NewRuntime(logger, g), nil
+	})
+	began := time.Now()
+	err := p.Wait()
+	if !errors.Is(err, pool.ErrCrashLoop) {
+		t.Errorf("want ErrCrashLoop but got %v", err)
+	}
+	// 10ms, 20ms and 40ms
+	if elapsed := time.Since(began); elapsed < 70*time.Millisecond {
+		t.Errorf("want backoff but restarted in %v", elapsed)
+	}
+	if stats := p.Stats(); stats.Restarts != 3 || stats.Failures != 4 {
+		t.Errorf("want 3 restarts and 4 failures but got %+v", stats)
+	}
+}
+
+func TestPool_HTTPListeners(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened atomic.Int32
+	p := start(t, &pool.Config{
+		Size:        1,
+		Restart:     pool.RestartAlways,
+		WaitTimeout: 5 * time.Second,
+		HTTPListeners: map[string]expimpl.HttpListenerConfig{
+			"default": {Ctor: func(string) (net.Listener, error) {
+				opened.Add(1)
+				return lis, nil
+			}},
+		},
+	}, newMember(t, true, nil))
+
+	get := func() string {
+		resp, err := http.Get("http://" + lis.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	// The listener is opened once the member listens.
+	waitForMember(t, p, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for opened.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the listener is not opened")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if body := get(); body != "0" {
+		t.Errorf("want 0 but got %q", body)
+	}
+
+	_, err = p.Call(testModuleID, methodID_Guest_Fail, &message.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForMember(t, p, 1)
+	// The request waits for the restarted member to listen.
+	if body := get(); body != "1" {
+		t.Errorf("want 1 but got %q", body)
+	}
+	if n := opened.Load(); n != 1 {
+		t.Errorf("want the listener to be opened once but got %d", n)
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, r := range []pool.RestartPolicy{pool.RestartNever, pool.RestartOnFailure, pool.RestartAlways} {
+		got, err := pool.ParseRestartPolicy(r.String())
+		if err != nil || got != r {
+			t.Errorf("want %v but got %v, %v", r, got, err)
+		}
+	}
+	_, err := pool.ParseRestartPolicy("sometimes")
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+}
@@ -0,0 +1,18 @@
+package wasm
+
+import (
+	"path/filepath"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/lang"
+)
+
+func init() {
+	lang.Register(&lang.Language{
+		Name:       "wasm",
+		Extensions: []string{".wasm"},
+		New: func(path string, src []byte, args []string, _ []runtime.ProcessOption) (runtime.Guest, error) {
+			return NewGuest(src, WithArgs(filepath.Base(path), args...))
+		},
+	})
+}
@@ -0,0 +1,268 @@
+// Package wasm runs WebAssembly modules as in-process guests on wazero, a pure-Go Wasm runtime.
+//
+// A module talks ELRPC in either of the following ways:
+//
+//   - WASI stdio: the module reads responses from stdin and writes requests to stdout, just like a process guest.
+//     Programs built for WASI, such as the ones built with GOOS=wasip1, work as they are.
+//   - Host functions: the module imports the functions below from the module named HostModuleName.
+//     Its stdin and stdout are then free for other uses; see WithStdio.
+//
+// The host functions are:
+//
+//	read(ptr i32, len i32) -> i32   reads at most len bytes into memory at ptr, and returns the number of bytes read,
+//	                                0 at EOF, or -1 on error
+//	write(ptr i32, len i32) -> i32  writes len bytes at ptr, and returns len, or -1 on error
+//
+// Modules are sandboxed by Wasm itself: they cannot access the host's file system or network except through ELRPC.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/genkami/elsi/elrpc/runtime"
+)
+
+// HostModuleName is the name of the module that provides the host functions.
+const HostModuleName = "elsi"
+
+// The exit codes of Wasm guests that do not exit by themselves.
+const (
+	ExitCodeOK    = 0
+	ExitCodeError = 1
+)
+
+// The size of a Wasm memory page.
+const PageSize = 65536
+
+var ErrNoStartFunction = errors.New("wasm: module does not export _start")
+
+type config struct {
+	name     string
+	args     []string
+	env      [][2]string
+	stdin    io.Reader
+	stdout   io.Writer
+	stdioSet bool
+	stderr   io.Writer
+	// The limit in pages, which is valid if memoryLimitSet is true.
+	memoryLimit    uint64
+	memoryLimitSet bool
+}
+
+type Option func(*config)
+
+// WithArgs sets the command-line arguments of the module, following its name.
+func WithArgs(name string, args ...string) Option {
+	return func(c *config) {
+		c.name = name
+		c.args = args
+	}
+}
+
+// WithEnv sets an environment variable of the module. The module does not inherit the host's environment.
+func WithEnv(key, value string) Option {
+	return func(c *config) {
+		c.env = append(c.env, [2]string{key, value})
+	}
+}
+
+// WithStdio sets the module's stdin and stdout. It requires the module to import the host functions,
+// because stdio is used by ELRPC otherwise.
+func WithStdio(stdin io.Reader, stdout io.Writer) Option {
+	return func(c *config) {
+		c.stdioSet = true
+		c.stdin = stdin
+		c.stdout = stdout
+	}
+}
+
+// WithStderr sends the module's stderr to w. It defaults to the host's stderr.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) {
+		c.stderr = w
+	}
+}
+
+// WithMemoryLimit limits the memory of the module to the given number of bytes, which is rounded down to pages.
+// NewGuest fails if the limit is less than a page.
+func WithMemoryLimit(bytes uint64) Option {
+	return func(c *config) {
+		pages := bytes / PageSize
+		if pages > 65536 {
+			pages = 65536
+		}
+		c.memoryLimit = pages
+		c.memoryLimitSet = true
+	}
+}
+
+// Guest runs a Wasm module in a goroutine.
+type Guest struct {
+	*runtime.GoroutineGuest
+	conf     config
+	rt       wazero.Runtime
+	compiled wazero.CompiledModule
+	// Whether the module imports the host functions.
+	hostFuncs bool
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ runtime.Guest = (*Guest)(nil)
+
+// NewGuest compiles the Wasm binary. The module must export _start, which is called when the guest starts.
+func NewGuest(binary []byte, opts ...Option) (*Guest, error) {
+	g := &Guest{conf: config{name: "main", stderr: os.Stderr}}
+	for _, opt := range opts {
+		opt(&g.conf)
+	}
+
+	ctx := context.Background()
+	rtConf := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if g.conf.memoryLimitSet {
+		if g.conf.memoryLimit == 0 {
+			return nil, fmt.Errorf("wasm: memory limit must be at least %d bytes", PageSize)
+		}
+		rtConf = rtConf.WithMemoryLimitPages(uint32(g.conf.memoryLimit))
+	}
+	g.rt = wazero.NewRuntimeWithConfig(ctx, rtConf)
+	compiled, err := g.rt.CompileModule(ctx, binary)
+	if err != nil {
+		g.rt.Close(ctx)
+		return nil, fmt.Errorf("wasm: %w", err)
+	}
+	g.compiled = compiled
+	if _, ok := compiled.ExportedFunctions()["_start"]; !ok {
+		g.rt.Close(ctx)
+		return nil, ErrNoStartFunction
+	}
+	for _, f := range compiled.ImportedFunctions() {
+		moduleName, _, _ := f.Import()
+		if moduleName == HostModuleName {
+			g.hostFuncs = true
+		}
+	}
+	if g.conf.stdioSet && !g.hostFuncs {
+		g.rt.Close(ctx)
+		return nil, errors.New("wasm: WithStdio requires the module to import the host functions")
+	}
+
+	g.GoroutineGuest = runtime.NewGoroutineGuest(g.run)
+	return g, nil
+}
+
+func (g *Guest) run(ctx context.Context, s runtime.Stream) int {
+	// The runtime cannot be reused because the module is instantiated with a fixed name.
+	defer g.rt.Close(context.Background())
+	err := g.instantiate(ctx, s)
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return int(exitErr.ExitCode())
+	}
+	if err != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.err = err
+		return ExitCodeError
+	}
+	return ExitCodeOK
+}
+
+func (g *Guest) instantiate(ctx context.Context, s runtime.Stream) error {
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, g.rt)
+	if err != nil {
+		return err
+	}
+	modConf := wazero.NewModuleConfig().
+		WithArgs(append([]string{g.conf.name}, g.conf.args...)...).
+		WithStderr(g.conf.stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, kv := range g.conf.env {
+		modConf = modConf.WithEnv(kv[0], kv[1])
+	}
+	if g.hostFuncs {
+		err := g.instantiateHostModule(ctx, s)
+		if err != nil {
+			return err
+		}
+		if g.conf.stdin != nil {
+			modConf = modConf.WithStdin(g.conf.stdin)
+		}
+		if g.conf.stdout != nil {
+			modConf = modConf.WithStdout(g.conf.stdout)
+		}
+	} else {
+		modConf = modConf.WithStdin(s).WithStdout(s)
+	}
+	mod, err := g.rt.InstantiateModule(ctx, g.compiled, modConf)
+	if err != nil {
+		return err
+	}
+	return mod.Close(ctx)
+}
+
+func (g *Guest) instantiateHostModule(ctx context.Context, s runtime.Stream) error {
+	_, err := g.rt.NewHostModuleBuilder(HostModuleName).
+		NewFunctionBuilder().
+		WithFunc(func(_ context.Context, m api.Module, ptr, n uint32) int32 {
+			buf, ok := m.Memory().Read(ptr, n)
+			if !ok {
+				return -1
+			}
+			read, err := s.Read(buf)
+			if read == 0 && errors.Is(err, io.EOF) {
+				return 0
+			}
+			if read == 0 && err != nil {
+				return -1
+			}
+			return int32(read)
+		}).
+		Export("read").
+		NewFunctionBuilder().
+		WithFunc(func(_ context.Context, m api.Module, ptr, n uint32) int32 {
+			buf, ok := m.Memory().Read(ptr, n)
+			if !ok {
+				return -1
+			}
+			_, err := s.Write(buf)
+			if err != nil {
+				return -1
+			}
+			return int32(n)
+		}).
+		Export("write").
+		Instantiate(ctx)
+	return err
+}
+
+// Wait waits for the module to exit. It returns the error that stopped the module, such as a trap, if any.
+func (g *Guest) Wait() error {
+	err := g.GoroutineGuest.Wait()
+	if wasmErr := g.Err(); wasmErr != nil {
+		return wasmErr
+	}
+	return err
+}
+
+// Err returns the error that stopped the module, if any.
+func (g *Guest) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
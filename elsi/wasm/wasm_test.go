@@ -0,0 +1,323 @@
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/genkami/elsi/elrpc/apibuilder"
+	"github.com/genkami/elsi/elrpc/message"
+	"github.com/genkami/elsi/elrpc/runtime"
+	"github.com/genkami/elsi/elsi/wasm"
+	"golang.org/x/exp/slog"
+)
+
+// The opcodes used by the test modules.
+const (
+	opUnreachable = 0x00
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opDrop        = 0x1a
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opI32Load     = 0x28
+	opI32Load8U   = 0x2d
+	opI32Store    = 0x36
+	opI32Const    = 0x41
+	opI32Ne       = 0x47
+	opI32LeS      = 0x4c
+	opI32GeS      = 0x4e
+	opI32Add      = 0x6a
+	opI32Sub      = 0x6b
+
+	blockEmpty = 0x40
+	valI32     = 0x7f
+)
+
+func uleb(n uint32) []byte {
+	var buf []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func sleb(n int32) []byte {
+	var buf []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	buf := uleb(uint32(len(items)))
+	for _, item := range items {
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+func name(s string) []byte {
+	return append(uleb(uint32(len(s))), s...)
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint32(len(content)))...), content...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func i32Const(n int32) []byte {
+	return append([]byte{opI32Const}, sleb(n)...)
+}
+
+// The types of the test modules.
+var types = vec(
+	// 0: fd_read and fd_write
+	[]byte{0x60, 4, valI32, valI32, valI32, valI32, 1, valI32},
+	// 1: read and write
+	[]byte{0x60, 2, valI32, valI32, 1, valI32},
+	// 2: proc_exit
+	[]byte{0x60, 1, valI32, 0},
+	// 3: _start
+	[]byte{0x60, 0, 0},
+)
+
+type function struct {
+	typ    uint32
+	locals uint32
+	body   []byte
+}
+
+type importedFunc struct {
+	module, name string
+	typ          uint32
+}
+
+// module assembles a module that exports memory and its last function as _start.
+func module(imports []importedFunc, funcs []function, data []byte) []byte {
+	var importEntries, funcTypes, bodies [][]byte
+	for _, imp := range imports {
+		importEntries = append(importEntries, concat(name(imp.module), name(imp.name), []byte{0x00}, uleb(imp.typ)))
+	}
+	for _, f := range funcs {
+		funcTypes = append(funcTypes, uleb(f.typ))
+		locals := vec()
+		if f.locals > 0 {
+			locals = vec(concat(uleb(f.locals), []byte{valI32}))
+		}
+		body := concat(locals, f.body, []byte{opEnd})
+		bodies = append(bodies, concat(uleb(uint32(len(body))), body))
+	}
+	start := uint32(len(imports) + len(funcs) - 1)
+	return concat(
+		[]byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00},
+		section(1, types),
+		section(2, vec(importEntries...)),
+		section(3, vec(funcTypes...)),
+		section(5, vec([]byte{0x00, 1})),
+		section(7, vec(
+			concat(name("memory"), []byte{0x02}, uleb(0)),
+			concat(name("_start"), []byte{0x00}, uleb(start)),
+		)),
+		section(10, vec(bodies...)),
+		section(11, vec(concat([]byte{0x00}, i32Const(0), []byte{opEnd}, uleb(uint32(len(data))), data))),
+	)
+}
+
+// The memory layout of the test modules.
+const (
+	addrIOVec  = 0
+	addrNBytes = 8
+	addrReq    = 16
+	addrResp   = 1024
+)
+
+// callOnce returns the body of _start that sends req, and reads a response of respLen bytes into addrResp.
+// write and read are the indices of the functions of type 1.
+func callOnce(write, read uint32, reqLen, respLen int32) []byte {
+	// local 0: the number of bytes read so far, local 1: the return value of read
+	return concat(
+		i32Const(addrReq), i32Const(reqLen), []byte{opCall}, uleb(write), []byte{opDrop},
+		[]byte{opBlock, blockEmpty, opLoop, blockEmpty},
+		[]byte{opLocalGet, 0}, i32Const(respLen), []byte{opI32GeS, opBrIf, 1},
+		i32Const(addrResp), []byte{opLocalGet, 0, opI32Add},
+		i32Const(respLen), []byte{opLocalGet, 0, opI32Sub},
+		[]byte{opCall}, uleb(read), []byte{opLocalTee, 1},
+		i32Const(0), []byte{opI32LeS, opIf, blockEmpty, opUnreachable, opEnd},
+		[]byte{opLocalGet, 0, opLocalGet, 1, opI32Add, opLocalSet, 0},
+		[]byte{opBr, 0, opEnd, opEnd},
+	)
+}
+
+// wasiIO returns a function of type 1 that calls fd_read or fd_write.
+func wasiIO(fdFunc uint32, fd int32) function {
+	return function{typ: 1, body: concat(
+		i32Const(addrIOVec), []byte{opLocalGet, 0, opI32Store, 2, 0},
+		i32Const(addrIOVec+4), []byte{opLocalGet, 1, opI32Store, 2, 0},
+		i32Const(fd), i32Const(addrIOVec), i32Const(1), i32Const(addrNBytes), []byte{opCall}, uleb(fdFunc),
+		[]byte{opIf, blockEmpty}, i32Const(-1), []byte{opReturn, opEnd},
+		i32Const(addrNBytes), []byte{opI32Load, 2, 0},
+	)}
+}
+
+func frame(t *testing.T, m ...message.Message) []byte {
+	t.Helper()
+	enc := message.NewEncoder()
+	for _, x := range m {
+		err := x.MarshalELRPC(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := message.AppendLength(nil, len(enc.Buffer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(buf, enc.Buffer()...)
+}
+
+const modID, methodID = 0x0000_ffff, 0x0000_0001
+
+// request returns the request that the test modules send, and the length of the response with a Uint8.
+func request(t *testing.T) ([]byte, int32) {
+	req := frame(t, &message.Uint32{Value: modID}, &message.Uint32{Value: methodID}, &message.String{Value: "hi"})
+	resp := frame(t, &message.Result[*message.Uint8, *message.Error]{IsOk: true, Ok: &message.Uint8{Value: 0}})
+	return req, int32(len(resp))
+}
+
+func runGuest(t *testing.T, guest *wasm.Guest) (*runtime.Runtime, string, error) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	rt := runtime.NewRuntime(logger, guest)
+	var got string
+	rt.Use(modID, methodID, apibuilder.HostHandler1[*message.String, *message.Uint8](func(s *message.String) (*message.Uint8, error) {
+		got = s.Value
+		return &message.Uint8{Value: 42}, nil
+	}))
+	err := rt.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt, got, rt.Wait()
+}
+
+func TestGuest_wasi(t *testing.T) {
+	req, respLen := request(t)
+	// It exits with the value in the response.
+	start := concat(
+		callOnce(3, 4, int32(len(req)), respLen),
+		i32Const(addrResp+respLen-1), []byte{opI32Load8U, 0, 0}, []byte{opCall}, uleb(2),
+	)
+	bin := module(
+		[]importedFunc{
+			{"wasi_snapshot_preview1", "fd_write", 0},
+			{"wasi_snapshot_preview1", "fd_read", 0},
+			{"wasi_snapshot_preview1", "proc_exit", 2},
+		},
+		[]function{wasiIO(0, 1), wasiIO(1, 0), {typ: 3, locals: 2, body: start}},
+		append(make([]byte, addrReq), req...),
+	)
+	guest, err := wasm.NewGuest(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := runGuest(t, guest)
+	var exitErr *runtime.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 42 {
+		t.Errorf("want exit code 42 but got %v", err)
+	}
+	if got != "hi" {
+		t.Errorf("want hi but got %q", got)
+	}
+}
+
+func TestGuest_hostFunctions(t *testing.T) {
+	req, respLen := request(t)
+	// It traps unless the response has the expected value.
+	start := concat(
+		callOnce(0, 1, int32(len(req)), respLen),
+		i32Const(addrResp+respLen-1), []byte{opI32Load8U, 0, 0}, i32Const(42),
+		[]byte{opI32Ne, opIf, blockEmpty, opUnreachable, opEnd},
+	)
+	bin := module(
+		[]importedFunc{
+			{wasm.HostModuleName, "write", 1},
+			{wasm.HostModuleName, "read", 1},
+		},
+		[]function{{typ: 3, locals: 2, body: start}},
+		append(make([]byte, addrReq), req...),
+	)
+	guest, err := wasm.NewGuest(bin, wasm.WithStdio(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := runGuest(t, guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hi" {
+		t.Errorf("want hi but got %q", got)
+	}
+}
+
+func TestGuest_kill(t *testing.T) {
+	bin := module(nil, []function{{typ: 3, body: []byte{opLoop, blockEmpty, opBr, 0, opEnd}}}, nil)
+	guest, err := wasm.NewGuest(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, guest.Kill)
+	_, _, err = runGuest(t, guest)
+	if err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestNewGuest_invalid(t *testing.T) {
+	_, err := wasm.NewGuest([]byte("not a wasm module"))
+	if err == nil {
+		t.Error("want error for an invalid module")
+	}
+
+	bin := module(nil, []function{{typ: 3}}, nil)
+	// Remove the export of _start.
+	bin = bytes.Replace(bin, []byte("_start"), []byte("_stop_"), 1)
+	_, err = wasm.NewGuest(bin)
+	if !errors.Is(err, wasm.ErrNoStartFunction) {
+		t.Errorf("want ErrNoStartFunction but got %v", err)
+	}
+
+	_, err = wasm.NewGuest(module(nil, []function{{typ: 3}}, nil), wasm.WithStdio(nil, nil))
+	if err == nil {
+		t.Error("want error for WithStdio without the host functions")
+	}
+	_, err = wasm.NewGuest(module(nil, []function{{typ: 3}}, nil), wasm.WithMemoryLimit(wasm.PageSize-1))
+	if err == nil {
+		t.Error("want error for a memory limit less than a page")
+	}
+	_, err = wasm.NewGuest(module(nil, []function{{typ: 3}}, nil), wasm.WithMemoryLimit(wasm.PageSize))
+	if err != nil {
+		t.Errorf("want no error for a memory limit of a page but got %v", err)
+	}
+}
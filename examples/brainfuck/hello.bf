@@ -0,0 +1,71 @@
+Prints Hello world through the elsi exp module
+
+Stdio OpenStdHandle stdout
+.
+.
+.
+.
+.
+.
+.
+++++++++++++.[-]
++++.[-]
+.
+.
+.
++.[-]
++++.[-]
+.
+.
+.
+++++++++++++++++++++++++++++++++.[-]
++.[-]
++.[-]
+Read the response into cells 0 to 18 where the handle ID is in cells 11 to 18
+,>,>,>,>,>,>,>,>,>,>,>,>,>,>,>,>,>,>,>
+Stream Write handle data using cell 19 as scratch
+.
+.
+.
+.
+.
+.
+.
+++++++++++++++++++++++++++++++++++++++++++.[-]
++++.[-]
+.
+.
+.
++.[-]
++++.[-]
+.
+.
+.
++.[-]
+++++.[-]
+<<<<<<<<.>.>.>.>.>.>.>.>
++++++++++.[-]
+.
+.
+.
+.
+.
+.
+.
+++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++.[-]
++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++.[-]
++++++++++++++++++++++++++++++++++.[-]
+++++++++++.[-]
+Consume the response
+,,,,,,,,,,,,,,,,,,,
@@ -7,3 +7,5 @@ require (
 	golang.org/x/exp v0.0.0-20230626212559-97b1e661b5df
 	golang.org/x/sync v0.3.0
 )
+
+require github.com/tetratelabs/wazero v1.7.3